@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// BatchReport lists each of the URIs requested with -uriFile and whether all
+// of the runs for it succeeded, followed by a count of those that did.
+func BatchReport(targets []*target) string {
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"URI", "Runs", "Failed", "Result"})
+	succeeded := 0
+	for _, tg := range targets {
+		failed := 0
+		for _, err := range tg.errs {
+			if err != nil {
+				failed++
+			}
+		}
+		result := "failed"
+		if failed == 0 {
+			succeeded++
+			result = "ok"
+		}
+		t.Append([]string{tg.uri,
+			fmt.Sprintf("%d", len(tg.runs)),
+			fmt.Sprintf("%d", failed),
+			result})
+	}
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+	fmt.Fprintf(tw, "%d of %d URIs succeeded, %d failed\n", succeeded, len(targets), len(targets)-succeeded)
+	return tw.String()
+}
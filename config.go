@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Set any flags that weren't given on the command line or in the environment
+// from a -config file, so that those always take precedence. The file is a
+// JSON object or a YAML mapping keyed by flag name, with a list for a flag
+// that may be given more than once, such as header. A list for any other flag
+// is joined with commas, which suits -reporters and the like. Every key that
+// isn't a flag is reported, as they're most likely typos.
+func applyConfig(fs *flag.FlagSet, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var settings map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		settings, err = parseConfigJSON(b)
+	case ".yaml", ".yml":
+		settings, err = parseYAML(b)
+	default:
+		if bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+			settings, err = parseConfigJSON(b)
+		} else {
+			settings, err = parseYAML(b)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Couldn't read %s: %s", path, err)
+	}
+
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	keys := []string{}
+	unknown := []string{}
+	for k := range settings {
+		if fs.Lookup(k) == nil {
+			unknown = append(unknown, k)
+		}
+		keys = append(keys, k)
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("Unknown settings in %s: %s", path, strings.Join(unknown, ", "))
+	}
+	if _, ok := settings["config"]; ok {
+		return fmt.Errorf("%s can't itself set config", path)
+	}
+
+	sort.Strings(keys)
+	for _, k := range keys {
+		if given[k] {
+			continue
+		}
+		f := fs.Lookup(k)
+		values, err := configValues(settings[k])
+		if err != nil {
+			return fmt.Errorf("Invalid value for %s in %s: %s", k, path, err)
+		}
+		if r, ok := f.Value.(repeatableFlag); !ok || !r.Repeatable() {
+			values = []string{strings.Join(values, ",")}
+		}
+		for _, v := range values {
+			if err := fs.Set(k, v); err != nil {
+				return fmt.Errorf("Invalid value '%s' for %s in %s: %s", v, k, path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Parse a -config file in JSON, which must be a single object
+func parseConfigJSON(b []byte) (map[string]interface{}, error) {
+	var settings map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&settings); err != nil {
+		return nil, err
+	}
+	if settings == nil {
+		return nil, errors.New("Expected an object of settings")
+	}
+	return settings, nil
+}
+
+// Return the value of a setting as the strings to give its flag, one for each
+// entry if it's a list
+func configValues(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case []interface{}:
+		ret := []string{}
+		for _, e := range v {
+			s, err := configScalar(e)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, s)
+		}
+		return ret, nil
+	default:
+		s, err := configScalar(v)
+		if err != nil {
+			return nil, err
+		}
+		return []string{s}, nil
+	}
+}
+
+// Return a single value of a setting as a string
+func configScalar(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case nil:
+		return "", nil
+	default:
+		return "", errors.New("Expected a string, number, boolean or list of them")
+	}
+}
+
+// Parse a -config file in YAML. Only what's needed for settings is
+// supported: a mapping of keys to plain or quoted values, or to lists of them
+// either as "- item" lines or inline in brackets, and comments. Everything
+// comes out as strings, as the flags parse the values themselves.
+func parseYAML(b []byte) (map[string]interface{}, error) {
+	settings := map[string]interface{}{}
+	list := ""
+	for i, line := range strings.Split(string(b), "\n") {
+		line = stripYAMLComment(strings.TrimRight(line, " \t\r"))
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+
+		// An entry in the list for the key before
+		if trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+			if list == "" {
+				return nil, fmt.Errorf("Line %d: a list entry without a key", i+1)
+			}
+			v, err := yamlScalar(strings.TrimSpace(trimmed[1:]))
+			if err != nil {
+				return nil, fmt.Errorf("Line %d: %s", i+1, err)
+			}
+			settings[list] = append(settings[list].([]interface{}), v)
+			continue
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, fmt.Errorf("Line %d: nested settings aren't supported", i+1)
+		}
+		k, v, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return nil, fmt.Errorf("Line %d: expected 'key: value'", i+1)
+		}
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if _, ok := settings[k]; ok {
+			return nil, fmt.Errorf("Line %d: %s is set more than once", i+1, k)
+		}
+		list = ""
+		switch {
+		case v == "":
+			// The start of a list on the lines that follow
+			settings[k] = []interface{}{}
+			list = k
+		case strings.HasPrefix(v, "["):
+			if !strings.HasSuffix(v, "]") {
+				return nil, fmt.Errorf("Line %d: a list must end with ] on the same line", i+1)
+			}
+			values := []interface{}{}
+			for _, e := range splitYAMLList(v[1 : len(v)-1]) {
+				s, err := yamlScalar(e)
+				if err != nil {
+					return nil, fmt.Errorf("Line %d: %s", i+1, err)
+				}
+				values = append(values, s)
+			}
+			settings[k] = values
+		case strings.HasPrefix(v, "{"), strings.HasPrefix(v, "|"), strings.HasPrefix(v, ">"):
+			return nil, fmt.Errorf("Line %d: only plain values and lists are supported", i+1)
+		default:
+			s, err := yamlScalar(v)
+			if err != nil {
+				return nil, fmt.Errorf("Line %d: %s", i+1, err)
+			}
+			settings[k] = s
+		}
+	}
+	return settings, nil
+}
+
+// Remove a comment from the end of a line of YAML, which starts with a # at
+// the start of the line or after a space, outside of any quotes
+func stripYAMLComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// Split the entries of an inline YAML list on commas outside of any quotes
+func splitYAMLList(s string) []string {
+	ret := []string{}
+	if strings.TrimSpace(s) == "" {
+		return ret
+	}
+	var quote rune
+	start := 0
+	for i, c := range s {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			ret = append(ret, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(ret, strings.TrimSpace(s[start:]))
+}
+
+// Return a YAML value without any quotes around it
+func yamlScalar(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("Bad quoted value %s", s)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("Bad quoted value %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	return s, nil
+}
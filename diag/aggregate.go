@@ -0,0 +1,133 @@
+package diag
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// Summary describes the spread of a set of samples
+type Summary struct {
+	Count  int
+	Min    float64
+	Max    float64
+	Mean   float64
+	StdDev float64
+	P50    float64
+	P90    float64
+	P99    float64
+}
+
+// Return the p'th percentile (0-100) of an already sorted set of samples,
+// using the nearest-rank method.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// Summarise a set of samples. An empty set gives an empty summary.
+func Summarise(samples []float64) (ret Summary) {
+	if len(samples) == 0 {
+		return
+	}
+	ret.Count = len(samples)
+	ret.Min = samples[0]
+	ret.Max = samples[0]
+	total := float64(0)
+	for _, v := range samples {
+		ret.Min = math.Min(ret.Min, v)
+		ret.Max = math.Max(ret.Max, v)
+		total += v
+	}
+	ret.Mean = total / float64(ret.Count)
+
+	variance := float64(0)
+	for _, v := range samples {
+		variance += (v - ret.Mean) * (v - ret.Mean)
+	}
+	ret.StdDev = math.Sqrt(variance / float64(ret.Count))
+
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+	ret.P50 = percentile(sorted, 50)
+	ret.P90 = percentile(sorted, 90)
+	ret.P99 = percentile(sorted, 99)
+	return
+}
+
+// Return the time between two timestamps in seconds, and whether both were
+// actually recorded. For the main phases of a request, use PhaseDuration.
+func phaseSeconds(e int64, s int64) (float64, bool) {
+	if e == 0 || s == 0 {
+		return 0, false
+	}
+	return nsSeconds(e - s), true
+}
+
+// AggregateReport summarises the key timings and throughput across a number
+// of runs as a table.
+func AggregateReport(runs []*StatsCollector) string {
+	var dns, conn, tls, ttfb, rate []float64
+	for _, s := range runs {
+		if d, ok := s.PhaseDuration(PhaseDns); ok {
+			dns = append(dns, d.Seconds())
+		}
+		if d, ok := s.PhaseDuration(PhaseConnect); ok {
+			conn = append(conn, d.Seconds())
+		}
+		if d, ok := s.PhaseDuration(PhaseTls); ok {
+			tls = append(tls, d.Seconds())
+		}
+		if d, ok := s.PhaseDuration(PhaseFirstByte); ok {
+			ttfb = append(ttfb, d.Seconds())
+		}
+		if s.DurationNS() > 0 {
+			rate = append(rate, s.KBPerSecond())
+		}
+	}
+
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"", "Samples", "Min", "P50", "P90", "P99", "Max", "Mean", "Std Dev"})
+	for _, row := range []struct {
+		name    string
+		samples []float64
+		format  func(float64) string
+	}{
+		{withUnit("DNS Lookup", "s"), dns, fmtSeconds},
+		{withUnit("Connection", "s"), conn, fmtSeconds},
+		{withUnit("TLS", "s"), tls, fmtSeconds},
+		{withUnit("First Byte", "s"), ttfb, fmtSeconds},
+		{withUnit("Throughput", "kB/s"), rate, fmtRate},
+	} {
+		sum := Summarise(row.samples)
+		if sum.Count == 0 {
+			t.Append([]string{row.name, "0", "-", "-", "-", "-", "-", "-", "-"})
+			continue
+		}
+		t.Append([]string{
+			row.name,
+			fmt.Sprintf("%d", sum.Count),
+			row.format(sum.Min),
+			row.format(sum.P50),
+			row.format(sum.P90),
+			row.format(sum.P99),
+			row.format(sum.Max),
+			row.format(sum.Mean),
+			row.format(sum.StdDev),
+		})
+	}
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+	return tw.String()
+}
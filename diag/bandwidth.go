@@ -0,0 +1,97 @@
+package diag
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// BandwidthReporter compares the rate the body arrived at once it got going
+// with the rate counting the time it took to get the response started. For a
+// small response from a distant gateway, the setup can take far longer than
+// the transfer, so a low end to end rate can be down to latency rather than
+// a lack of bandwidth.
+type BandwidthReporter struct{}
+
+func (r BandwidthReporter) Name() string {
+	return "Bandwidth"
+}
+
+func (r BandwidthReporter) Title() string {
+	return "Effective Bandwidth"
+}
+
+func (r BandwidthReporter) Description() string {
+	return "Compares the steady state rate of the body with the end to end rate including setup"
+}
+
+func (r BandwidthReporter) check(s *StatsCollector) error {
+	if s.TransferSkipped {
+		return errors.New("The body wasn't read, as -ttfbOnly was given")
+	}
+	if s.FirstByteTime == 0 || s.EndTime == 0 {
+		return errors.New("The response never arrived")
+	}
+	return nil
+}
+
+// Return the time taken to get to the first byte, and to transfer from there
+// to the last, in seconds. check makes sure both were recorded.
+func (r BandwidthReporter) times(s *StatsCollector) (float64, float64) {
+	setup, _ := phaseSeconds(s.FirstByteTime, s.Total.StartTime)
+	transfer, _ := phaseSeconds(s.EndTime, s.FirstByteTime)
+	return setup, transfer
+}
+
+func (r BandwidthReporter) Report(s *StatsCollector) (ret string, e error) {
+	if err := r.check(s); err != nil {
+		return "", err
+	}
+	setup, transfer := r.times(s)
+
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"", withUnit("Time", "s"), withUnit("Rate", "kB/s")})
+	t.Append([]string{"To first byte", fmtSeconds(setup), "-"})
+	t.Append([]string{"First to last byte", fmtSeconds(transfer),
+		fmtRate(s.SteadyKBPerSecond())})
+	total := "n/a"
+	if d, ok := s.PhaseDuration(PhaseTotal); ok {
+		total = fmtDuration(d)
+	}
+	t.Append([]string{"End to end", total, fmtRate(s.EndToEndKBPerSecond())})
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+
+	if setup+transfer > 0 {
+		share := setup / (setup + transfer) * 100
+		if setup > transfer {
+			tw.Write([]byte(fmt.Sprintf("Setup took %.1f%% of the time, so latency held the rate back more than bandwidth\n", share)))
+		} else {
+			tw.Write([]byte(fmt.Sprintf("Setup took %.1f%% of the time, so the rate was mostly down to bandwidth\n", share)))
+		}
+	}
+	if s.Session.Reused {
+		tw.Write([]byte("The connection was reused, so the setup didn't include making a new one\n"))
+	}
+	ret = tw.String()
+	return
+}
+
+func (r BandwidthReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	if err := r.check(s); err != nil {
+		return nil, err
+	}
+	setup, transfer := r.times(s)
+	return map[string]interface{}{
+		"Setup":      setup,
+		"Transfer":   transfer,
+		"Steady":     s.SteadyKBPerSecond(),
+		"EndToEnd":   s.EndToEndKBPerSecond(),
+		"Bytes":      s.TotalBytesTransferred(),
+		"SetupShare": setup / (setup + transfer) * 100,
+	}, nil
+}
@@ -0,0 +1,148 @@
+package diag
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// How many buckets the latency histogram has, and how wide its bars can be
+const (
+	benchBuckets  = 10
+	benchBarWidth = 40
+)
+
+// Return the latency of a request in seconds, from starting it to the first
+// byte of the response, so including any DNS lookup, connection and TLS
+// handshake it needed, and whether it got that far
+func benchLatency(s *StatsCollector) (float64, bool) {
+	return phaseSeconds(s.FirstByteTime, s.Total.StartTime)
+}
+
+// Return a short description of why a request failed, which is its status if
+// the server answered with one we treat as a failure, and where it got stuck
+// if it timed out
+func benchFailure(s *StatsCollector, err error) string {
+	code := ExitCode(err)
+	if code == ExitStatus && s.StatusCode != 0 {
+		return fmt.Sprintf("status %d", s.StatusCode)
+	}
+	if s.StoppedDuring != "" && code != ExitInterrupted {
+		// Timeouts are told apart by where they got stuck
+		return "timeout (" + s.StoppedDuring + ")"
+	}
+	if name, ok := failureNames[code]; ok {
+		return name
+	}
+	return "request"
+}
+
+// Draw a histogram of samples with n buckets of equal width from the lowest
+// to the highest, one line each, labelled with the top of the bucket
+func histogram(samples []float64, n int) string {
+	tw := &strings.Builder{}
+	sum := Summarise(samples)
+	if sum.Count == 0 {
+		return ""
+	}
+	width := (sum.Max - sum.Min) / float64(n)
+	if width == 0 {
+		// They're all the same, so there's just the one bucket
+		n = 1
+	}
+	counts := make([]int, n)
+	for _, v := range samples {
+		i := n - 1
+		if width > 0 {
+			i = int((v - sum.Min) / width)
+		}
+		if i >= n {
+			// The highest belongs in the last bucket
+			i = n - 1
+		}
+		counts[i]++
+	}
+	peak := 0
+	for _, c := range counts {
+		if c > peak {
+			peak = c
+		}
+	}
+	// Line the bars up whatever the counts
+	label := len(fmt.Sprintf("[%d]", peak))
+	top := 0
+	for i := range counts {
+		if l := len([]rune(fmtSeconds(sum.Min + width*float64(i+1)))); l > top {
+			top = l
+		}
+	}
+	for i, c := range counts {
+		fmt.Fprintf(tw, "  %-*s %-*s |%s\n", top, fmtSeconds(sum.Min+width*float64(i+1)), label,
+			fmt.Sprintf("[%d]", c), strings.Repeat("■", c*benchBarWidth/peak))
+	}
+	return tw.String()
+}
+
+// BenchReport summarises the requests made with -bench: the rate they were
+// made at, how many failed and why, and the spread of their latencies to the
+// first byte, as a table and a histogram.
+func BenchReport(runs []*StatsCollector, errs []error, elapsed time.Duration, workers int) string {
+	tw := &strings.Builder{}
+	failures := map[string]int{}
+	failed := 0
+	var latencies []float64
+	for i, s := range runs {
+		if errs[i] != nil {
+			failed++
+			failures[benchFailure(s, errs[i])]++
+		}
+		if l, ok := benchLatency(s); ok {
+			latencies = append(latencies, l)
+		}
+	}
+	total := len(runs)
+	fmt.Fprintf(tw, "Benchmark of %d requests using %d worker(s) in %s\n", total, workers, SecondsText(elapsed.Seconds()))
+	if total == 0 {
+		return tw.String()
+	}
+	fmt.Fprintf(tw, "Requests/s: %f\n", float64(total)/elapsed.Seconds())
+	fmt.Fprintf(tw, "Errors: %d of %d (%.1f%%)\n", failed, total, float64(failed)/float64(total)*100)
+	if failed > 0 {
+		reasons := []string{}
+		for k := range failures {
+			reasons = append(reasons, k)
+		}
+		sort.Strings(reasons)
+		for _, k := range reasons {
+			fmt.Fprintf(tw, "  %s: %d\n", k, failures[k])
+		}
+	}
+
+	sum := Summarise(latencies)
+	if sum.Count == 0 {
+		tw.WriteString("No request got as far as a response, so there are no latencies\n")
+		return tw.String()
+	}
+	fmt.Fprintln(tw, "\n"+withUnit("Latency to first byte", "s"))
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"Samples", "Min", "P50", "P90", "P99", "Max", "Mean", "Std Dev"})
+	t.Append([]string{
+		fmt.Sprintf("%d", sum.Count),
+		fmtSeconds(sum.Min),
+		fmtSeconds(sum.P50),
+		fmtSeconds(sum.P90),
+		fmtSeconds(sum.P99),
+		fmtSeconds(sum.Max),
+		fmtSeconds(sum.Mean),
+		fmtSeconds(sum.StdDev),
+	})
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+	fmt.Fprintln(tw, "\n"+withUnit("Latency histogram", "s"))
+	tw.WriteString(histogram(latencies, benchBuckets))
+	return tw.String()
+}
@@ -0,0 +1,229 @@
+package diag
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// The response headers that say how a response may be cached, and whether it
+// came from a cache, as they're usually written
+var cacheHeaders = []string{
+	"Age",
+	"Cache-Control",
+	"Expires",
+	"ETag",
+	"Last-Modified",
+	"X-Cache",
+	"CF-Cache-Status",
+}
+
+// CacheReporter shows how the response may be cached and how much longer it
+// stays fresh, along with whether a cache along the way says it served it.
+type CacheReporter struct{}
+
+func (r CacheReporter) Name() string {
+	return "Cache"
+}
+
+func (r CacheReporter) Title() string {
+	return "Cache Freshness"
+}
+
+func (r CacheReporter) Description() string {
+	return "Shows the caching headers and how much longer the response stays fresh"
+}
+
+// Check that at least one of the caching headers is present
+func (r CacheReporter) check(s *StatsCollector) error {
+	keys := []string{}
+	for _, k := range cacheHeaders {
+		keys = append(keys, http.CanonicalHeaderKey(k))
+	}
+	return anyHeader(s, keys...)
+}
+
+// cacheInfo is what we make of the caching headers
+type cacheInfo struct {
+	// Directives from Cache-Control, keyed by their lower-cased names,
+	// with the value of any that have one
+	directives map[string]string
+	// Age is how long the response has been in a cache, if it said. The
+	// lifetime is how long it can be cached for in all, from s-maxage,
+	// max-age or Expires, in that order.
+	age          *int64
+	lifetime     *int64
+	lifetimeFrom string
+	// hit is whether X-Cache or CF-Cache-Status say it came from a cache,
+	// or nil if neither says either way
+	hit *bool
+	// noCache is set if the request asked not to be served from a cache,
+	// as with -noCache or any of the flags for its headers
+	noCache bool
+}
+
+// Split Cache-Control into its directives
+func cacheDirectives(values []string) map[string]string {
+	ret := map[string]string{}
+	for _, d := range strings.Split(strings.Join(values, ","), ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(d), "=")
+		if k != "" {
+			ret[strings.ToLower(k)] = strings.Trim(v, "\"")
+		}
+	}
+	return ret
+}
+
+// Return a header given in seconds, or nil if it wasn't or isn't a number
+func seconds(v string) *int64 {
+	n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &n
+}
+
+func (r CacheReporter) info(s *StatsCollector) cacheInfo {
+	h := http.Header(s.ResponseHeaders)
+	ret := cacheInfo{directives: cacheDirectives(h.Values("Cache-Control"))}
+	if v := h.Get("Age"); v != "" {
+		ret.age = seconds(v)
+	}
+	if v, ok := ret.directives["s-maxage"]; ok {
+		ret.lifetime, ret.lifetimeFrom = seconds(v), "s-maxage"
+	} else if v, ok := ret.directives["max-age"]; ok {
+		ret.lifetime, ret.lifetimeFrom = seconds(v), "max-age"
+	} else if v := h.Get("Expires"); v != "" {
+		// Relative to when the server says it sent the response. A date
+		// that doesn't parse, like 0, means it's already expired.
+		date, err := http.ParseTime(h.Get("Date"))
+		if err != nil {
+			date = time.Unix(0, s.FirstByteTime)
+		}
+		lifetime := int64(0)
+		if expires, err := http.ParseTime(v); err == nil && expires.After(date) {
+			lifetime = int64(expires.Sub(date) / time.Second)
+		}
+		ret.lifetime, ret.lifetimeFrom = &lifetime, "Expires"
+	}
+	for _, k := range []string{"X-Cache", "CF-Cache-Status"} {
+		if v := h.Get(k); v != "" {
+			hit := strings.Contains(strings.ToUpper(v), "HIT")
+			ret.hit = &hit
+			if hit {
+				break
+			}
+		}
+	}
+	for k := range cacheDirectives(http.Header(s.RequestHeaders).Values("Cache-Control")) {
+		if k == "no-cache" || k == "no-store" {
+			ret.noCache = true
+		}
+	}
+	if _, ok := cacheDirectives(http.Header(s.RequestHeaders).Values("Pragma"))["no-cache"]; ok {
+		ret.noCache = true
+	}
+	return ret
+}
+
+// Return how many seconds the response stays fresh for, which is negative if
+// it's already stale, or nil if there's no telling
+func (i cacheInfo) ttl() *int64 {
+	if i.lifetime == nil {
+		return nil
+	}
+	ttl := *i.lifetime
+	if i.age != nil {
+		ttl -= *i.age
+	}
+	return &ttl
+}
+
+// Return whether the response looks to have come from a cache, as a reason why
+// or an empty string
+func (i cacheInfo) cached() string {
+	switch {
+	case i.hit != nil && *i.hit:
+		return "a cache reported a hit"
+	case i.age != nil && *i.age > 0:
+		return fmt.Sprintf("it had been cached for %d seconds", *i.age)
+	}
+	return ""
+}
+
+func (r CacheReporter) Report(s *StatsCollector) (ret string, e error) {
+	if err := r.check(s); err != nil {
+		return "", err
+	}
+	i := r.info(s)
+
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"Header", "Value"})
+	for _, k := range cacheHeaders {
+		t.Append([]string{k, headerOrNa(s, http.CanonicalHeaderKey(k))})
+	}
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+
+	if _, ok := i.directives["no-store"]; ok {
+		tw.Write([]byte("The response is no-store, so caches shouldn't keep it at all\n"))
+	} else if _, ok := i.directives["no-cache"]; ok {
+		tw.Write([]byte("The response is no-cache, so caches must check with the origin before reusing it\n"))
+	}
+	if _, ok := i.directives["private"]; ok {
+		tw.Write([]byte("The response is private, so only the client may cache it, not a shared cache along the way\n"))
+	}
+	age := int64(0)
+	if i.age != nil {
+		age = *i.age
+	}
+	if ttl := i.ttl(); ttl == nil {
+		tw.Write([]byte("There's no max-age or Expires, so how long the response stays fresh is up to the cache\n"))
+	} else if *ttl >= 0 {
+		tw.Write([]byte(fmt.Sprintf("The response stays fresh for another %d seconds (a lifetime of %d from %s, less an age of %d)\n",
+			*ttl, *i.lifetime, i.lifetimeFrom, age)))
+	} else {
+		tw.Write([]byte(fmt.Sprintf("The response has been stale for %d seconds (a lifetime of %d from %s, less an age of %d)\n",
+			-*ttl, *i.lifetime, i.lifetimeFrom, age)))
+	}
+	if i.noCache {
+		if why := i.cached(); why != "" {
+			tw.Write([]byte(fmt.Sprintf("WARNING: the request asked not to be served from a cache, but %s\n", why)))
+		} else {
+			tw.Write([]byte("The request asked not to be served from a cache, and nothing suggests that it was\n"))
+		}
+	}
+	ret = tw.String()
+	return
+}
+
+func (r CacheReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	if err := r.check(s); err != nil {
+		return nil, err
+	}
+	i := r.info(s)
+	headers := map[string]interface{}{}
+	for _, k := range cacheHeaders {
+		headers[k] = headerOrNil(s, http.CanonicalHeaderKey(k))
+	}
+	ret := map[string]interface{}{
+		"Headers":          headers,
+		"Directives":       i.directives,
+		"Age":              i.age,
+		"Lifetime":         i.lifetime,
+		"LifetimeFrom":     i.lifetimeFrom,
+		"Ttl":              i.ttl(),
+		"Hit":              i.hit,
+		"NoCacheRequested": i.noCache,
+	}
+	if i.noCache {
+		ret["Bypassed"] = i.cached() == ""
+	}
+	return ret, nil
+}
@@ -0,0 +1,536 @@
+package diag
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// A CAR (content addressable archive) is what IPFS gateways send for
+// ?format=car, and is a stream of blocks each prefixed with its CID. We parse
+// it as it streams past, checking each block against its CID, so that the
+// body never needs to be held in memory.
+//
+// A CARv1 is a varint length and a DAG-CBOR header naming the roots, followed
+// by sections of a varint length, a binary CID and the block itself. A CARv2
+// wraps a CARv1 with a fixed header saying where it is, and an index after it
+// that we skip.
+
+const (
+	carMediaType = "application/vnd.ipld.car"
+	// Limits on the header and CIDs, beyond which it's more likely that
+	// the stream is garbage than that the CAR is unusual
+	carMaxHeader = 1 << 20
+	carMaxDigest = 128
+	// How many failed blocks to keep the details of
+	carMaxFailures = 20
+	// The CARv2 pragma is a CARv1 header of {version: 2} and no roots,
+	// followed by a fixed size header
+	carV2HeaderSize = 40
+)
+
+// CarStats is what was found in a CAR response
+type CarStats struct {
+	Version int
+	Roots   []string
+	// Blocks is how many blocks were found, and BlockBytes the size of
+	// them all. Verified blocks matched their CIDs, and Unverified ones
+	// used a hash function we can't check.
+	Blocks     int
+	BlockBytes uint64
+	Verified   int
+	Unverified int
+	// Failed is how many blocks didn't match their CIDs, with the details
+	// of the first of them in Failures
+	Failed   int
+	Failures []CarFailure
+	// Error is why the CAR couldn't be parsed to the end, if it couldn't
+	Error *ErrorMessage
+}
+
+// CarFailure is a block that didn't match its CID. Offset is where its
+// section started in the body, and Actual the hash of what was received, or
+// its size if that was wrong for an identity CID.
+type CarFailure struct {
+	Cid    string
+	Offset int64
+	Actual string
+}
+
+// Return whether a response is a CAR, from its Content-Type or from it having
+// been asked for with ?format=car
+func isCar(resp *http.Response) bool {
+	if t, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil && t == carMediaType {
+		return true
+	}
+	return resp.Request != nil && resp.Request.URL.Query().Get("format") == "car"
+}
+
+// carReader reads from a CAR, keeping track of how far into it we are
+type carReader struct {
+	br  *bufio.Reader
+	off int64
+}
+
+func newCarReader(r io.Reader) *carReader {
+	return &carReader{br: bufio.NewReader(r)}
+}
+
+func (r *carReader) ReadByte() (byte, error) {
+	b, err := r.br.ReadByte()
+	if err == nil {
+		r.off++
+	}
+	return b, err
+}
+
+func (r *carReader) uvarint() (uint64, error) {
+	return binary.ReadUvarint(r)
+}
+
+// Read n bytes, which running out before then is an error
+func (r *carReader) read(n int) ([]byte, error) {
+	b := make([]byte, n)
+	m, err := io.ReadFull(r.br, b)
+	r.off += int64(m)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+func (r *carReader) copyN(w io.Writer, n int64) error {
+	m, err := io.CopyN(w, r.br, n)
+	r.off += m
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Read a binary CID. A CIDv0 is just a sha2-256 multihash, which always
+// starts with 0x12 0x20, where a CIDv1 starts with its version.
+func (r *carReader) cid() (*Cid, error) {
+	if p, err := r.br.Peek(2); err == nil && p[0] == hashSha256 && p[1] == 32 {
+		b, err := r.read(34)
+		if err != nil {
+			return nil, err
+		}
+		return &Cid{Raw: cidString(0, b), Version: 0, Codec: codecDagPb, HashCode: hashSha256, Digest: b[2:]}, nil
+	}
+	var fields [4]uint64
+	for i := range fields {
+		v, err := r.uvarint()
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+		fields[i] = v
+	}
+	version, codec, code, length := fields[0], fields[1], fields[2], fields[3]
+	if version != 1 {
+		return nil, fmt.Errorf("unsupported CID version %d", version)
+	}
+	if length > carMaxDigest {
+		return nil, fmt.Errorf("CID has a %d byte digest", length)
+	}
+	digest, err := r.read(int(length))
+	if err != nil {
+		return nil, err
+	}
+	b := binary.AppendUvarint(nil, version)
+	b = binary.AppendUvarint(b, codec)
+	b = binary.AppendUvarint(b, code)
+	b = binary.AppendUvarint(b, length)
+	b = append(b, digest...)
+	return &Cid{Raw: cidString(1, b), Version: 1, Codec: codec, HashCode: code, Digest: digest}, nil
+}
+
+// carVerifier is fed the body of a CAR response as it's received, and parses
+// it in the background. It never fails a write, so that a bad CAR doesn't
+// stop the transfer, and once the CAR can't be parsed any further the rest is
+// ignored.
+type carVerifier struct {
+	pw    *io.PipeWriter
+	done  chan struct{}
+	stats CarStats
+}
+
+func newCarVerifier() *carVerifier {
+	pr, pw := io.Pipe()
+	v := &carVerifier{pw: pw, done: make(chan struct{})}
+	go func() {
+		defer close(v.done)
+		if err := v.parse(newCarReader(pr)); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				err = errors.New("the CAR ends part way through")
+			}
+			v.stats.Error = NewErrorMessage(err)
+		}
+		pr.CloseWithError(errors.New("stopped parsing the CAR"))
+	}()
+	return v
+}
+
+func (v *carVerifier) Write(b []byte) (int, error) {
+	v.pw.Write(b)
+	return len(b), nil
+}
+
+// Finish with the CAR, once the whole of the body has been written, and
+// return what was found in it
+func (v *carVerifier) Close() *CarStats {
+	v.pw.Close()
+	<-v.done
+	return &v.stats
+}
+
+func (v *carVerifier) parse(r *carReader) error {
+	version, roots, err := carHeader(r)
+	if err != nil {
+		return err
+	}
+	v.stats.Version = version
+	if version == 2 {
+		// Skip to the CARv1 inside, and stop at the end of it
+		h, err := r.read(carV2HeaderSize)
+		if err != nil {
+			return err
+		}
+		offset := int64(binary.LittleEndian.Uint64(h[16:]))
+		size := int64(binary.LittleEndian.Uint64(h[24:]))
+		if offset < r.off {
+			return fmt.Errorf("CARv2 data offset %d is inside its header", offset)
+		}
+		if err := r.copyN(io.Discard, offset-r.off); err != nil {
+			return err
+		}
+		inner := newCarReader(io.LimitReader(r.br, size))
+		inner.off = r.off
+		if version, roots, err = carHeader(inner); err != nil {
+			return err
+		}
+		if version != 1 {
+			return fmt.Errorf("CARv2 holds a version %d CAR rather than a CARv1", version)
+		}
+		r = inner
+	} else if version != 1 {
+		return fmt.Errorf("unsupported CAR version %d", version)
+	}
+	v.stats.Roots = roots
+	if len(roots) > 0 {
+		LogInfo("CAR root is %s", strings.Join(roots, ", "))
+	}
+	return v.sections(r)
+}
+
+// Read the blocks, checking each against its CID
+func (v *carVerifier) sections(r *carReader) error {
+	for {
+		start := r.off
+		length, err := r.uvarint()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if length == 0 {
+			return fmt.Errorf("empty section at offset %d", start)
+		}
+		cidStart := r.off
+		cid, err := r.cid()
+		if err != nil {
+			return fmt.Errorf("bad CID in the section at offset %d: %w", start, err)
+		}
+		size := int64(length) - (r.off - cidStart)
+		if size < 0 {
+			return fmt.Errorf("section at offset %d is shorter than its CID", start)
+		}
+		v.stats.Blocks++
+		v.stats.BlockBytes += uint64(size)
+
+		var actual []byte
+		got := ""
+		if cid.HashCode == hashIdentity {
+			// The block is its own digest, so can't be any longer than
+			// one, and a length from the gateway that says otherwise
+			// isn't read into memory
+			if size != int64(len(cid.Digest)) {
+				if err := r.copyN(io.Discard, size); err != nil {
+					return err
+				}
+				got = fmt.Sprintf("a %d byte block", size)
+			} else if actual, err = r.read(int(size)); err != nil {
+				return err
+			}
+		} else if h, herr := cid.NewHash(); herr == nil {
+			if err := r.copyN(h, size); err != nil {
+				return err
+			}
+			actual = h.Sum(nil)
+			if len(cid.Digest) < len(actual) {
+				// A multihash may be truncated
+				actual = actual[:len(cid.Digest)]
+			}
+		} else {
+			v.stats.Unverified++
+			if err := r.copyN(io.Discard, size); err != nil {
+				return err
+			}
+			continue
+		}
+		if got == "" && bytes.Equal(actual, cid.Digest) {
+			v.stats.Verified++
+			continue
+		}
+		v.stats.Failed++
+		LogError("Block %s at offset %d of the CAR does not match its CID", cid.Raw, start)
+		if got == "" {
+			got = hex.EncodeToString(actual)
+		}
+		if len(v.stats.Failures) < carMaxFailures {
+			v.stats.Failures = append(v.stats.Failures,
+				CarFailure{Cid: cid.Raw, Offset: start, Actual: got})
+		}
+	}
+}
+
+// Read a CARv1 header, or the pragma at the start of a CARv2, returning the
+// version and any roots
+func carHeader(r *carReader) (int, []string, error) {
+	length, err := r.uvarint()
+	if err != nil {
+		return 0, nil, fmt.Errorf("no CAR header: %w", err)
+	}
+	if length == 0 || length > carMaxHeader {
+		return 0, nil, fmt.Errorf("CAR header is %d bytes long", length)
+	}
+	b, err := r.read(int(length))
+	if err != nil {
+		return 0, nil, err
+	}
+	h, rest, err := cborDecode(b, 0)
+	if err != nil {
+		return 0, nil, fmt.Errorf("bad CAR header: %w", err)
+	}
+	m, ok := h.(map[string]interface{})
+	if !ok || len(rest) > 0 {
+		return 0, nil, errors.New("bad CAR header: not a map")
+	}
+	version, ok := m["version"].(uint64)
+	if !ok {
+		return 0, nil, errors.New("bad CAR header: no version")
+	}
+	roots := []string{}
+	list, _ := m["roots"].([]interface{})
+	for _, root := range list {
+		b, ok := root.(cborCid)
+		if !ok {
+			return 0, nil, errors.New("bad CAR header: a root isn't a CID")
+		}
+		cid, err := newCarReader(bytes.NewReader(b)).cid()
+		if err != nil {
+			return 0, nil, fmt.Errorf("bad CAR header: %w", err)
+		}
+		roots = append(roots, cid.Raw)
+	}
+	return int(version), roots, nil
+}
+
+// cborCid is a CID in DAG-CBOR, which is tag 42 of its binary form
+type cborCid []byte
+
+// Decode just enough CBOR for a CAR header, returning the value and whatever
+// follows it. Maps must have string keys, and floats and indefinite lengths
+// aren't supported, as DAG-CBOR doesn't use them in headers.
+func cborDecode(b []byte, depth int) (interface{}, []byte, error) {
+	if depth > 16 {
+		return nil, nil, errors.New("nested too deeply")
+	}
+	if len(b) == 0 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+	b = b[1:]
+	var n uint64
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info <= 27:
+		size := 1 << (info - 24)
+		if len(b) < size {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		for _, c := range b[:size] {
+			n = n<<8 | uint64(c)
+		}
+		b = b[size:]
+	default:
+		return nil, nil, fmt.Errorf("unsupported CBOR length 0x%x", info)
+	}
+
+	switch major {
+	case 0:
+		return n, b, nil
+	case 1:
+		return -1 - int64(n), b, nil
+	case 2, 3:
+		if uint64(len(b)) < n {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		if major == 3 {
+			return string(b[:n]), b[n:], nil
+		}
+		return b[:n], b[n:], nil
+	case 4:
+		ret := []interface{}{}
+		for i := uint64(0); i < n; i++ {
+			v, rest, err := cborDecode(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			ret, b = append(ret, v), rest
+		}
+		return ret, b, nil
+	case 5:
+		ret := map[string]interface{}{}
+		for i := uint64(0); i < n; i++ {
+			k, rest, err := cborDecode(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, nil, errors.New("map key isn't a string")
+			}
+			v, rest, err := cborDecode(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			ret[key], b = v, rest
+		}
+		return ret, b, nil
+	case 6:
+		v, rest, err := cborDecode(b, depth+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		if n == 42 {
+			// The CID's bytes are prefixed with the identity multibase
+			if cid, ok := v.([]byte); ok && len(cid) > 1 && cid[0] == 0 {
+				return cborCid(cid[1:]), rest, nil
+			}
+			return nil, nil, errors.New("bad CID")
+		}
+		return v, rest, nil
+	default:
+		switch info {
+		case 20:
+			return false, b, nil
+		case 21:
+			return true, b, nil
+		case 22:
+			return nil, b, nil
+		}
+		return nil, nil, fmt.Errorf("unsupported CBOR simple value 0x%x", info)
+	}
+}
+
+// CarReporter shows the blocks found in a CAR response, and whether they all
+// matched their CIDs
+type CarReporter struct{}
+
+func (r CarReporter) Name() string {
+	return "Car"
+}
+
+func (r CarReporter) Title() string {
+	return "CAR Verification"
+}
+
+func (r CarReporter) Description() string {
+	return "Checks each block of a CAR response against its CID"
+}
+
+func (r CarReporter) check(s *StatsCollector) error {
+	if s.Car == nil {
+		return errors.New("The response wasn't a CAR (ask a gateway for one with ?format=car)")
+	}
+	return nil
+}
+
+func (r CarReporter) Report(s *StatsCollector) (ret string, e error) {
+	if err := r.check(s); err != nil {
+		return "", err
+	}
+	c := s.Car
+
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"Version", "Blocks", "Bytes", "Verified", "Unverified", "Failed"})
+	t.Append([]string{
+		fmt.Sprintf("%d", c.Version),
+		fmt.Sprintf("%d", c.Blocks),
+		fmt.Sprintf("%d", c.BlockBytes),
+		fmt.Sprintf("%d", c.Verified),
+		fmt.Sprintf("%d", c.Unverified),
+		fmt.Sprintf("%d", c.Failed),
+	})
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+
+	for _, root := range c.Roots {
+		tw.Write([]byte(fmt.Sprintf("Root: %s\n", root)))
+	}
+	for _, f := range c.Failures {
+		tw.Write([]byte(fmt.Sprintf("WARNING: block %s at offset %d does not match its CID (got %s)\n",
+			f.Cid, f.Offset, f.Actual)))
+	}
+	if c.Failed > len(c.Failures) {
+		tw.Write([]byte(fmt.Sprintf("WARNING: and %d more blocks do not match their CIDs\n",
+			c.Failed-len(c.Failures))))
+	}
+	if c.Unverified > 0 {
+		tw.Write([]byte(fmt.Sprintf("%d blocks use a hash function that can't be checked\n", c.Unverified)))
+	}
+	if c.Error != nil {
+		if s.Truncated {
+			tw.Write([]byte(fmt.Sprintf("The CAR was cut short by -maxBytes: %s\n", c.Error)))
+		} else {
+			tw.Write([]byte(fmt.Sprintf("WARNING: the CAR couldn't be read to the end: %s\n", c.Error)))
+		}
+	} else if c.Failed == 0 {
+		tw.Write([]byte(fmt.Sprintf("All %d blocks that could be checked match their CIDs\n", c.Verified)))
+	}
+	ret = tw.String()
+	return
+}
+
+func (r CarReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	if err := r.check(s); err != nil {
+		return nil, err
+	}
+	c := s.Car
+	return map[string]interface{}{
+		"Version":    c.Version,
+		"Roots":      c.Roots,
+		"Blocks":     c.Blocks,
+		"BlockBytes": c.BlockBytes,
+		"Verified":   c.Verified,
+		"Unverified": c.Unverified,
+		"Failed":     c.Failed,
+		"Failures":   c.Failures,
+		"Error":      c.Error,
+	}, nil
+}
@@ -0,0 +1,75 @@
+package diag
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// ChunkedReporter shows how the body was framed, which for a streamed IPFS
+// response is usually chunked transfer encoding, as the gateway can't know
+// the size until it's done. That leaves no total to check the size against or
+// to show progress through.
+type ChunkedReporter struct{}
+
+func (r ChunkedReporter) Name() string {
+	return "Chunked"
+}
+
+func (r ChunkedReporter) Title() string {
+	return "Transfer Framing"
+}
+
+func (r ChunkedReporter) Description() string {
+	return "Shows whether the body was sent chunked or with a Content-Length given up front"
+}
+
+func (r ChunkedReporter) Report(s *StatsCollector) (ret string, e error) {
+	if err := checkResponse(s); err != nil {
+		return "", err
+	}
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"Protocol", "Transfer-Encoding", "Content-Length", "Framing"})
+	te, length := "n/a", "not given"
+	if len(s.TransferEncoding) > 0 {
+		te = strings.Join(s.TransferEncoding, ", ")
+	}
+	if s.ContentLength >= 0 {
+		length = fmt.Sprintf("%d", s.ContentLength)
+	}
+	t.Append([]string{s.Proto, te, length, s.Framing()})
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+
+	switch s.Framing() {
+	case framingChunked:
+		tw.Write([]byte("The body was sent in chunks, so its size wasn't known until the last one arrived\n"))
+	case framingLength:
+		tw.Write([]byte("The size of the body was given up front\n"))
+	case framingStream:
+		tw.Write([]byte(fmt.Sprintf("The body ended with the %s stream, without its size being given up front\n", s.Proto)))
+	case framingClose:
+		tw.Write([]byte("WARNING: the body had neither a length nor chunks, so it ended when the server closed the connection, and a dropped connection would look the same as the end\n"))
+	}
+	ret = tw.String()
+	return
+}
+
+func (r ChunkedReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	if err := checkResponse(s); err != nil {
+		return nil, err
+	}
+	ret := map[string]interface{}{
+		"TransferEncoding": s.TransferEncoding,
+		"Chunked":          s.Framing() == framingChunked,
+		"Framing":          s.Framing(),
+		"ContentLength":    nil,
+	}
+	if s.ContentLength >= 0 {
+		ret["ContentLength"] = s.ContentLength
+	}
+	return ret, nil
+}
@@ -0,0 +1,167 @@
+package diag
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+)
+
+// Multicodec codes for the content types and hash functions we understand
+const (
+	codecRaw     = 0x55
+	codecDagPb   = 0x70
+	hashIdentity = 0x00
+	hashSha256   = 0x12
+	hashSha512   = 0x13
+	base58Chars  = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+)
+
+// Cid is a decoded content identifier. Only as much as we need to check the
+// content against it is kept.
+type Cid struct {
+	// Raw is the CID as it was given
+	Raw     string
+	Version int
+	Codec   uint64
+	// HashCode is the multihash function code, and Digest the hash itself
+	HashCode uint64
+	Digest   []byte
+}
+
+// Decode a base58btc string, as used by CIDv0 and the 'z' multibase prefix
+func base58Decode(s string) ([]byte, error) {
+	n := new(big.Int)
+	for _, c := range s {
+		i := strings.IndexRune(base58Chars, c)
+		if i < 0 {
+			return nil, fmt.Errorf("invalid base58 character '%c'", c)
+		}
+		n.Mul(n, big.NewInt(58))
+		n.Add(n, big.NewInt(int64(i)))
+	}
+	// Leading zero bytes are encoded as leading '1's
+	zeros := len(s) - len(strings.TrimLeft(s, "1"))
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
+
+// Encode bytes as base58btc
+func base58Encode(b []byte) string {
+	n := new(big.Int).SetBytes(b)
+	ret := []byte{}
+	base, mod := big.NewInt(58), new(big.Int)
+	for n.Sign() > 0 {
+		n.DivMod(n, base, mod)
+		ret = append(ret, base58Chars[mod.Int64()])
+	}
+	for _, c := range b {
+		if c != 0 {
+			break
+		}
+		ret = append(ret, '1')
+	}
+	for i, j := 0, len(ret)-1; i < j; i, j = i+1, j-1 {
+		ret[i], ret[j] = ret[j], ret[i]
+	}
+	return string(ret)
+}
+
+// Encode a CID in its binary form as a string the way it's usually written,
+// in base58btc for CIDv0 and base32 for CIDv1
+func cidString(version int, b []byte) string {
+	if version == 0 {
+		return base58Encode(b)
+	}
+	return "b" + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+}
+
+// Parse a multihash into its function code and digest
+func parseMultihash(b []byte) (code uint64, digest []byte, err error) {
+	r := bytes.NewReader(b)
+	if code, err = binary.ReadUvarint(r); err != nil {
+		return 0, nil, errors.New("truncated multihash")
+	}
+	length, err := binary.ReadUvarint(r)
+	if err != nil || length != uint64(r.Len()) {
+		return 0, nil, errors.New("multihash length doesn't match its digest")
+	}
+	return code, b[len(b)-r.Len():], nil
+}
+
+// Parse a CID in its string form. CIDv0 (base58btc "Qm...") and CIDv1 in
+// base32 ('b') or base58btc ('z') multibase are supported.
+func ParseCid(s string) (*Cid, error) {
+	if len(s) == 46 && strings.HasPrefix(s, "Qm") {
+		b, err := base58Decode(s)
+		if err != nil {
+			return nil, err
+		}
+		code, digest, err := parseMultihash(b)
+		if err != nil {
+			return nil, err
+		}
+		return &Cid{Raw: s, Version: 0, Codec: codecDagPb, HashCode: code, Digest: digest}, nil
+	}
+	if len(s) < 2 {
+		return nil, fmt.Errorf("'%s' is too short to be a CID", s)
+	}
+
+	var b []byte
+	var err error
+	switch s[0] {
+	case 'b':
+		b, err = base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(s[1:]))
+	case 'z':
+		b, err = base58Decode(s[1:])
+	default:
+		return nil, fmt.Errorf("unsupported multibase prefix '%c' in CID", s[0])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CID '%s': %w", s, err)
+	}
+
+	r := bytes.NewReader(b)
+	version, err := binary.ReadUvarint(r)
+	if err != nil || version != 1 {
+		return nil, fmt.Errorf("unsupported CID version in '%s'", s)
+	}
+	codec, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("truncated CID '%s'", s)
+	}
+	code, digest, err := parseMultihash(b[len(b)-r.Len():])
+	if err != nil {
+		return nil, err
+	}
+	return &Cid{Raw: s, Version: 1, Codec: codec, HashCode: code, Digest: digest}, nil
+}
+
+// NewHash returns a hash of the type used by the CID
+func (c Cid) NewHash() (hash.Hash, error) {
+	switch c.HashCode {
+	case hashSha256:
+		return sha256.New(), nil
+	case hashSha512:
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported multihash function 0x%x", c.HashCode)
+}
+
+// HashName returns the multihash name of the CID's hash function
+func (c Cid) HashName() string {
+	switch c.HashCode {
+	case hashIdentity:
+		return "identity"
+	case hashSha256:
+		return "sha2-256"
+	case hashSha512:
+		return "sha2-512"
+	}
+	return fmt.Sprintf("0x%x", c.HashCode)
+}
@@ -0,0 +1,195 @@
+package diag
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The Go resolver follows CNAMEs for us, but only tells us where it ended up.
+// To get the whole chain, we look the host up again and pick the CNAME
+// records out of the answers as they come back from the server.
+
+// cnameRecords collects the CNAME records seen in DNS answers, keyed by the
+// name they're for. The A and AAAA lookups are made at the same time, so it
+// needs a lock.
+type cnameRecords struct {
+	sync.Mutex
+	targets map[string]string
+}
+
+func (r *cnameRecords) add(name string, target string) {
+	r.Lock()
+	defer r.Unlock()
+	r.targets[strings.ToLower(name)] = target
+}
+
+// Return the chain of names that host is an alias for, in order
+func (r *cnameRecords) chain(host string) []string {
+	r.Lock()
+	defer r.Unlock()
+	ret := []string{}
+	name := strings.ToLower(strings.TrimSuffix(host, "."))
+	for {
+		target, ok := r.targets[name]
+		if !ok || len(ret) > len(r.targets) {
+			// The length check stops us going round a loop
+			return ret
+		}
+		ret = append(ret, target)
+		name = strings.ToLower(target)
+	}
+}
+
+// cnameConn passes through the connection to a DNS server, reading the CNAME
+// records out of each answer. Over UDP each read is a whole message, and
+// otherwise, as over TCP and for DoH, messages are prefixed with their length.
+type cnameConn struct {
+	net.Conn
+	records *cnameRecords
+	packet  bool
+	buf     []byte
+}
+
+func (c *cnameConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if c.packet {
+		c.parse(b[:n])
+		return n, err
+	}
+	c.buf = append(c.buf, b[:n]...)
+	for len(c.buf) >= 2 {
+		l := int(binary.BigEndian.Uint16(c.buf))
+		if len(c.buf) < 2+l {
+			break
+		}
+		c.parse(c.buf[2 : 2+l])
+		c.buf = c.buf[2+l:]
+	}
+	return n, err
+}
+
+// Pick the CNAME records out of the answer section of a DNS message. Anything
+// we can't make sense of is ignored, as the resolver will complain about it
+// anyway.
+func (c *cnameConn) parse(msg []byte) {
+	if len(msg) < 12 {
+		return
+	}
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	answers := int(binary.BigEndian.Uint16(msg[6:]))
+	off := 12
+	for i := 0; i < questions; i++ {
+		_, next, err := dnsName(msg, off)
+		if err != nil {
+			return
+		}
+		off = next + 4
+	}
+	for i := 0; i < answers; i++ {
+		name, next, err := dnsName(msg, off)
+		if err != nil || next+10 > len(msg) {
+			return
+		}
+		rrtype := binary.BigEndian.Uint16(msg[next:])
+		length := int(binary.BigEndian.Uint16(msg[next+8:]))
+		off = next + 10
+		if off+length > len(msg) {
+			return
+		}
+		if rrtype == 5 {
+			if target, _, err := dnsName(msg, off); err == nil {
+				c.records.add(name, target)
+			}
+		}
+		off += length
+	}
+}
+
+// cnamePacketConn is a cnameConn over UDP, which the resolver needs to be a
+// net.PacketConn to know not to expect the length prefixes
+type cnamePacketConn struct {
+	*cnameConn
+	pc net.PacketConn
+}
+
+func (c cnamePacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	return c.pc.ReadFrom(b)
+}
+
+func (c cnamePacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.pc.WriteTo(b, addr)
+}
+
+// Read a possibly compressed name from a DNS message at off, returning it
+// without the trailing dot along with the offset just after it
+func dnsName(msg []byte, off int) (string, int, error) {
+	labels := []string{}
+	next := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errors.New("name runs past the end of the message")
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case l&0xc0 == 0xc0:
+			// A pointer to the rest of the name elsewhere
+			if off+1 >= len(msg) || jumps > 10 {
+				return "", 0, errors.New("bad name compression")
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, errors.New("label runs past the end of the message")
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
+
+// Look host up using the same servers as base, or the system's if base is
+// nil, and return the chain of CNAMEs it's an alias for, if any. The lookup
+// is given up on after five seconds, or when ctx is cancelled.
+func LookupCnames(ctx context.Context, base *net.Resolver, host string) ([]string, error) {
+	dial := (&net.Dialer{}).DialContext
+	if base != nil && base.Dial != nil {
+		dial = base.Dial
+	}
+	records := &cnameRecords{targets: map[string]string{}}
+	r := &net.Resolver{
+		// Only the Go resolver lets us see the answers
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			conn, err := dial(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			c := &cnameConn{Conn: conn, records: records}
+			if pc, ok := conn.(net.PacketConn); ok {
+				c.packet = true
+				return cnamePacketConn{c, pc}, nil
+			}
+			return c, nil
+		},
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if _, err := r.LookupIPAddr(ctx, host); err != nil {
+		return nil, err
+	}
+	return records.chain(host), nil
+}
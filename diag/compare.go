@@ -0,0 +1,104 @@
+package diag
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// Response headers worth comparing between two servers, as they tend to
+// explain differences in the timings.
+var compareHeaders = []string{
+	"Server",
+	"Content-Type",
+	"Content-Length",
+	"Cache-Control",
+	"Age",
+	"Etag",
+	"X-Proxy-Cache",
+	"X-Ipfs-Path",
+	"Saturn-Cache-Status",
+}
+
+// Format a pair of values for comparison, marking the better of the two. Values
+// that weren't measured are shown as "n/a" and never marked.
+func comparePair(a float64, aOk bool, b float64, bOk bool, lowerBetter bool, format func(float64) string) (string, string) {
+	as, bs := "n/a", "n/a"
+	if aOk {
+		as = format(a)
+	}
+	if bOk {
+		bs = format(b)
+	}
+	if aOk && bOk && a != b {
+		if (a < b) == lowerBetter {
+			as += " *"
+		} else {
+			bs += " *"
+		}
+	}
+	return as, bs
+}
+
+// CompareReport shows the key timings, throughput and headers of two requests
+// side by side, marking whichever was faster for each.
+func CompareReport(a *StatsCollector, b *StatsCollector) string {
+	tw := &strings.Builder{}
+	fmt.Fprintf(tw, "First:  %s\nSecond: %s\n", a.Uri, b.Uri)
+
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"", "First", "Second"})
+	result := func(s *StatsCollector) string {
+		if s.Error != nil {
+			return "failed"
+		}
+		return fmt.Sprintf("%s %d", s.Proto, s.StatusCode)
+	}
+	t.Append([]string{"Result", result(a), result(b)})
+
+	for _, p := range []struct {
+		name  string
+		phase Phase
+	}{
+		{withUnit("DNS Lookup", "s"), PhaseDns},
+		{withUnit("Connection", "s"), PhaseConnect},
+		{withUnit("TLS", "s"), PhaseTls},
+		{withUnit("First Byte", "s"), PhaseFirstByte},
+		{withUnit("Transfer", "s"), PhaseTransfer},
+	} {
+		ad, aOk := a.PhaseDuration(p.phase)
+		bd, bOk := b.PhaseDuration(p.phase)
+		as, bs := comparePair(ad.Seconds(), aOk, bd.Seconds(), bOk, true, fmtSeconds)
+		t.Append([]string{p.name, as, bs})
+	}
+	as, bs := comparePair(a.KBPerSecond(), a.DurationNS() > 0, b.KBPerSecond(), b.DurationNS() > 0, false, fmtRate)
+	t.Append([]string{withUnit("Throughput", "kB/s"), as, bs})
+	t.Append([]string{"Bytes",
+		fmtBytes(a.TotalBytesTransferred()),
+		fmtBytes(b.TotalBytesTransferred())})
+
+	differ := 0
+	for _, h := range compareHeaders {
+		av := strings.Join(a.ResponseHeaders[h], ", ")
+		bv := strings.Join(b.ResponseHeaders[h], ", ")
+		if av == "" && bv == "" {
+			continue
+		}
+		if av != bv {
+			differ++
+		}
+		t.Append([]string{h, av, bv})
+	}
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+	if Units == UnitsRaw {
+		tw.Write([]byte("Timings are in seconds. "))
+	}
+	tw.Write([]byte("* marks the faster of the two\n"))
+	if differ > 0 {
+		tw.Write([]byte(fmt.Sprintf("%d of the compared headers differ\n", differ)))
+	}
+	return tw.String()
+}
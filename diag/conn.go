@@ -0,0 +1,84 @@
+package diag
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// countingConn counts the bytes read from and written to a connection, so
+// that we know what really went over the network. This includes the request
+// and response headers, TLS records and HTTP/2 framing, and the body before
+// it's decompressed.
+type countingConn struct {
+	net.Conn
+	read    atomic.Uint64
+	written atomic.Uint64
+	// The TCP info as it was when the connection was closed, which the
+	// transport may do before we get to look
+	mu      sync.Mutex
+	closed  bool
+	info    *TcpInfo
+	infoErr error
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read.Add(uint64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(uint64(n))
+	return n, err
+}
+
+func (c *countingConn) Close() error {
+	c.mu.Lock()
+	if !c.closed {
+		c.info, c.infoErr = readTcpInfo(c.Conn)
+		c.closed = true
+	}
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
+
+// Return the kernel's view of the TCP connection, now or when it was closed
+func (c *countingConn) tcpInfo() (*TcpInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return c.info, c.infoErr
+	}
+	return readTcpInfo(c.Conn)
+}
+
+// Return the bytes read and written so far
+func (c *countingConn) counts() (uint64, uint64) {
+	return c.read.Load(), c.written.Load()
+}
+
+// Return a DialContext function that wraps each connection made by dial in a
+// countingConn.
+func CountingDialContext(dial func(context.Context, string, string) (net.Conn, error)) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, network string, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		return &countingConn{Conn: conn}, nil
+	}
+}
+
+// Find the countingConn underneath a connection handed to us by the
+// transport, which for https:// will be a TLS connection wrapping it.
+func findCountingConn(conn net.Conn) *countingConn {
+	if t, ok := conn.(*tls.Conn); ok {
+		conn = t.NetConn()
+	}
+	c, _ := conn.(*countingConn)
+	return c
+}
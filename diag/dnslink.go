@@ -0,0 +1,172 @@
+package diag
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// DNSLink maps a DNS name to content in IPFS with a TXT record on
+// _dnslink.<name> of the form dnslink=/ipfs/<cid>, or dnslink=/ipns/<name> to
+// point at another name. An ipns:// URI for a DNS name is resolved this way
+// by the gateway, and we do the same lookup to see what it should have found.
+
+// Return whether the name of an ipns:// URI is a DNS name, rather than the
+// CID of a key, which is never a DNS name
+func isDnsLinkName(name string) bool {
+	return strings.Contains(name, ".")
+}
+
+// Look up the DNSLink records for name, returning the value of each
+// dnslink= record. As the spec says, where there are several the first
+// in sorted order is the one that counts, so they're returned sorted. The
+// lookup is given up on after five seconds, or when ctx is cancelled.
+func LookupDnsLink(ctx context.Context, r *net.Resolver, name string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	txts, err := r.LookupTXT(ctx, "_dnslink."+strings.TrimSuffix(name, "."))
+	if err != nil {
+		return nil, err
+	}
+	ret := []string{}
+	for _, txt := range txts {
+		if strings.HasPrefix(txt, "dnslink=") {
+			ret = append(ret, strings.TrimPrefix(txt, "dnslink="))
+		}
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no dnslink= TXT record for _dnslink.%s", name)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// DnsLinkReporter shows what the DNSLink record for an ipns:// name points
+// at, and whether that's what the gateway served
+type DnsLinkReporter struct{}
+
+func (r DnsLinkReporter) Name() string {
+	return "DnsLink"
+}
+
+func (r DnsLinkReporter) Title() string {
+	return "DNSLink Resolution"
+}
+
+func (r DnsLinkReporter) Description() string {
+	return "Shows what the DNSLink record of an ipns:// name points at, and whether the gateway served it"
+}
+
+func (r DnsLinkReporter) check(s *StatsCollector) error {
+	if s.DnsLink.Name == "" {
+		return errors.New("No DNSLink lookup was made (the URI isn't ipns:// with a DNS name)")
+	}
+	return nil
+}
+
+// Return the CID the DNSLink record points at, if it's an /ipfs/ path
+func (r DnsLinkReporter) cid(s *StatsCollector) *Cid {
+	if !strings.HasPrefix(s.DnsLink.Path, "/ipfs/") {
+		return nil
+	}
+	c, _, _ := strings.Cut(strings.TrimPrefix(s.DnsLink.Path, "/ipfs/"), "/")
+	cid, err := ParseCid(c)
+	if err != nil {
+		return nil
+	}
+	return cid
+}
+
+// Return the root CID the gateway says it served, from X-Ipfs-Roots, which
+// lists the CID of each part of the path starting with the root
+func (r DnsLinkReporter) gatewayRoot(s *StatsCollector) *Cid {
+	v := http.Header(s.ResponseHeaders).Get("X-Ipfs-Roots")
+	if v == "" {
+		return nil
+	}
+	c, _, _ := strings.Cut(v, ",")
+	cid, err := ParseCid(strings.TrimSpace(c))
+	if err != nil {
+		return nil
+	}
+	return cid
+}
+
+// Return whether the gateway served the content the DNSLink record points
+// at, or nil if there's no telling. CIDs are compared by their hashes, so that
+// a CIDv0 and a CIDv1 of the same content match.
+func (r DnsLinkReporter) match(s *StatsCollector) *bool {
+	cid, root := r.cid(s), r.gatewayRoot(s)
+	if cid == nil || root == nil {
+		return nil
+	}
+	match := cid.HashCode == root.HashCode && bytes.Equal(cid.Digest, root.Digest)
+	return &match
+}
+
+func (r DnsLinkReporter) Report(s *StatsCollector) (ret string, e error) {
+	if err := r.check(s); err != nil {
+		return "", err
+	}
+
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"Name", "Record", "Gateway Root"})
+	record := s.DnsLink.Path
+	if s.DnsLink.Error != nil {
+		record = s.DnsLink.Error.Error()
+	}
+	root := headerOrNa(s, "X-Ipfs-Roots")
+	if cid := r.gatewayRoot(s); cid != nil {
+		root = cid.Raw
+	}
+	t.Append([]string{"_dnslink." + s.DnsLink.Name, record, root})
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+
+	if len(s.DnsLink.Records) > 1 {
+		tw.Write([]byte(fmt.Sprintf("WARNING: there are %d dnslink= records, and only the first in sorted order counts: %s\n",
+			len(s.DnsLink.Records), strings.Join(s.DnsLink.Records, ", "))))
+	}
+	if strings.HasPrefix(s.DnsLink.Path, "/ipns/") {
+		tw.Write([]byte("The record points at another IPNS name, which the gateway resolves in turn\n"))
+	}
+	if match := r.match(s); match != nil {
+		if *match {
+			tw.Write([]byte("The gateway served the content the DNSLink record points at\n"))
+		} else {
+			tw.Write([]byte(fmt.Sprintf("WARNING: the DNSLink record points at %s but the gateway served %s, so it may have an out of date copy of the record\n",
+				r.cid(s).Raw, r.gatewayRoot(s).Raw)))
+		}
+	}
+	tw.Write([]byte(fmt.Sprintf("Looked up via the %s resolver\n", s.Dns.Resolver)))
+	ret = tw.String()
+	return
+}
+
+func (r DnsLinkReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	if err := r.check(s); err != nil {
+		return nil, err
+	}
+	ret := map[string]interface{}{
+		"Name":        s.DnsLink.Name,
+		"Records":     s.DnsLink.Records,
+		"Path":        s.DnsLink.Path,
+		"Error":       s.DnsLink.Error,
+		"GatewayRoot": nil,
+		"Match":       r.match(s),
+	}
+	if root := r.gatewayRoot(s); root != nil {
+		ret["GatewayRoot"] = root.Raw
+	}
+	return ret, nil
+}
@@ -0,0 +1,115 @@
+package diag
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+)
+
+// Exit codes returned by web3diag. Each class of failure gets its own code so
+// that scripts wrapping us can tell where a request fell over.
+const (
+	ExitOK = iota
+	ExitUsage
+	ExitRequest
+	ExitDns
+	ExitConnect
+	ExitTls
+	ExitTransfer
+	ExitOutput
+	ExitVerify
+	ExitStatus
+	ExitInterrupted
+)
+
+// Short names for the classes of failure, for summaries
+var failureNames = map[int]string{
+	ExitRequest:     "request",
+	ExitDns:         "dns",
+	ExitConnect:     "connect",
+	ExitTls:         "tls",
+	ExitTransfer:    "transfer",
+	ExitOutput:      "output",
+	ExitVerify:      "verify",
+	ExitStatus:      "status",
+	ExitInterrupted: "interrupted",
+}
+
+// RequestError is returned when a request fails, and carries the exit code for
+// the class of failure along with the underlying error.
+type RequestError struct {
+	Code int
+	Err  error
+}
+
+func (e *RequestError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// Return the exit code for an error returned from a request
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var re *RequestError
+	if errors.As(err, &re) {
+		return re.Code
+	}
+	return ExitRequest
+}
+
+// Whether a request got far enough for there to be something to report, even
+// if it failed: it was made, so there's at least the DNS lookup or connection
+// attempt to go on. Reporters say for themselves when what they need is
+// missing.
+func Reportable(s *StatsCollector) bool {
+	return s.Total.StartTime != 0
+}
+
+// Work out which phase of the request a failure happened in, based on the
+// error itself and how far through the trace points we managed to get.
+func failureClass(s *StatsCollector, err error) int {
+	if errors.Is(err, context.Canceled) {
+		return ExitInterrupted
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return ExitDns
+	}
+	if p, ok := s.UnfinishedPhase(); ok && p == PhaseDns {
+		// Timed out before the lookup had an answer
+		return ExitDns
+	}
+	if s.Session.EndTime == 0 {
+		// Never got a usable connection, so it's either the TCP
+		// connection or the TLS handshake on top of it.
+		if s.Tls.StartTime != 0 {
+			return ExitTls
+		}
+		return ExitConnect
+	}
+	return ExitTransfer
+}
+
+// ErrorMessage wraps an error so that it marshals to JSON as its message,
+// rather than as an (often empty) object.
+type ErrorMessage struct {
+	error
+}
+
+// NewErrorMessage wraps err, returning nil if there was no error.
+func NewErrorMessage(err error) *ErrorMessage {
+	if err == nil {
+		return nil
+	}
+	return &ErrorMessage{err}
+}
+
+func (e ErrorMessage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.Error())
+}
@@ -0,0 +1,139 @@
+package diag
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// The GeoIP/ASN databases given with -geodb, if any
+var GeoDbs []*MmdbReader
+
+// GeoReporter shows where the server's IP address is and which network it's on
+type GeoReporter struct{}
+
+// GeoInfo is what we know about an IP address from the GeoIP/ASN databases
+type GeoInfo struct {
+	Ip           string
+	City         string
+	Country      string
+	Asn          uint64
+	Organisation string
+}
+
+func (r GeoReporter) Name() string {
+	return "GeoIP"
+}
+
+func (r GeoReporter) Title() string {
+	return "Server Location and Network"
+}
+
+func (r GeoReporter) Description() string {
+	return "Shows the city, country and ASN of the server's IP address, using the databases given with -geodb"
+}
+
+// Follow a path of map keys through a decoded record
+func mmdbPath(v interface{}, keys ...string) interface{} {
+	for _, k := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
+
+// Return the IP address the content came from. When going through a proxy
+// this will be the proxy's address.
+func remoteIp(s *StatsCollector) (net.IP, error) {
+	if s.Session.Remote == nil {
+		return nil, errors.New("No connection was made to the server")
+	}
+	if a, ok := s.Session.Remote.(*net.TCPAddr); ok {
+		return a.IP, nil
+	}
+	host, _, err := net.SplitHostPort(s.Session.Remote.String())
+	if ip := net.ParseIP(host); err == nil && ip != nil {
+		return ip, nil
+	}
+	return nil, fmt.Errorf("Unable to get an IP address from %s", s.Session.Remote)
+}
+
+// Look the server's address up in each database in turn, so that a City and
+// an ASN database can be used together.
+func (r GeoReporter) lookup(s *StatsCollector) (*GeoInfo, error) {
+	if len(GeoDbs) == 0 {
+		return nil, errors.New("No GeoIP database was given (see -geodb)")
+	}
+	ip, err := remoteIp(s)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &GeoInfo{Ip: ip.String()}
+	for _, db := range GeoDbs {
+		rec, err := db.Lookup(ip)
+		if err != nil {
+			return nil, err
+		}
+		if v, ok := mmdbPath(rec, "city", "names", "en").(string); ok {
+			ret.City = v
+		}
+		if v, ok := mmdbPath(rec, "country", "names", "en").(string); ok {
+			ret.Country = v
+		}
+		if v, ok := mmdbPath(rec, "autonomous_system_number").(uint64); ok {
+			ret.Asn = v
+		}
+		if v, ok := mmdbPath(rec, "autonomous_system_organization").(string); ok {
+			ret.Organisation = v
+		}
+	}
+	return ret, nil
+}
+
+func (r GeoReporter) Report(s *StatsCollector) (ret string, e error) {
+	g, err := r.lookup(s)
+	if err != nil {
+		return "", err
+	}
+
+	asn := ""
+	if g.Asn != 0 {
+		asn = fmt.Sprintf("AS%d", g.Asn)
+	}
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"IP Address", "City", "Country", "ASN", "Organisation"})
+	t.Append([]string{g.Ip, g.City, g.Country, asn, g.Organisation})
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+	if g.City == "" && g.Country == "" && g.Asn == 0 {
+		tw.Write([]byte("The address wasn't found in any of the databases\n"))
+	}
+	if s.Proxy != "" {
+		tw.Write([]byte("The request went through a proxy, so this is the proxy's address\n"))
+	}
+	ret = tw.String()
+	return
+}
+
+func (r GeoReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	g, err := r.lookup(s)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"Ip":           g.Ip,
+		"City":         g.City,
+		"Country":      g.Country,
+		"Asn":          g.Asn,
+		"Organisation": g.Organisation,
+	}, nil
+}
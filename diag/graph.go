@@ -0,0 +1,148 @@
+package diag
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// How many rows the graph is drawn over
+const graphHeight = 10
+
+// GraphReporter draws the per-second transfer rate as a chart, which makes
+// the ramp up, any stalls and the tail off of a long transfer easy to see.
+type GraphReporter struct{}
+
+func (r GraphReporter) Name() string {
+	return "Throughput Graph"
+}
+
+func (r GraphReporter) Title() string {
+	return "Throughput Over Time"
+}
+
+func (r GraphReporter) Description() string {
+	return "Draws a chart of the per-second transfer rate"
+}
+
+// Return how many columns there are to draw the graph in: the width of the
+// terminal, or of $COLUMNS, or 80 if neither is known. The terminal may be on
+// stderr rather than stdout, as the report goes there when the body is on
+// stdout.
+func (r GraphReporter) width() int {
+	if w, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && w > 0 {
+		return w
+	}
+	for _, f := range []*os.File{os.Stdout, os.Stderr} {
+		if w := terminalWidth(f); w > 0 {
+			return w
+		}
+	}
+	return 80
+}
+
+// Squash the samples into at most n columns, each the average of the seconds
+// it covers, returning them along with how many seconds each covers
+func (r GraphReporter) columns(samples []float64, n int) ([]float64, int) {
+	per := (len(samples) + n - 1) / n
+	if per <= 1 {
+		return samples, 1
+	}
+	ret := []float64{}
+	for i := 0; i < len(samples); i += per {
+		end := i + per
+		if end > len(samples) {
+			end = len(samples)
+		}
+		sum := float64(0)
+		for _, v := range samples[i:end] {
+			sum += v
+		}
+		ret = append(ret, sum/float64(end-i))
+	}
+	return ret, per
+}
+
+func (r GraphReporter) Report(s *StatsCollector) (ret string, e error) {
+	if err := checkBody(s); err != nil {
+		return "", err
+	}
+	samples := ThroughputReporter{}.samples(s)
+	if len(samples) < 2 {
+		return "The transfer didn't span two whole seconds, so there's nothing to draw\n", nil
+	}
+
+	peak := float64(0)
+	for _, v := range samples {
+		peak = math.Max(peak, v)
+	}
+	labels := []string{fmt.Sprintf("%.1f", peak), fmt.Sprintf("%.1f", peak/2), "0"}
+	if Units != UnitsRaw {
+		labels = []string{fmtRate(peak), fmtRate(peak / 2), "0"}
+	}
+	lw := 0
+	for _, l := range labels {
+		if len(l) > lw {
+			lw = len(l)
+		}
+	}
+	cols, per := r.columns(samples, r.width()-lw-3)
+
+	tw := &strings.Builder{}
+	for row := graphHeight; row > 0; row-- {
+		label := ""
+		switch row {
+		case graphHeight:
+			label = labels[0]
+		case graphHeight / 2:
+			label = labels[1]
+		}
+		fmt.Fprintf(tw, "%*s |", lw, label)
+		for _, v := range cols {
+			h := 0
+			if peak > 0 {
+				h = int(math.Round(v / peak * graphHeight))
+			}
+			switch {
+			case h >= row:
+				tw.WriteByte('#')
+			case row == 1 && v > 0:
+				// Too slow to show, but not stalled
+				tw.WriteByte('.')
+			default:
+				tw.WriteByte(' ')
+			}
+		}
+		tw.WriteByte('\n')
+	}
+	fmt.Fprintf(tw, "%*s +%s\n", lw, labels[2], strings.Repeat("-", len(cols)))
+	end := fmt.Sprintf("%ds", len(samples))
+	gap := len(cols) - 2 - len(end)
+	if gap < 1 {
+		gap = 1
+	}
+	fmt.Fprintf(tw, "%*s  0s%s%s\n", lw, "", strings.Repeat(" ", gap), end)
+	if Units == UnitsRaw {
+		tw.Write([]byte("Rates are in kB/s. "))
+	}
+	tw.Write([]byte(fmt.Sprintf("Each column covers %d second(s). A . is a column with too little to show, and a gap one with nothing at all.\n", per)))
+	ret = tw.String()
+	return
+}
+
+func (r GraphReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	if err := checkBody(s); err != nil {
+		return nil, err
+	}
+	samples := ThroughputReporter{}.samples(s)
+	peak := float64(0)
+	for _, v := range samples {
+		peak = math.Max(peak, v)
+	}
+	return map[string]interface{}{
+		"PerSecond": samples,
+		"Peak":      peak,
+	}, nil
+}
@@ -0,0 +1,192 @@
+package diag
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// QuicInfo describes the QUIC connection a HTTP/3 request was made over
+type QuicInfo struct {
+	// Version is the QUIC version, e.g. v1, once there was a connection
+	Version string
+	// Used0RTT is set if the connection resumed an earlier session and
+	// sent its first request as 0-RTT data, without waiting for the
+	// handshake to finish
+	Used0RTT bool
+}
+
+// http3Transport makes requests over HTTP/3, with -http3. QUIC runs over UDP
+// and does its own TLS handshake, so rather than the dialer and trace hooks
+// of a http.Transport, it dials each connection itself, firing those hooks
+// for the DNS lookup and the handshake. It keeps hold of the connections so
+// that each request can say which QUIC version it used and whether 0-RTT was.
+type http3Transport struct {
+	*http3.Transport
+	opts  Options
+	mu    sync.Mutex
+	conns map[string]*quic.Conn
+}
+
+func newHttp3Transport(opts Options) *http3Transport {
+	t := &http3Transport{opts: opts, conns: map[string]*quic.Conn{}}
+	conf := &quic.Config{
+		// As http3 would otherwise, as servers can't open streams of
+		// their own with HTTP/3
+		MaxIncomingStreams: -1,
+	}
+	t.Transport = &http3.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify:   opts.Insecure,
+			GetClientCertificate: ClientCertificate(opts.Certificate),
+			// So that later connections to the same server can
+			// resume the session with 0-RTT
+			ClientSessionCache: tls.NewLRUClientSessionCache(0),
+		},
+		QUICConfig: conf,
+		Dial:       t.dial,
+		// We ask for gzip ourselves unless NoCompress is set
+		DisableCompression: true,
+	}
+	return t
+}
+
+// Look addr up and make a QUIC connection to it, trying each address in turn
+func (t *http3Transport) dial(ctx context.Context, addr string, tlsConf *tls.Config, conf *quic.Config) (*quic.Conn, error) {
+	trace := httptrace.ContextClientTrace(ctx)
+	if ip, ok := t.opts.Resolve[strings.ToLower(addr)]; ok {
+		_, port, _ := net.SplitHostPort(addr)
+		addr = net.JoinHostPort(ip, port)
+	}
+	network := strings.Replace(t.opts.Network, "tcp", "udp", 1)
+	if network == "" {
+		network = "udp"
+	}
+	host, service, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := net.LookupPort(network, service)
+	if err != nil {
+		return nil, err
+	}
+	// Both limits apply, as the TLS handshake is part of the connection
+	for _, limit := range []time.Duration{t.opts.DialTimeout, t.opts.TlsTimeout} {
+		if limit > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, limit)
+			defer cancel()
+		}
+	}
+
+	addrs := []net.IPAddr{}
+	if ip := net.ParseIP(host); ip != nil {
+		addrs = append(addrs, net.IPAddr{IP: ip})
+	} else {
+		resolver := t.opts.NameResolver
+		if resolver == nil {
+			resolver = net.DefaultResolver
+		}
+		if trace != nil && trace.DNSStart != nil {
+			trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+		}
+		addrs, err = resolver.LookupIPAddr(ctx, host)
+		if trace != nil && trace.DNSDone != nil {
+			trace.DNSDone(httptrace.DNSDoneInfo{Addrs: addrs, Err: err})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = &net.AddrError{Err: "no suitable address found", Addr: host}
+	for _, a := range addrs {
+		if (network == "udp4" && a.IP.To4() == nil) || (network == "udp6" && a.IP.To4() != nil) {
+			continue
+		}
+		raddr := &net.UDPAddr{IP: a.IP, Port: port, Zone: a.Zone}
+		var conn *quic.Conn
+		if conn, err = t.dialAddr(ctx, trace, network, raddr, tlsConf, conf); err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
+
+// Make a QUIC connection to a single address, over a UDP socket of its own
+// that's closed along with it
+func (t *http3Transport) dialAddr(ctx context.Context, trace *httptrace.ClientTrace, network string, raddr *net.UDPAddr,
+	tlsConf *tls.Config, conf *quic.Config) (*quic.Conn, error) {
+	if trace != nil && trace.ConnectStart != nil {
+		trace.ConnectStart(network, raddr.String())
+	}
+	udp, err := net.ListenUDP(network, nil)
+	var conn *quic.Conn
+	if err == nil {
+		conn, err = quic.DialEarly(ctx, udp, raddr, tlsConf, conf)
+	}
+	if err != nil {
+		if udp != nil {
+			udp.Close()
+		}
+		if trace != nil && trace.ConnectDone != nil {
+			trace.ConnectDone(network, raddr.String(), err)
+		}
+		return nil, err
+	}
+	if trace != nil && trace.ConnectDone != nil {
+		trace.ConnectDone(network, raddr.String(), nil)
+	}
+	// With 0-RTT, the handshake is still going, so what was agreed is
+	// only recorded once there's a response
+	if state := conn.ConnectionState().TLS; state.HandshakeComplete && trace != nil && trace.TLSHandshakeDone != nil {
+		trace.TLSHandshakeDone(state, nil)
+	}
+
+	local := conn.LocalAddr().String()
+	t.mu.Lock()
+	t.conns[local] = conn
+	t.mu.Unlock()
+	go func() {
+		<-conn.Context().Done()
+		t.mu.Lock()
+		delete(t.conns, local)
+		t.mu.Unlock()
+		udp.Close()
+	}()
+	return conn, nil
+}
+
+// Fill in s.Quic from the connection the response came over, once there was
+// one, along with what was agreed in its TLS handshake if that was still going
+// when it was dialled
+func (t *http3Transport) describe(s *StatsCollector) {
+	if s.Session.Local == nil {
+		return
+	}
+	t.mu.Lock()
+	conn := t.conns[s.Session.Local.String()]
+	t.mu.Unlock()
+	if conn == nil {
+		return
+	}
+	state := conn.ConnectionState()
+	s.Quic.Version = state.Version.String()
+	s.Quic.Used0RTT = state.Used0RTT
+	if s.Tls.Version == 0 && !s.Session.Reused {
+		s.setTlsState(state.TLS)
+		if t.opts.Insecure {
+			s.CheckVerification(state.TLS)
+		}
+	}
+}
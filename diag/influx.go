@@ -0,0 +1,149 @@
+package diag
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfluxReporter writes the timings and byte counts as InfluxDB line protocol,
+// so they can be written straight into InfluxDB, or posted with -influxUrl.
+type InfluxReporter struct{}
+
+func (r InfluxReporter) Name() string {
+	return "InfluxDB Line Protocol"
+}
+
+func (r InfluxReporter) Title() string {
+	return "InfluxDB Line Protocol"
+}
+
+func (r InfluxReporter) Description() string {
+	return "Shows timings and byte counts as InfluxDB line protocol"
+}
+
+func (r InfluxReporter) Raw() bool {
+	return true
+}
+
+// Escape a tag key or value as required by the line protocol
+func influxEscape(v string) string {
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", "").Replace(v)
+}
+
+// Return the tags for the request
+func (r InfluxReporter) tags(s *StatsCollector) map[string]string {
+	ret := map[string]string{"status": strconv.Itoa(s.StatusCode)}
+	if u, err := url.Parse(s.Uri); err == nil {
+		ret["host"], ret["scheme"] = u.Hostname(), u.Scheme
+	}
+	return ret
+}
+
+// Return the fields for the request, already formatted. Integers are marked as
+// such with an i suffix, and phases that didn't happen are left out, as are
+// the byte counts with -ttfbOnly.
+func (r InfluxReporter) fields(s *StatsCollector) map[string]string {
+	ret := map[string]string{}
+	for _, p := range []struct {
+		name  string
+		phase Phase
+	}{
+		{"dns_seconds", PhaseDns},
+		{"connect_seconds", PhaseConnect},
+		{"tls_seconds", PhaseTls},
+		{"ttfb_seconds", PhaseFirstByte},
+		{"transfer_seconds", PhaseTransfer},
+		{"total_seconds", PhaseTotal},
+	} {
+		if d, ok := s.PhaseDuration(p.phase); ok {
+			ret[p.name] = strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+		}
+	}
+	if s.TransferSkipped {
+		return ret
+	}
+	ret["transfer_bytes"] = fmt.Sprintf("%di", s.TotalBytesTransferred())
+	ret["throughput_bytes_per_second"] = strconv.FormatFloat(s.KBPerSecond()*1024, 'f', -1, 64)
+	if s.WireBytes() > 0 {
+		ret["wire_bytes"] = fmt.Sprintf("%di", s.WireBytes())
+	}
+	return ret
+}
+
+// Return the keys of a map in order, so that lines always come out the same
+func sortedKeys(m map[string]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Return the line for the request, timestamped in nanoseconds with when it
+// was started
+func (r InfluxReporter) line(s *StatsCollector) string {
+	b := &strings.Builder{}
+	b.WriteString("web3diag")
+	tags := r.tags(s)
+	for _, k := range sortedKeys(tags) {
+		if tags[k] != "" {
+			fmt.Fprintf(b, ",%s=%s", k, influxEscape(tags[k]))
+		}
+	}
+	fields := r.fields(s)
+	for i, k := range sortedKeys(fields) {
+		sep := ","
+		if i == 0 {
+			sep = " "
+		}
+		fmt.Fprintf(b, "%s%s=%s", sep, k, fields[k])
+	}
+	ts := s.Total.StartTime
+	if ts == 0 {
+		ts = time.Now().UnixNano()
+	}
+	fmt.Fprintf(b, " %d\n", ts)
+	return b.String()
+}
+
+func (r InfluxReporter) Report(s *StatsCollector) (ret string, e error) {
+	return r.line(s), nil
+}
+
+func (r InfluxReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"Tags": r.tags(s),
+		"Line": strings.TrimSuffix(r.line(s), "\n"),
+	}, nil
+}
+
+// Post the line for each successful run to an InfluxDB write endpoint, such as
+// http://localhost:8086/write?db=web3diag
+func PostInflux(runs []*StatsCollector, errs []error, uri string) error {
+	body := &strings.Builder{}
+	for i, s := range runs {
+		if errs[i] == nil {
+			body.WriteString(InfluxReporter{}.line(s))
+		}
+	}
+	if body.Len() == 0 {
+		return nil
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(uri, "text/plain; charset=utf-8", strings.NewReader(body.String()))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("InfluxDB returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package diag
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// IpfsUri holds the parts of an ipfs:// or ipns:// URI, so that reporters can
+// check what the gateway gave us against what was asked for.
+type IpfsUri struct {
+	Uri       string
+	Namespace string
+	// Cid is the content identifier for ipfs://, or the name for ipns://
+	Cid  string
+	Path string
+}
+
+// ContentPath returns the path as a gateway would see it, e.g. /ipfs/<cid>/a
+func (u IpfsUri) ContentPath() string {
+	return fmt.Sprintf("/%s/%s%s", u.Namespace, u.Cid, u.Path)
+}
+
+// Parse an ipfs:// or ipns:// URI into its parts.
+func ParseIpfsUri(uri string) (*IpfsUri, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	ns := strings.ToLower(u.Scheme)
+	if ns != "ipfs" && ns != "ipns" {
+		return nil, fmt.Errorf("'%s' is not an ipfs:// or ipns:// URI", uri)
+	}
+	if u.Host == "" {
+		return nil, errors.New("no CID or name given in URI")
+	}
+	return &IpfsUri{
+		Uri:       uri,
+		Namespace: ns,
+		Cid:       u.Host,
+		Path:      u.EscapedPath(),
+	}, nil
+}
+
+// GatewayUri returns the URI to request the content from the given HTTP(S)
+// gateway using a path-style request (e.g. https://ipfs.io/ipfs/<cid>/a).
+func (u IpfsUri) GatewayUri(gateway string) string {
+	ret := strings.TrimRight(gateway, "/") + u.ContentPath()
+	if i := strings.Index(u.Uri, "?"); i >= 0 {
+		ret += u.Uri[i:]
+	}
+	return ret
+}
+
+// The ways a gateway can serve content, as told apart by GatewayMode. In
+// path mode every CID shares the gateway's origin, where in subdomain mode
+// each gets its own, so that sites can't get at each other's cookies and
+// storage.
+const (
+	gatewayPath      = "path"
+	gatewaySubdomain = "subdomain"
+)
+
+// GatewayMode returns whether a gateway URL is path style (gw/ipfs/<cid>) or
+// subdomain style (<cid>.ipfs.gw), or an empty string if it's neither.
+func GatewayMode(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return ""
+	}
+	labels := strings.Split(strings.ToLower(u.Hostname()), ".")
+	if len(labels) > 2 && (labels[1] == "ipfs" || labels[1] == "ipns") {
+		return gatewaySubdomain
+	}
+	if strings.HasPrefix(u.Path, "/ipfs/") || strings.HasPrefix(u.Path, "/ipns/") {
+		return gatewayPath
+	}
+	return ""
+}
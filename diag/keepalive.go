@@ -0,0 +1,210 @@
+package diag
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// KeepAliveReporter shows whether each request reused a kept-alive connection,
+// and with -count, KeepAliveSummary shows how well the connection held up
+// over the runs. A gateway that drops idle connections early makes every
+// request pay for a new connection and handshake.
+type KeepAliveReporter struct{}
+
+func (r KeepAliveReporter) Name() string {
+	return "KeepAlive"
+}
+
+func (r KeepAliveReporter) Title() string {
+	return "Connection Keepalive"
+}
+
+func (r KeepAliveReporter) Description() string {
+	return "Shows whether the connection was reused, and with -count, how often the server closed it between requests"
+}
+
+// Return why the server won't keep the connection open after this response,
+// or an empty string if it will
+func closeReason(s *StatsCollector) string {
+	switch {
+	case !s.Close:
+		return ""
+	case s.ProtoMajor == 1 && s.ProtoMinor == 0:
+		return "the response was HTTP/1.0 without keep-alive"
+	}
+	return "the response said Connection: close"
+}
+
+// Return the time spent setting up a new connection, which is nothing for a
+// reused one
+func handshakeTime(s *StatsCollector) (time.Duration, bool) {
+	connect, ok := s.PhaseDuration(PhaseConnect)
+	if !ok {
+		return 0, false
+	}
+	if tls, ok := s.PhaseDuration(PhaseTls); ok {
+		connect += tls
+	}
+	return connect, true
+}
+
+// Format a time for a table, or as n/a if there wasn't one
+func secondsOrNa(d time.Duration, ok bool) string {
+	if !ok {
+		return "n/a"
+	}
+	return fmtDuration(d)
+}
+
+// Return a time in seconds, or nil if there wasn't one, for Data
+func secondsOrNil(d time.Duration, ok bool) interface{} {
+	if !ok {
+		return nil
+	}
+	return d.Seconds()
+}
+
+// Return the local address of the connection, or n/a if there wasn't one
+func localAddr(s *StatsCollector) string {
+	if s.Session.Local == nil {
+		return "n/a"
+	}
+	return s.Session.Local.String()
+}
+
+func (r KeepAliveReporter) Report(s *StatsCollector) (ret string, e error) {
+	if err := checkResponse(s); err != nil {
+		return "", err
+	}
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"Local Address", "Reused", withUnit("Idle", "s"), withUnit("Handshake", "s"), withUnit("TTFB", "s")})
+	idle := "n/a"
+	if s.Session.WasIdle {
+		idle = fmtDuration(time.Duration(s.Session.IdleTime))
+	}
+	handshake, ok := handshakeTime(s)
+	ttfb, ttfbOk := s.PhaseDuration(PhaseFirstByte)
+	t.Append([]string{
+		localAddr(s),
+		fmt.Sprintf("%t", s.Session.Reused),
+		idle,
+		secondsOrNa(handshake, ok),
+		secondsOrNa(ttfb, ttfbOk),
+	})
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+
+	if s.Session.KeepAliveDisabled {
+		tw.Write([]byte("Keepalives were disabled with -noKeepAlive, so the connection won't be reused\n"))
+	} else if why := closeReason(s); why != "" {
+		tw.Write([]byte(fmt.Sprintf("The connection won't be reused, as %s\n", why)))
+	}
+	ret = tw.String()
+	return
+}
+
+func (r KeepAliveReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	if err := checkResponse(s); err != nil {
+		return nil, err
+	}
+	local := interface{}(nil)
+	if s.Session.Local != nil {
+		local = s.Session.Local.String()
+	}
+	ret := map[string]interface{}{
+		"LocalAddress": local,
+		"Reused":       s.Session.Reused,
+		"WasIdle":      s.Session.WasIdle,
+		"IdleTime":     nsSeconds(s.Session.IdleTime),
+		"Handshake":    secondsOrNil(handshakeTime(s)),
+		"FirstByte":    secondsOrNil(s.PhaseDuration(PhaseFirstByte)),
+		"WillClose":    closeReason(s) != "",
+		"Disabled":     s.Session.KeepAliveDisabled,
+	}
+	return ret, nil
+}
+
+// KeepAliveSummary shows which of several sequential runs reused the
+// connection and which had to make a new one, and why if the server said, along
+// with how the time to first byte changed over the runs. Reuse is false with
+// -reuse=false or -noKeepAlive, when every run makes a new connection on
+// purpose.
+func KeepAliveSummary(runs []*StatsCollector, reuse bool) string {
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"Run", "Local Address", "Connection", withUnit("Handshake", "s"), withUnit("TTFB", "s")})
+	reused, fresh, dropped := 0, 0, []string{}
+	var reusedTtfb, freshTtfb []float64
+	var xs, ys []float64
+	for i, s := range runs {
+		if s.ResponseHeaders == nil {
+			// The request failed before there was a response
+			continue
+		}
+		conn := "reused"
+		if !s.Session.Reused {
+			fresh++
+			conn = "new"
+			if i > 0 {
+				// Any new connection after the first means the last
+				// one wasn't kept open
+				why := "closed by the server"
+				if s.Session.KeepAliveDisabled {
+					why = "-noKeepAlive"
+				} else if !reuse {
+					why = "-reuse=false"
+				} else if prev := runs[i-1]; prev.ResponseHeaders != nil && closeReason(prev) != "" {
+					why = closeReason(prev)
+				}
+				conn = "new: " + why
+				if reuse {
+					dropped = append(dropped, fmt.Sprintf("%d", i+1))
+				}
+			}
+		} else {
+			reused++
+		}
+		t.Append([]string{fmt.Sprintf("%d", i+1), localAddr(s), conn,
+			secondsOrNa(handshakeTime(s)), secondsOrNa(s.PhaseDuration(PhaseFirstByte))})
+		if d, ok := s.PhaseDuration(PhaseFirstByte); ok {
+			xs, ys = append(xs, float64(i+1)), append(ys, d.Seconds())
+			if s.Session.Reused {
+				reusedTtfb = append(reusedTtfb, d.Seconds())
+			} else {
+				freshTtfb = append(freshTtfb, d.Seconds())
+			}
+		}
+	}
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+
+	tw.Write([]byte(fmt.Sprintf("%d of %d requests reused a connection, and %d made a new one\n",
+		reused, reused+fresh, fresh)))
+	if len(dropped) > 0 {
+		tw.Write([]byte(fmt.Sprintf("WARNING: a new connection was needed for run(s) %s, so the server isn't keeping connections alive\n",
+			strings.Join(dropped, ", "))))
+	}
+	if len(reusedTtfb) > 0 && len(freshTtfb) > 0 {
+		tw.Write([]byte(fmt.Sprintf("Mean time to first byte: %s on a reused connection, %s on a new one\n",
+			SecondsText(Summarise(reusedTtfb).Mean), SecondsText(Summarise(freshTtfb).Mean))))
+	}
+	if len(xs) > 1 {
+		// The least squares slope of TTFB over the runs, which shows
+		// whether the server gets slower the longer a connection is in use
+		mx, my := Summarise(xs).Mean, Summarise(ys).Mean
+		num, den := 0.0, 0.0
+		for i := range xs {
+			num += (xs[i] - mx) * (ys[i] - my)
+			den += (xs[i] - mx) * (xs[i] - mx)
+		}
+		tw.Write([]byte(fmt.Sprintf("Time to first byte went from %s in the first run to %s in the last, a trend of %+.3f ms per request\n",
+			SecondsText(ys[0]), SecondsText(ys[len(ys)-1]), num/den*1000)))
+	}
+	return tw.String()
+}
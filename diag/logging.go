@@ -0,0 +1,51 @@
+package diag
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// Logging levels, from least to most chatty
+const (
+	// LogQuiet only logs errors
+	LogQuiet = iota
+	// LogNormal also logs the main milestones of each request
+	LogNormal
+	// LogVerbose also logs every trace event and header
+	LogVerbose
+)
+
+// The current logging level, which web3diag sets from the -quiet and -verbose
+// flags
+var LogLevel = LogNormal
+
+func logAt(level int, format string, v ...interface{}) {
+	if LogLevel >= level {
+		log.Printf(format, v...)
+	}
+}
+
+// Log an error, which is logged whatever the level
+func LogError(format string, v ...interface{}) {
+	logAt(LogQuiet, format, v...)
+}
+
+// Log a milestone in a request
+func LogInfo(format string, v ...interface{}) {
+	logAt(LogNormal, format, v...)
+}
+
+// Log the details of a trace event
+func logVerbose(format string, v ...interface{}) {
+	logAt(LogVerbose, format, v...)
+}
+
+// Write a copy of the JSON representation of the stats to the log
+func logStats(s *StatsCollector) {
+	j, err := json.Marshal(s)
+	if err != nil {
+		LogError("Unable to marshal stats: %s", err)
+		return
+	}
+	LogInfo("%s", j)
+}
@@ -0,0 +1,267 @@
+package diag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"os"
+)
+
+// The metadata section of a MaxMind DB file starts after this marker, which
+// is the last occurrence of it in the file.
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// Data section types, from the MaxMind DB format spec
+const (
+	mmdbExtended = iota
+	mmdbPointer
+	mmdbString
+	mmdbDouble
+	mmdbBytes
+	mmdbUint16
+	mmdbUint32
+	mmdbMap
+	mmdbInt32
+	mmdbUint64
+	mmdbUint128
+	mmdbArray
+	mmdbContainer
+	mmdbEndMarker
+	mmdbBool
+	mmdbFloat
+)
+
+// MmdbReader looks IP addresses up in a MaxMind DB (.mmdb) file, such as the
+// GeoLite2 City and ASN databases. The whole file is read into memory, and
+// records are decoded into generic maps rather than typed structs.
+type MmdbReader struct {
+	buf        []byte
+	nodeCount  uint64
+	recordSize uint64
+	ipVersion  uint64
+	// dataStart is the offset of the data section in buf
+	dataStart uint64
+}
+
+// Open a MaxMind DB file and read its metadata.
+func OpenMmdb(path string) (*MmdbReader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	i := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("%s is not a MaxMind DB file", path)
+	}
+	start := uint64(i + len(mmdbMetadataMarker))
+	meta, _, err := mmdbDecoder{buf, start}.decode(start)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read metadata from %s: %w", path, err)
+	}
+	m, ok := meta.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unable to read metadata from %s", path)
+	}
+
+	r := &MmdbReader{buf: buf}
+	r.nodeCount, _ = m["node_count"].(uint64)
+	r.recordSize, _ = m["record_size"].(uint64)
+	r.ipVersion, _ = m["ip_version"].(uint64)
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d in %s", r.recordSize, path)
+	}
+	r.dataStart = r.nodeCount*r.recordSize/4 + 16
+	if r.dataStart > uint64(len(buf)) {
+		return nil, fmt.Errorf("%s is truncated", path)
+	}
+	return r, nil
+}
+
+// Read one of the two records of a node in the search tree
+func (r *MmdbReader) record(node uint64, right bool) uint64 {
+	n := r.buf[node*r.recordSize/4:]
+	switch r.recordSize {
+	case 24:
+		if right {
+			n = n[3:]
+		}
+		return uint64(n[0])<<16 | uint64(n[1])<<8 | uint64(n[2])
+	case 28:
+		if right {
+			return uint64(n[3]&0x0f)<<24 | uint64(n[4])<<16 | uint64(n[5])<<8 | uint64(n[6])
+		}
+		return uint64(n[3]&0xf0)<<20 | uint64(n[0])<<16 | uint64(n[1])<<8 | uint64(n[2])
+	}
+	if right {
+		n = n[4:]
+	}
+	return uint64(binary.BigEndian.Uint32(n))
+}
+
+// Look an IP address up, returning its record or nil if there isn't one.
+func (r *MmdbReader) Lookup(ip net.IP) (interface{}, error) {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		if r.ipVersion == 6 {
+			// IPv4 addresses live under ::/96 in an IPv6 tree
+			ip = append(make(net.IP, 12), ip4...)
+		}
+	} else if r.ipVersion == 4 {
+		return nil, errors.New("IPv6 addresses can't be looked up in an IPv4 database")
+	}
+
+	node := uint64(0)
+	for i := 0; i < len(ip)*8 && node < r.nodeCount; i++ {
+		if node*r.recordSize/4+r.recordSize/4 > uint64(len(r.buf)) {
+			return nil, errors.New("search tree is truncated")
+		}
+		node = r.record(node, ip[i/8]&(0x80>>(i%8)) != 0)
+	}
+	if node == r.nodeCount {
+		return nil, nil
+	}
+	if node < r.nodeCount {
+		return nil, errors.New("search tree is deeper than an IP address")
+	}
+	offset := r.dataStart + node - r.nodeCount - 16
+	v, _, err := mmdbDecoder{r.buf, r.dataStart}.decode(offset)
+	return v, err
+}
+
+// mmdbDecoder decodes values from a data section starting at base, which is
+// what pointers are relative to.
+type mmdbDecoder struct {
+	buf  []byte
+	base uint64
+}
+
+// Read n bytes at offset as a big-endian unsigned integer
+func (d mmdbDecoder) uint(offset uint64, n uint64) (uint64, error) {
+	if offset+n > uint64(len(d.buf)) {
+		return 0, errors.New("unexpected end of data")
+	}
+	v := uint64(0)
+	for _, b := range d.buf[offset : offset+n] {
+		v = v<<8 | uint64(b)
+	}
+	return v, nil
+}
+
+// Decode the value at offset, returning it and the offset just after it.
+func (d mmdbDecoder) decode(offset uint64) (interface{}, uint64, error) {
+	if offset >= uint64(len(d.buf)) {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+	ctrl := d.buf[offset]
+	offset++
+	typ := uint64(ctrl >> 5)
+
+	if typ == mmdbPointer {
+		ss := uint64(ctrl>>3) & 3
+		v, err := d.uint(offset, ss+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		switch ss {
+		case 0:
+			v |= uint64(ctrl&7) << 8
+		case 1:
+			v = (v | uint64(ctrl&7)<<16) + 2048
+		case 2:
+			v = (v | uint64(ctrl&7)<<24) + 526336
+		}
+		// The value pointed to is never itself a pointer
+		ret, _, err := d.decode(d.base + v)
+		return ret, offset + ss + 1, err
+	}
+
+	if typ == mmdbExtended {
+		if offset >= uint64(len(d.buf)) {
+			return nil, 0, errors.New("unexpected end of data")
+		}
+		typ = 7 + uint64(d.buf[offset])
+		offset++
+	}
+
+	size := uint64(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		v, err := d.uint(offset, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += n
+		size = []uint64{29, 285, 65821}[n-1] + v
+	}
+
+	switch typ {
+	case mmdbMap:
+		m := make(map[string]interface{}, size)
+		for i := uint64(0); i < size; i++ {
+			k, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			if m[key], offset, err = d.decode(next); err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, offset, nil
+	case mmdbArray:
+		a := make([]interface{}, size)
+		for i := range a {
+			var err error
+			if a[i], offset, err = d.decode(offset); err != nil {
+				return nil, 0, err
+			}
+		}
+		return a, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint64(len(d.buf)) {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+	b := d.buf[offset : offset+size]
+	offset += size
+	switch typ {
+	case mmdbString:
+		return string(b), offset, nil
+	case mmdbBytes:
+		return append([]byte{}, b...), offset, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid double")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64:
+		v := uint64(0)
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, offset, nil
+	case mmdbInt32:
+		v := uint32(0)
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		return int64(int32(v)), offset, nil
+	case mmdbUint128:
+		return new(big.Int).SetBytes(b), offset, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", typ)
+}
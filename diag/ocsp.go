@@ -0,0 +1,128 @@
+package diag
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// The ASN.1 structures for an OCSP response, from RFC 6960. Only as much as we
+// need to pull out the certificate status is decoded, and the signature on
+// the response is not checked, as we're reporting on it rather than relying
+// on it.
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    ocspResponseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Raw            asn1.RawContent
+	Version        int `asn1:"optional,default:0,explicit,tag:0"`
+	RawResponderID asn1.RawValue
+	ProducedAt     time.Time `asn1:"generalized"`
+	Responses      []ocspSingleResponse
+}
+
+type ocspSingleResponse struct {
+	CertID           ocspCertID
+	Good             asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked          ocspRevokedInfo  `asn1:"tag:1,optional"`
+	Unknown          asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate       time.Time        `asn1:"generalized"`
+	NextUpdate       time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	SingleExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+var oidOcspBasic = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+
+// Names for the responseStatus values in an OCSP response
+var ocspResponseStatus = map[asn1.Enumerated]string{
+	0: "successful",
+	1: "malformedRequest",
+	2: "internalError",
+	3: "tryLater",
+	5: "sigRequired",
+	6: "unauthorized",
+}
+
+// OcspInfo is the status of a certificate from a stapled OCSP response
+type OcspInfo struct {
+	Status     string
+	ProducedAt time.Time
+	ThisUpdate time.Time
+	NextUpdate time.Time
+	RevokedAt  time.Time
+}
+
+// Parse a DER encoded OCSP response, as stapled to a TLS handshake, and return
+// the status of the first certificate in it.
+func ParseOcspResponse(der []byte) (*OcspInfo, error) {
+	resp := ocspResponse{}
+	if rest, err := asn1.Unmarshal(der, &resp); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, errors.New("trailing data in OCSP response")
+	}
+	if resp.Status != 0 {
+		name, ok := ocspResponseStatus[resp.Status]
+		if !ok {
+			name = fmt.Sprintf("%d", resp.Status)
+		}
+		return nil, fmt.Errorf("OCSP responder returned status %s", name)
+	}
+	if !resp.Response.ResponseType.Equal(oidOcspBasic) {
+		return nil, errors.New("OCSP response is not a basic response")
+	}
+
+	basic := ocspBasicResponse{}
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return nil, err
+	}
+	if len(basic.TBSResponseData.Responses) == 0 {
+		return nil, errors.New("OCSP response contains no certificate status")
+	}
+
+	r := basic.TBSResponseData.Responses[0]
+	ret := &OcspInfo{
+		ProducedAt: basic.TBSResponseData.ProducedAt,
+		ThisUpdate: r.ThisUpdate,
+		NextUpdate: r.NextUpdate,
+	}
+	switch {
+	case bool(r.Good):
+		ret.Status = "good"
+	case bool(r.Unknown):
+		ret.Status = "unknown"
+	default:
+		ret.Status = "revoked"
+		ret.RevokedAt = r.Revoked.RevocationTime
+	}
+	return ret, nil
+}
@@ -0,0 +1,218 @@
+package diag
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The OTLP/HTTP JSON encoding of a trace, as much of it as we need. IDs are
+// hex, and 64 bit integers are strings.
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+// Span kinds and status codes, from the OTLP protobuf definitions
+const (
+	otlpKindInternal = 1
+	otlpKindClient   = 3
+	otlpStatusOk     = 1
+	otlpStatusError  = 2
+)
+
+func otlpString(k string, v string) otlpAttribute {
+	return otlpAttribute{k, otlpValue{StringValue: &v}}
+}
+
+func otlpInt(k string, v int64) otlpAttribute {
+	i := strconv.FormatInt(v, 10)
+	return otlpAttribute{k, otlpValue{IntValue: &i}}
+}
+
+// Return a random ID of n bytes, hex encoded
+func otlpId(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// The TLS version as OpenTelemetry names it, e.g. 1.3
+func otlpTlsVersion(v uint16) string {
+	if n := TlsVersionNumber(v); n != "" {
+		return n
+	}
+	return fmt.Sprintf("%x", v)
+}
+
+// Turn the stats for a request into a trace, with a client span covering the
+// whole request and a child span for each phase that happened. The trace ID
+// is returned too.
+func otlpSpans(s *StatsCollector) ([]otlpSpan, string) {
+	traceId, rootId := otlpId(16), otlpId(8)
+
+	// A request that failed won't have finished, so the root span ends
+	// with whatever happened last.
+	start, end, ok := s.PhaseTimes(PhaseTotal)
+	if !ok {
+		start = s.Total.StartTime
+		for _, p := range []Phase{PhaseDns, PhaseConnect, PhaseTls, PhaseRequest, PhaseFirstByte, PhaseTransfer} {
+			if _, e, ok := s.PhaseTimes(p); ok && e > end {
+				end = e
+			}
+		}
+		if end < start {
+			end = start
+		}
+	}
+
+	attrs := []otlpAttribute{
+		otlpString("url.full", s.Uri),
+		otlpString("http.request.method", s.Request.Method),
+	}
+	if u, err := url.Parse(s.Uri); err == nil {
+		attrs = append(attrs, otlpString("server.address", u.Hostname()))
+	}
+	if ip, err := remoteIp(s); err == nil {
+		attrs = append(attrs, otlpString("network.peer.address", ip.String()))
+	}
+	if s.StatusCode != 0 {
+		attrs = append(attrs,
+			otlpInt("http.response.status_code", int64(s.StatusCode)),
+			otlpString("network.protocol.version", fmt.Sprintf("%d.%d", s.ProtoMajor, s.ProtoMinor)))
+		if !s.TransferSkipped {
+			attrs = append(attrs, otlpInt("http.response.body.size", int64(s.TotalBytesTransferred())))
+		}
+	}
+	if s.Tls.Version != 0 {
+		attrs = append(attrs, otlpString("tls.protocol.version", otlpTlsVersion(s.Tls.Version)))
+	}
+	status := &otlpStatus{Code: otlpStatusOk}
+	if s.Error != nil {
+		status = &otlpStatus{Code: otlpStatusError, Message: s.Error.Error()}
+	}
+
+	spans := []otlpSpan{{
+		TraceId:           traceId,
+		SpanId:            rootId,
+		Name:              s.Request.Method,
+		Kind:              otlpKindClient,
+		StartTimeUnixNano: strconv.FormatInt(start, 10),
+		EndTimeUnixNano:   strconv.FormatInt(end, 10),
+		Attributes:        attrs,
+		Status:            status,
+	}}
+
+	for _, p := range []struct {
+		name  string
+		phase Phase
+		attrs []otlpAttribute
+	}{
+		{"dns", PhaseDns, []otlpAttribute{otlpString("dns.question.name", s.Dns.Host)}},
+		{"connect", PhaseConnect, []otlpAttribute{otlpString("network.peer.address", s.Connection.Address)}},
+		{"tls", PhaseTls, []otlpAttribute{otlpString("tls.protocol.version", otlpTlsVersion(s.Tls.Version)),
+			otlpString("tls.server.name", s.Tls.ServerName)}},
+		{"request", PhaseRequest, nil},
+		{"ttfb", PhaseFirstByte, nil},
+		{"transfer", PhaseTransfer, []otlpAttribute{otlpInt("http.response.body.size", int64(s.TotalBytesTransferred()))}},
+	} {
+		start, end, ok := s.PhaseTimes(p.phase)
+		if !ok {
+			continue
+		}
+		spans = append(spans, otlpSpan{
+			TraceId:           traceId,
+			SpanId:            otlpId(8),
+			ParentSpanId:      rootId,
+			Name:              p.name,
+			Kind:              otlpKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(start, 10),
+			EndTimeUnixNano:   strconv.FormatInt(end, 10),
+			Attributes:        p.attrs,
+		})
+	}
+	return spans, traceId
+}
+
+// Export each run as a trace to an OpenTelemetry collector using OTLP/HTTP
+// with JSON encoding. The endpoint is the collector's base URL, e.g.
+// http://localhost:4318, to which /v1/traces is added.
+func ExportOtlp(runs []*StatsCollector, endpoint string) error {
+	spans := []otlpSpan{}
+	for i, s := range runs {
+		if s.Total.StartTime == 0 {
+			// The request was never made
+			continue
+		}
+		sp, traceId := otlpSpans(s)
+		spans = append(spans, sp...)
+		LogInfo("Exporting run %d as trace %s", i+1, traceId)
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+
+	name := "web3diag"
+	doc := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpAttribute{otlpString("service.name", name)},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": name},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasSuffix(endpoint, "/v1/traces") {
+		endpoint = strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("the collector returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package diag
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// OverheadReporter shows how much of what was sent and received was headers
+// rather than body. For a small block from a gateway, the headers can easily
+// be bigger than the content, which the throughput doesn't show, as it only
+// counts the body.
+type OverheadReporter struct{}
+
+func (r OverheadReporter) Name() string {
+	return "Overhead"
+}
+
+func (r OverheadReporter) Title() string {
+	return "Header Overhead"
+}
+
+func (r OverheadReporter) Description() string {
+	return "Shows the size of the request and response headers alongside the bodies"
+}
+
+func (r OverheadReporter) check(s *StatsCollector) error {
+	if s.HeaderBytes.Response == 0 {
+		return errors.New("There was no response")
+	}
+	return nil
+}
+
+// Return the percentage of a total that's headers, or 0 if there's nothing
+func headerShare(headers, body uint64) float64 {
+	if headers+body == 0 {
+		return 0
+	}
+	return float64(headers) / float64(headers+body) * 100
+}
+
+func (r OverheadReporter) Report(s *StatsCollector) (ret string, e error) {
+	if err := r.check(s); err != nil {
+		return "", err
+	}
+	req, resp := s.HeaderBytes.Request, s.HeaderBytes.Response+s.HeaderBytes.Trailers
+	body := s.EncodedBytes()
+
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"", "Headers", "Body", "Total", "Headers (%)"})
+	row := func(name string, headers, body uint64) {
+		t.Append([]string{name,
+			fmtBytes(headers),
+			fmtBytes(body),
+			fmtBytes(headers + body),
+			fmt.Sprintf("%.1f", headerShare(headers, body)),
+		})
+	}
+	row("Request", req, s.Upload.Bytes)
+	row("Response", resp, body)
+	row("Total", req+resp, s.Upload.Bytes+body)
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+
+	if s.HeaderBytes.Trailers > 0 {
+		tw.Write([]byte(fmt.Sprintf("The response headers include %s of trailers\n", bytesText(s.HeaderBytes.Trailers))))
+	}
+	if s.TransferSkipped {
+		tw.Write([]byte("The body wasn't read, as -ttfbOnly was given\n"))
+	} else if req+resp > s.Upload.Bytes+body {
+		tw.Write([]byte(fmt.Sprintf("The headers were %.1f%% of everything sent and received, more than the content\n",
+			headerShare(req+resp, s.Upload.Bytes+body))))
+	}
+	if s.ProtoMajor >= 2 {
+		tw.Write([]byte(fmt.Sprintf("Headers are counted as HTTP/1.1 would send them, and %s compresses them, so they took less on the wire\n", s.Proto)))
+	}
+	if s.Wire.Read > 0 {
+		tw.Write([]byte(fmt.Sprintf("On the wire, %s were written and %s read, which also counts any TLS and framing\n",
+			bytesText(s.Wire.Written), bytesText(s.Wire.Read))))
+	}
+	ret = tw.String()
+	return
+}
+
+func (r OverheadReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	if err := r.check(s); err != nil {
+		return nil, err
+	}
+	req, resp := s.HeaderBytes.Request, s.HeaderBytes.Response+s.HeaderBytes.Trailers
+	ret := map[string]interface{}{
+		"RequestHeaders":   s.HeaderBytes.Request,
+		"RequestBody":      s.Upload.Bytes,
+		"ResponseHeaders":  s.HeaderBytes.Response,
+		"ResponseTrailers": s.HeaderBytes.Trailers,
+		"ResponseBody":     s.EncodedBytes(),
+		"HeaderShare":      headerShare(req+resp, s.Upload.Bytes+s.EncodedBytes()),
+		"TransferSkipped":  s.TransferSkipped,
+	}
+	if s.Wire.Read > 0 {
+		ret["WireWritten"] = s.Wire.Written
+		ret["WireRead"] = s.Wire.Read
+	}
+	return ret, nil
+}
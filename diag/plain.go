@@ -0,0 +1,96 @@
+package diag
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PlainReport renders the data from a reporter as one "key: value" line per
+// value, for -format plain, which reads better than a table in a narrow
+// terminal or a CI log. It's built from Data rather than Report, so it's the
+// same information as -format json: nested values get dotted keys, entries in
+// a list get their index, and missing values are shown as n/a.
+func PlainReport(r Reporter, s *StatsCollector) (string, error) {
+	d, err := r.Data(s)
+	if err != nil {
+		return "", err
+	}
+	// Go through JSON, so that structs, times and so on come out as they
+	// do with -format json, and numbers aren't rounded
+	b, err := json.Marshal(d)
+	if err != nil {
+		return "", err
+	}
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return "", err
+	}
+	tw := &strings.Builder{}
+	writePlain(tw, "", v)
+	return tw.String(), nil
+}
+
+// Write a value decoded from JSON as "key: value" lines, with key as the
+// prefix for anything nested in it
+func writePlain(tw *strings.Builder, key string, v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := []string{}
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if key != "" {
+				writePlain(tw, key+"."+k, v[k])
+			} else {
+				writePlain(tw, k, v[k])
+			}
+		}
+		if len(v) == 0 && key != "" {
+			fmt.Fprintf(tw, "%s: none\n", key)
+		}
+	case []interface{}:
+		if scalars, ok := plainScalars(v); ok {
+			// A short list of plain values reads better on one line
+			fmt.Fprintf(tw, "%s: %s\n", key, scalars)
+			return
+		}
+		for i, e := range v {
+			writePlain(tw, fmt.Sprintf("%s[%d]", key, i), e)
+		}
+	default:
+		fmt.Fprintf(tw, "%s: %s\n", key, plainValue(v))
+	}
+}
+
+// Format a single value decoded from JSON, with n/a for one that's missing
+// or empty
+func plainValue(v interface{}) string {
+	if v == nil || v == "" {
+		return "n/a"
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// Return a list of values as a comma separated string, or false if any of
+// them is itself a list or a map
+func plainScalars(v []interface{}) (string, bool) {
+	if len(v) == 0 {
+		return "none", true
+	}
+	ret := []string{}
+	for _, e := range v {
+		switch e.(type) {
+		case map[string]interface{}, []interface{}:
+			return "", false
+		}
+		ret = append(ret, plainValue(e))
+	}
+	return strings.Join(ret, ", "), true
+}
@@ -0,0 +1,122 @@
+// Package diag makes HTTP(S) requests to web3 gateways and the like, tracing
+// each one into a StatsCollector that the reporters can then describe. It's
+// what web3diag itself is built on, so that other programs can run the same
+// diagnostics.
+package diag
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// The gateway ipfs:// and ipns:// URIs are requested from unless told otherwise
+const DefaultGateway = "https://ipfs.io"
+
+// The stall threshold used by Probe if Options doesn't give one
+const defaultStallThreshold = 2 * time.Second
+
+// Probe requests opts.Uri, retrying as opts says, and returns the stats for
+// it, which can be reported on even if the request failed part way through.
+// Cancelling ctx stops the request wherever it's got to, and the stats have
+// the timings up to then, with an error that wraps ctx.Err(). The error is a
+// *RequestError, whose code says what failed, except when opts.Uri can't be
+// requested at all, when there are no stats either.
+func Probe(ctx context.Context, opts Options) (*StatsCollector, error) {
+	gateway := opts.Gateway
+	if gateway == "" {
+		gateway = DefaultGateway
+	}
+	uri, ipfs, err := RequestUri(opts.Uri, gateway)
+	if err != nil {
+		return nil, err
+	}
+	if opts.OutFile == "" {
+		opts.OutFile = os.DevNull
+	}
+	if opts.StallThreshold == 0 {
+		opts.StallThreshold = defaultStallThreshold
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	t := opts.Transport
+	if t == nil {
+		t = NewTransport(opts)
+		defer t.CloseIdleConnections()
+	}
+	return retryRequest(ctx, t, uri, opts, ipfs)
+}
+
+// Transport is what requests are made over, as made by NewTransport
+type Transport interface {
+	http.RoundTripper
+	CloseIdleConnections()
+}
+
+// NewTransport makes a transport for requests to be traced over, with the
+// TLS, proxy, DNS and connection settings from opts. With opts.Http3 it makes
+// them over HTTP/3 rather than TCP.
+func NewTransport(opts Options) Transport {
+	if opts.Http3 {
+		return newHttp3Transport(opts)
+	}
+	dialer := &net.Dialer{Timeout: opts.DialTimeout, Resolver: opts.NameResolver}
+	proxy := opts.Proxy
+	if proxy == nil {
+		proxy = http.ProxyFromEnvironment
+	}
+	return &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: opts.Insecure,
+			MinVersion:         opts.TlsMin,
+			MaxVersion:         opts.TlsMax,
+			CipherSuites:       opts.CipherSuites,
+			// Offers any client certificate, and notes that the
+			// server asked for one
+			GetClientCertificate: ClientCertificate(opts.Certificate),
+		},
+		Proxy:               proxy,
+		DialContext:         CountingDialContext(NetworkDialContext(PinnedDialContext(dialer, opts.Resolve), opts.Network)),
+		TLSHandshakeTimeout: opts.TlsTimeout,
+		// As for http.DefaultTransport, so that Expect: 100-continue is
+		// honoured rather than the body being sent straight away
+		ExpectContinueTimeout: time.Second,
+		// Setting our own dialer turns HTTP/2 off unless we ask for it
+		ForceAttemptHTTP2: true,
+		// We ask for gzip ourselves unless NoCompress is set
+		DisableCompression: true,
+		// Which also sends Connection: close, so the server knows too
+		DisableKeepAlives: opts.NoKeepAlive,
+	}
+}
+
+// Work out the HTTP(S) URI to request for one given on the command line. IPFS
+// URIs are turned into requests against a HTTP(S) gateway, but we keep hold
+// of the original so reporters can check the response.
+func RequestUri(uri string, gateway string) (string, *IpfsUri, error) {
+	var ipfs *IpfsUri
+	lower := strings.ToLower(uri)
+	if strings.HasPrefix(lower, "ipfs://") || strings.HasPrefix(lower, "ipns://") {
+		var err error
+		if ipfs, err = ParseIpfsUri(uri); err != nil {
+			return "", nil, fmt.Errorf("Invalid IPFS URI: %w", err)
+		}
+		uri = ipfs.GatewayUri(gateway)
+		LogInfo("Using gateway %s for %s", gateway, ipfs.Uri)
+		lower = strings.ToLower(uri)
+	}
+
+	if !strings.HasPrefix(lower, "http://") &&
+		!strings.HasPrefix(lower, "https://") {
+		return "", nil, errors.New("Currently, only http://, https://, ipfs:// and ipns:// URIs are supported")
+	}
+	return uri, ipfs, nil
+}
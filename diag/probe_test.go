@@ -0,0 +1,167 @@
+package diag
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+func TestProbeRedirects(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusFound)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	none, unlimited := 0, -1
+	tests := []struct {
+		name   string
+		opts   Options
+		status int
+		hops   int
+	}{
+		{"by default", Options{}, http.StatusOK, 1},
+		{"with MaxRedirects 0", Options{MaxRedirects: &none}, http.StatusFound, 0},
+		{"without a limit", Options{MaxRedirects: &unlimited}, http.StatusOK, 1},
+	}
+	for _, tt := range tests {
+		tt.opts.Uri = srv.URL + "/old"
+		s, err := Probe(context.Background(), tt.opts)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if s.StatusCode != tt.status || len(s.Redirects) != tt.hops {
+			t.Errorf("%s: got status %d after %d redirect(s), want %d after %d",
+				tt.name, s.StatusCode, len(s.Redirects), tt.status, tt.hops)
+		}
+	}
+}
+
+func TestProbeUploadRedirected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusTemporaryRedirect)
+		}
+	}))
+	defer srv.Close()
+
+	body := bytes.Repeat([]byte{'x'}, 10000)
+	s, err := Probe(context.Background(), Options{Uri: srv.URL + "/old", Method: http.MethodPost, Body: body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Redirects) != 1 {
+		t.Fatalf("Got %d redirect(s), want 1", len(s.Redirects))
+	}
+	// The body was sent twice, but only the last is counted
+	if s.Upload.Bytes != uint64(len(body)) {
+		t.Errorf("Upload.Bytes is %d, want %d", s.Upload.Bytes, len(body))
+	}
+}
+
+func TestProbeMaxBytes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte{'x'}, 1000))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		max       int64
+		bytes     uint64
+		truncated bool
+	}{
+		{10, 10, true},
+		{999, 999, true},
+		{1000, 1000, false},
+		{1001, 1000, false},
+	}
+	for _, tt := range tests {
+		s, err := Probe(context.Background(), Options{Uri: srv.URL, MaxBytes: tt.max})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.TotalBytes != tt.bytes || s.Truncated != tt.truncated {
+			t.Errorf("-maxBytes %d: got %d bytes, truncated %t, want %d, %t",
+				tt.max, s.TotalBytes, s.Truncated, tt.bytes, tt.truncated)
+		}
+	}
+}
+
+// Serve h over HTTP/3 on a local UDP port, returning its URL
+func http3Server(t *testing.T, h http.Handler) string {
+	// Only for its certificate
+	tcp := httptest.NewTLSServer(h)
+	t.Cleanup(tcp.Close)
+	udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http3.Server{
+		Handler:    h,
+		TLSConfig:  http3.ConfigureTLSConfig(&tls.Config{Certificates: tcp.TLS.Certificates}),
+		QUICConfig: &quic.Config{Allow0RTT: true},
+	}
+	go srv.Serve(udp)
+	t.Cleanup(func() {
+		srv.Close()
+		udp.Close()
+	})
+	return "https://" + udp.LocalAddr().String()
+}
+
+func TestProbeHttp3(t *testing.T) {
+	uri := http3Server(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte{'x'}, 1000))
+	}))
+	opts := Options{Uri: uri, Http3: true, Insecure: true}
+	opts.Transport = NewTransport(opts)
+	defer opts.Transport.CloseIdleConnections()
+
+	s, err := Probe(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ProtoMajor != 3 || s.Tls.NegotiatedProtocol != "h3" || s.TotalBytes != 1000 {
+		t.Errorf("Got %s with ALPN %q and %d bytes, want HTTP/3 with h3 and 1000",
+			s.Proto, s.Tls.NegotiatedProtocol, s.TotalBytes)
+	}
+	if s.Quic == nil || s.Quic.Version != "v1" || s.Quic.Used0RTT {
+		t.Errorf("Got QUIC %+v, want v1 without 0-RTT", s.Quic)
+	}
+	// The handshake is timed as the connection, with no TLS phase of its own
+	if _, ok := s.PhaseDuration(PhaseConnect); !ok {
+		t.Error("The connection wasn't timed")
+	}
+	if d, ok := s.PhaseDuration(PhaseTls); ok {
+		t.Errorf("TLS took %s, want it unmeasured", d)
+	}
+	if u := s.UnmeasuredPhases(); len(u) != 1 || u[0] != PhaseTls {
+		t.Errorf("Unmeasured phases are %v, want only TLS", u)
+	}
+
+	// A new connection resumes the session, sending the request as 0-RTT
+	opts.Transport.CloseIdleConnections()
+	s, err = Probe(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Session.Reused || s.Quic == nil || !s.Quic.Used0RTT {
+		t.Errorf("Got QUIC %+v on a reused (%t) connection, want 0-RTT on a new one", s.Quic, s.Session.Reused)
+	}
+	// Though the handshake wasn't done when the request went
+	if s.Tls.NegotiatedProtocol != "h3" {
+		t.Errorf("Got ALPN %q with 0-RTT, want h3", s.Tls.NegotiatedProtocol)
+	}
+}
@@ -0,0 +1,77 @@
+package diag
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// How often the progress bar is redrawn
+const progressInterval = 200 * time.Millisecond
+
+// progressBar draws a single, continually updated line showing how far
+// through the transfer we are.
+type progressBar struct {
+	out      io.Writer
+	lastDraw time.Time
+}
+
+// Draw the progress of the transfer so far. Unless force is set, this does
+// nothing if the bar was drawn recently.
+func (p *progressBar) draw(c *StatsCollector, now time.Time, force bool) {
+	if !force && now.Sub(p.lastDraw) < progressInterval {
+		return
+	}
+	p.lastDraw = now
+
+	// Content-Length is of the body as sent, before any decompression
+	got := c.EncodedBytes()
+	rate := float64(0)
+	if elapsed := now.UnixNano() - c.StartTime; elapsed > 0 {
+		rate = float64(got) / float64(elapsed) * float64(1000000000)
+	}
+	// When resuming, the progress is through the whole of the content,
+	// including what we already had
+	had := uint64(0)
+	if c.Resume.Resumed {
+		had = uint64(c.Resume.Offset)
+	}
+
+	line := ""
+	if c.ContentLength > 0 {
+		total := uint64(c.ContentLength) + had
+		frac := float64(got+had) / float64(total)
+		if frac > 1 {
+			frac = 1
+		}
+		width := 30
+		done := int(frac * float64(width))
+		eta := "?"
+		if rate > 0 {
+			remaining := float64(c.ContentLength) - float64(got)
+			eta = (time.Duration(remaining/rate) * time.Second).String()
+		}
+		line = fmt.Sprintf("[%s%s] %5.1f%% %d/%d bytes %.1f kB/s ETA %s",
+			strings.Repeat("#", done), strings.Repeat(".", width-done),
+			frac*100, got+had, total, rate/1024, eta)
+	} else {
+		// Say why there's no way of telling how far through it is
+		line = fmt.Sprintf("%d bytes %.1f kB/s (%s, size unknown)", got+had, rate/1024, c.Framing())
+	}
+	// Return to the start of the line and clear whatever was there
+	fmt.Fprintf(p.out, "\r%s\033[K", line)
+}
+
+// Clear the progress bar, so something else can be written on its line. It
+// will be drawn again on the next update.
+func (p *progressBar) clear() {
+	fmt.Fprint(p.out, "\r\033[K")
+	p.lastDraw = time.Time{}
+}
+
+// Draw the final state of the transfer and move on to a new line
+func (p *progressBar) finish(c *StatsCollector, now time.Time) {
+	p.draw(c, now, true)
+	fmt.Fprintln(p.out)
+}
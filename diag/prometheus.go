@@ -0,0 +1,137 @@
+package diag
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// PrometheusReporter writes the timings and byte counts in the Prometheus text
+// exposition format, suitable for the node_exporter textfile collector.
+type PrometheusReporter struct{}
+
+func (r PrometheusReporter) Name() string {
+	return "Prometheus Metrics"
+}
+
+func (r PrometheusReporter) Title() string {
+	return "Prometheus Metrics"
+}
+
+func (r PrometheusReporter) Description() string {
+	return "Shows timings and byte counts in the Prometheus text exposition format"
+}
+
+func (r PrometheusReporter) Raw() bool {
+	return true
+}
+
+// Escape a label value as required by the exposition format
+func promEscape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// promMetric is a single gauge and its value
+type promMetric struct {
+	name  string
+	help  string
+	value float64
+}
+
+// Return the host and scheme labels for the request
+func (r PrometheusReporter) labels(s *StatsCollector) (host string, scheme string) {
+	if u, err := url.Parse(s.Uri); err == nil {
+		host, scheme = u.Hostname(), u.Scheme
+	}
+	return
+}
+
+func (r PrometheusReporter) metrics(s *StatsCollector) []promMetric {
+	ret := []promMetric{}
+
+	// Phases that didn't happen (e.g. no TLS for http://) are left out
+	// rather than reported as zero.
+	for _, p := range []struct {
+		name  string
+		help  string
+		phase Phase
+	}{
+		{"web3diag_dns_seconds", "Time taken to resolve the host name.", PhaseDns},
+		{"web3diag_connect_seconds", "Time taken to establish the TCP connection.", PhaseConnect},
+		{"web3diag_tls_seconds", "Time taken for the TLS handshake.", PhaseTls},
+		{"web3diag_ttfb_seconds", "Time from the request being sent to the first byte of the response.", PhaseFirstByte},
+		{"web3diag_transfer_seconds", "Time taken to transfer the response body.", PhaseTransfer},
+	} {
+		if d, ok := s.PhaseDuration(p.phase); ok {
+			ret = append(ret, promMetric{p.name, p.help, d.Seconds()})
+		}
+	}
+	if s.TransferSkipped {
+		// There's no body to measure with -ttfbOnly
+		return ret
+	}
+	ret = append(ret,
+		promMetric{"web3diag_transfer_bytes", "Number of bytes in the response body.",
+			float64(s.TotalBytesTransferred())},
+		promMetric{"web3diag_throughput_bytes_per_second", "Average transfer rate of the response body.",
+			s.KBPerSecond() * 1024})
+	if s.WireBytes() > 0 {
+		ret = append(ret,
+			promMetric{"web3diag_wire_bytes", "Number of bytes read from the connection, including headers and TLS.",
+				float64(s.WireBytes())},
+			promMetric{"web3diag_wire_bytes_per_second", "Rate the response was read from the connection.",
+				s.WireKBPerSecond() * 1024})
+	}
+	return ret
+}
+
+func (r PrometheusReporter) Report(s *StatsCollector) (ret string, e error) {
+	return PrometheusReport([]*StatsCollector{s}), nil
+}
+
+// PrometheusReport writes the metrics for any number of runs. A metric family
+// may only appear once in the exposition format, so each has its HELP and TYPE
+// just the once, followed by a sample for each run that has it. With more
+// than one run, a run label (from 1) tells the samples apart.
+func PrometheusReport(runs []*StatsCollector) string {
+	r := PrometheusReporter{}
+	names := []string{}
+	families := map[string][]string{}
+	help := map[string]string{}
+	for i, s := range runs {
+		host, scheme := r.labels(s)
+		labels := fmt.Sprintf(`{host="%s",scheme="%s"}`, promEscape(host), promEscape(scheme))
+		if len(runs) > 1 {
+			labels = fmt.Sprintf(`{host="%s",scheme="%s",run="%d"}`, promEscape(host), promEscape(scheme), i+1)
+		}
+		for _, m := range r.metrics(s) {
+			if _, ok := families[m.name]; !ok {
+				names = append(names, m.name)
+				help[m.name] = m.help
+			}
+			families[m.name] = append(families[m.name], fmt.Sprintf("%s%s %g\n", m.name, labels, m.value))
+		}
+	}
+
+	tw := &strings.Builder{}
+	for _, name := range names {
+		fmt.Fprintf(tw, "# HELP %s %s\n", name, help[name])
+		fmt.Fprintf(tw, "# TYPE %s gauge\n", name)
+		for _, sample := range families[name] {
+			tw.WriteString(sample)
+		}
+	}
+	return tw.String()
+}
+
+func (r PrometheusReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	host, scheme := r.labels(s)
+	metrics := map[string]float64{}
+	for _, m := range r.metrics(s) {
+		metrics[m.name] = m.value
+	}
+	return map[string]interface{}{
+		"Labels":  map[string]string{"host": host, "scheme": scheme},
+		"Metrics": metrics,
+	}, nil
+}
@@ -0,0 +1,173 @@
+package diag
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// How many standard deviations from the mean an entry has to be to count as
+// an outlier with -rank
+const rankOutlierSigma = 2
+
+// With the population standard deviation, no sample can be more than
+// sqrt(n-1) of them from the mean, so it takes more than this many entries
+// for any to be an outlier
+const rankOutlierMin = rankOutlierSigma*rankOutlierSigma + 1
+
+// RankEntry is something ranked with -rank: a URI from a -uriFile, with all of
+// its runs, or a single run with -count
+type RankEntry struct {
+	name string
+	runs []*StatsCollector
+
+	// The mean time to first byte and throughput of the runs that got that
+	// far, and whether any did
+	ttfb, rate     float64
+	ttfbOk, rateOk bool
+	failed         int
+}
+
+// Work out the means for an entry from its runs
+func NewRankEntry(name string, runs []*StatsCollector, errs []error) *RankEntry {
+	e := &RankEntry{name: name, runs: runs}
+	var ttfb, rate []float64
+	for i, s := range runs {
+		if errs[i] != nil {
+			e.failed++
+		}
+		if d, ok := s.PhaseDuration(PhaseFirstByte); ok {
+			ttfb = append(ttfb, d.Seconds())
+		}
+		if errs[i] == nil && !s.TransferSkipped && s.DurationNS() > 0 {
+			rate = append(rate, s.KBPerSecond())
+		}
+	}
+	if len(ttfb) > 0 {
+		e.ttfb, e.ttfbOk = Summarise(ttfb).Mean, true
+	}
+	if len(rate) > 0 {
+		e.rate, e.rateOk = Summarise(rate).Mean, true
+	}
+	return e
+}
+
+// Return how many standard deviations v is from the mean of the samples, or 0
+// if they're all the same
+func sigmas(v float64, sum Summary) float64 {
+	if sum.StdDev == 0 {
+		return 0
+	}
+	return (v - sum.Mean) / sum.StdDev
+}
+
+// RankReport ranks the URIs requested with -uriFile, or the runs made with
+// -count, from the fastest time to first byte to the slowest, along with
+// where each comes by throughput, and flags any more than two standard
+// deviations from the mean of either. Entries that never got a response are
+// ranked last. The label says what the entries are, URI or Run.
+func RankReport(entries []*RankEntry, label string) string {
+	tw := &strings.Builder{}
+	var ttfbs, rates []float64
+	for _, e := range entries {
+		if e.ttfbOk {
+			ttfbs = append(ttfbs, e.ttfb)
+		}
+		if e.rateOk {
+			rates = append(rates, e.rate)
+		}
+	}
+	ttfbSum, rateSum := Summarise(ttfbs), Summarise(rates)
+
+	// The throughput ranks, from the fastest down
+	byRate := append([]*RankEntry(nil), entries...)
+	sort.SliceStable(byRate, func(i, j int) bool {
+		if byRate[i].rateOk != byRate[j].rateOk {
+			return byRate[i].rateOk
+		}
+		return byRate[i].rate > byRate[j].rate
+	})
+	rateRank := map[*RankEntry]int{}
+	for i, e := range byRate {
+		rateRank[e] = i + 1
+	}
+
+	byTtfb := append([]*RankEntry(nil), entries...)
+	sort.SliceStable(byTtfb, func(i, j int) bool {
+		if byTtfb[i].ttfbOk != byTtfb[j].ttfbOk {
+			return byTtfb[i].ttfbOk
+		}
+		return byTtfb[i].ttfb < byTtfb[j].ttfb
+	})
+
+	ttfbOutliers, rateOutliers := len(ttfbs) > rankOutlierMin, len(rates) > rankOutlierMin
+	t := tablewriter.NewWriter(tw)
+	header := []string{"Rank", label, withUnit("TTFB", "s"), withUnit("Throughput", "kB/s"), "Throughput Rank", "Failed"}
+	if ttfbOutliers || rateOutliers {
+		header = append(header, "Outlier")
+	}
+	t.SetHeader(header)
+	outliers := 0
+	for i, e := range byTtfb {
+		ttfb, rate, rrank := "n/a", "n/a", "-"
+		notes := []string{}
+		if e.ttfbOk {
+			ttfb = fmtSeconds(e.ttfb)
+			if z := sigmas(e.ttfb, ttfbSum); ttfbOutliers && math.Abs(z) > rankOutlierSigma {
+				notes = append(notes, fmt.Sprintf("ttfb %+.1fσ", z))
+			}
+		}
+		if e.rateOk {
+			rate = fmtRate(e.rate)
+			rrank = fmt.Sprintf("%d", rateRank[e])
+			if z := sigmas(e.rate, rateSum); rateOutliers && math.Abs(z) > rankOutlierSigma {
+				notes = append(notes, fmt.Sprintf("throughput %+.1fσ", z))
+			}
+		}
+		if len(notes) > 0 {
+			outliers++
+		}
+		row := []string{
+			fmt.Sprintf("%d", i+1),
+			e.name,
+			ttfb,
+			rate,
+			rrank,
+			fmt.Sprintf("%d of %d", e.failed, len(e.runs)),
+		}
+		if ttfbOutliers || rateOutliers {
+			row = append(row, strings.Join(notes, ", "))
+		}
+		t.Append(row)
+	}
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+
+	// Those with nothing to rank them by are sorted to the end, after the
+	// slowest that had something
+	if len(ttfbs) > 1 {
+		last := byTtfb[len(ttfbs)-1]
+		tw.Write([]byte(fmt.Sprintf("Slowest to the first byte: %s %s (%s, against a mean of %s)\n",
+			label, last.name, SecondsText(last.ttfb), SecondsText(ttfbSum.Mean))))
+	}
+	if len(rates) > 1 {
+		last := byRate[len(rates)-1]
+		tw.Write([]byte(fmt.Sprintf("Lowest throughput: %s %s (%s, against a mean of %s)\n",
+			label, last.name, RateText(last.rate), RateText(rateSum.Mean))))
+	}
+	if n := len(entries) - len(ttfbs); n > 0 {
+		tw.Write([]byte(fmt.Sprintf("WARNING: %d of %d never got a response, so are ranked last\n", n, len(entries))))
+	}
+	if !ttfbOutliers || !rateOutliers {
+		tw.Write([]byte(fmt.Sprintf("Outliers are only looked for with more than %d to compare, as with fewer none can be more than %dσ from the mean\n",
+			rankOutlierMin, rankOutlierSigma)))
+	}
+	if outliers > 0 {
+		tw.Write([]byte(fmt.Sprintf("%d of %d are more than %dσ from the mean\n", outliers, len(entries), rankOutlierSigma)))
+	}
+	return tw.String()
+}
@@ -0,0 +1,1541 @@
+package diag
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Maintain a map of defined reporters that may be called
+var ReportersList = map[string]Reporter{
+	"Bandwidth":   BandwidthReporter{},
+	"Cache":       CacheReporter{},
+	"Car":         CarReporter{},
+	"Certificate": CertificateReporter{},
+	"Chunked":     ChunkedReporter{},
+	"Connection":  ConnectionReporter{},
+	"Content":     ContentReporter{},
+	"Digest":      DigestReporter{},
+	"Dns":         DnsReporter{},
+	"DnsLink":     DnsLinkReporter{},
+	"Format":      FormatReporter{},
+	"Geo":         GeoReporter{},
+	"Graph":       GraphReporter{},
+	"Header":      HeaderReporter{},
+	"IPFSGW":      IpfsGwReporter{},
+	"Influx":      InfluxReporter{},
+	"KeepAlive":   KeepAliveReporter{},
+	"Overhead":    OverheadReporter{},
+	"Prom":        PrometheusReporter{},
+	"Range":       RangeReporter{},
+	"Redirect":    RedirectReporter{},
+	"Saturn":      SaturnReporter{},
+	"Security":    SecurityReporter{},
+	"Stall":       StallReporter{},
+	"Tcp":         TcpReporter{},
+	"Throughput":  ThroughputReporter{},
+}
+
+// An interface for code that wishes to do post-processing on the data
+// gathered during the request lifetime.
+type Reporter interface {
+	Report(*StatsCollector) (string, error)
+	// Data returns the same information as Report in a structured form,
+	// for -format json.
+	Data(*StatsCollector) (map[string]interface{}, error)
+	Name() string
+	Title() string
+	Description() string
+}
+
+// Reporters whose output is a machine-readable format in its own right can
+// implement RawReporter so that it's printed without a title or description.
+type RawReporter interface {
+	Raw() bool
+}
+
+// Return an error if the request failed before there was a response, for
+// reporters that need one
+func checkResponse(s *StatsCollector) error {
+	if s.ResponseHeaders == nil {
+		return errors.New("There was no response")
+	}
+	return nil
+}
+
+// Return an error if there's no body to report on, either as there was no
+// response or as it wasn't read. A body that was cut short is still reported.
+func checkBody(s *StatsCollector) error {
+	if err := checkResponse(s); err != nil {
+		return err
+	}
+	if s.TransferSkipped {
+		return errors.New("The body wasn't read, as -ttfbOnly was given")
+	}
+	return nil
+}
+
+// Reporter that summarises the session init (DNS, TCP, TLS)
+type ConnectionReporter struct{}
+
+// Convenience function to turn a length of time in ns into a float in
+// seconds. For the time between two timestamps, use phaseSeconds.
+func nsSeconds(ns int64) float64 {
+	return float64(ns) / float64(1000000000)
+}
+
+// NsDiffInSeconds returns the time between an end and a start (in ns) in
+// seconds, and whether both were actually recorded
+func (r ConnectionReporter) NsDiffInSeconds(e int64, s int64) (float64, bool) {
+	return phaseSeconds(e, s)
+}
+
+func (r ConnectionReporter) Name() string {
+	return "Connection Timing"
+}
+
+func (r ConnectionReporter) Title() string {
+	return "Session Establishment"
+}
+
+func (r ConnectionReporter) Description() string {
+	return "Shows the timing for various stages of establishment of a HTTP/HTTPS session"
+}
+
+// The phases shown by the reporter, in order
+var connectionPhases = []Phase{PhaseDns, PhaseConnect, PhaseTls, PhaseRequest, PhaseFirstByte}
+
+// Describe how long the server took to send 100 Continue, if we asked it to
+// with an Expect header.
+func (r ConnectionReporter) continueDelay(s *StatsCollector) string {
+	if s.RequestHeaders["Expect"] == nil {
+		return ""
+	}
+	if v, ok := phaseSeconds(s.Request.Got100Time, s.Request.Wait100Time); ok {
+		return fmtSeconds(v)
+	}
+	return "not received"
+}
+
+func (r ConnectionReporter) Report(s *StatsCollector) (ret string, e error) {
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{withUnit("DNS Lookup", "s"), withUnit("Connection", "s"), withUnit("TLS", "s"),
+		withUnit("Request", "s"), withUnit("First Byte", "s")})
+
+	data := []string{}
+	for _, p := range connectionPhases {
+		if d, ok := s.PhaseDuration(p); ok {
+			data = append(data, fmtDuration(d))
+		} else if unmeasured(s, p) {
+			data = append(data, "unmeasured")
+		} else {
+			data = append(data, "n/a")
+		}
+	}
+	conn := fmt.Sprintf("%s\nreused: %t", s.Connection.Address, s.Session.Reused)
+	if s.Quic != nil && s.Quic.Version != "" {
+		conn += "\nquic: " + s.Quic.Version
+		if s.Quic.Used0RTT {
+			conn += "\n0-rtt: yes"
+		}
+	}
+	if s.Connection.Family != "" {
+		conn += "\nfamily: " + s.Connection.Family
+	}
+	if s.Session.WasIdle {
+		conn += fmt.Sprintf("\nidle: %s", time.Duration(s.Session.IdleTime))
+	}
+	if s.Session.KeepAliveDisabled {
+		conn += "\nkeepalive: off"
+	}
+	for _, name := range s.Session.Ptr {
+		conn += "\nptr: " + name
+	}
+	if s.Proxy != "" {
+		conn += "\nproxy: " + s.Proxy
+	}
+	dns := fmt.Sprintf("%s\n%s\nvia: %s", s.Dns.Host, s.Dns.Addrs, s.Dns.Resolver)
+	if s.Dns.Pinned != "" {
+		dns = fmt.Sprintf("%s\npinned to %s\nwith -resolve", s.Session.HostPort, s.Dns.Pinned)
+	}
+	hints := []string{
+		dns,
+		conn,
+		"",
+		s.Proto,
+		"",
+	}
+	if s.Tls.Version != 0 {
+		hints[2] = fmt.Sprintf("ver: %s\ncipher: %s\nname: %s\nalpn: %s", s.Tls.VersionName,
+			s.Tls.CipherSuiteName, s.Tls.ServerName, s.Tls.NegotiatedProtocol)
+	}
+	if v, ok := phaseSeconds(s.Request.WroteHeadersTime, s.Session.EndTime); ok {
+		hints[3] += "\nheaders: " + fmtSeconds(v)
+	}
+	if c := r.continueDelay(s); c != "" {
+		hints[3] += "\n100-continue: " + c
+	}
+
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetAutoMergeCells(true)
+	t.SetRowLine(true)
+	// Keep each hint on a line of its own
+	t.SetAutoWrapText(false)
+	t.Append(data)
+	t.Append(hints)
+	t.Render()
+	if total, ok := s.PhaseDuration(PhaseTotal); ok && s.TransferSkipped {
+		tw.Write([]byte(fmt.Sprintf("End to end: %s to the first byte (transfer skipped with -ttfbOnly)\n",
+			SecondsText(total.Seconds()))))
+	} else if ok {
+		setup, _ := s.PhaseDuration(PhaseSetup)
+		transfer, _ := s.PhaseDuration(PhaseTransfer)
+		tw.Write([]byte(fmt.Sprintf("End to end: %s (%s setup, %s transfer)\n",
+			SecondsText(total.Seconds()), fmtDuration(setup), fmtDuration(transfer))))
+	}
+	if a, aaaa := dnsFamilies(s.Dns.Addrs); len(a) > 0 && len(aaaa) > 0 {
+		used := s.Connection.Family
+		if used == "" {
+			used = "neither"
+		}
+		tw.Write([]byte(fmt.Sprintf("%s has both IPv4 and IPv6 addresses, and %s was used\nA: %s\nAAAA: %s\n",
+			s.Dns.Host, used, strings.Join(a, ", "), strings.Join(aaaa, ", "))))
+	}
+	if s.Quic != nil {
+		tw.Write([]byte("Over HTTP/3, TLS is part of the QUIC handshake, so it's unmeasured on its own and timed as the connection\n"))
+		if s.Quic.Used0RTT {
+			tw.Write([]byte("The connection resumed an earlier session with 0-RTT, so the first request on it was sent before the handshake was done\n"))
+		}
+	} else if h3 := http3Advertised(s); h3 != "" {
+		tw.Write([]byte(fmt.Sprintf("The server advertises HTTP/3 (Alt-Svc: %s), which -http3 makes the request over\n", h3)))
+	}
+
+	ret = tw.String()
+	return // ret, e
+}
+
+// Split the addresses a host was resolved to into its A and AAAA records
+func dnsFamilies(addrs []net.IPAddr) ([]string, []string) {
+	a, aaaa := []string{}, []string{}
+	for _, addr := range addrs {
+		if ipFamily(addr.IP) == "ipv4" {
+			a = append(a, addr.IP.String())
+		} else {
+			aaaa = append(aaaa, addr.IP.String())
+		}
+	}
+	return a, aaaa
+}
+
+// Return whether a phase has no timing because of how the request was made,
+// rather than because it didn't happen
+func unmeasured(s *StatsCollector, p Phase) bool {
+	for _, u := range s.UnmeasuredPhases() {
+		if u == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the Alt-Svc entry advertising HTTP/3, if the server sent one, as it's
+// worth knowing the server would have offered it when it wasn't used
+func http3Advertised(s *StatsCollector) string {
+	for _, v := range s.ResponseHeaders["Alt-Svc"] {
+		for _, svc := range strings.Split(v, ",") {
+			svc = strings.TrimSpace(svc)
+			if strings.HasPrefix(svc, "h3=") || strings.HasPrefix(svc, "h3-") {
+				return svc
+			}
+		}
+	}
+	return ""
+}
+
+// The keys of the phases in Data, in the same order as connectionPhases
+var connectionPhaseKeys = []string{"DnsLookup", "Connection", "Tls", "Request", "FirstByte"}
+
+func (r ConnectionReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	ret := map[string]interface{}{
+		"Host":               s.Dns.Host,
+		"Addrs":              s.Dns.Addrs,
+		"Resolver":           s.Dns.Resolver,
+		"Pinned":             s.Dns.Pinned,
+		"Address":            s.Connection.Address,
+		"Family":             s.Connection.Family,
+		"Proxy":              s.Proxy,
+		"Ptr":                s.Session.Ptr,
+		"Reused":             s.Session.Reused,
+		"WasIdle":            s.Session.WasIdle,
+		"IdleTime":           nsSeconds(s.Session.IdleTime),
+		"KeepAliveDisabled":  s.Session.KeepAliveDisabled,
+		"TlsVersion":         s.Tls.Version,
+		"TlsVersionName":     s.Tls.VersionName,
+		"TlsCipherSuite":     s.Tls.CipherSuite,
+		"TlsCipherSuiteName": s.Tls.CipherSuiteName,
+		"ServerName":         s.Tls.ServerName,
+		"Alpn":               s.Tls.NegotiatedProtocol,
+		"Proto":              s.Proto,
+		"Http3":              http3Advertised(s),
+		"Quic":               s.Quic,
+	}
+	// Phases that didn't happen are null, as are those that weren't
+	// measured, which are also listed as such
+	unmeasuredKeys := []string{}
+	for i, phase := range connectionPhases {
+		ret[connectionPhaseKeys[i]] = nil
+		if d, ok := s.PhaseDuration(phase); ok {
+			ret[connectionPhaseKeys[i]] = d.Seconds()
+		}
+		if unmeasured(s, phase) {
+			unmeasuredKeys = append(unmeasuredKeys, connectionPhaseKeys[i])
+		}
+	}
+	ret["Unmeasured"] = unmeasuredKeys
+	if v, ok := phaseSeconds(s.Request.WroteHeadersTime, s.Session.EndTime); ok {
+		ret["Headers"] = v
+	}
+	if s.Dns.EndTime != 0 {
+		ret["A"], ret["AAAA"] = dnsFamilies(s.Dns.Addrs)
+	}
+	if total, ok := s.PhaseDuration(PhaseTotal); ok && s.TransferSkipped {
+		ret["Total"] = total.Seconds()
+		ret["TransferSkipped"] = true
+	} else if ok {
+		setup, _ := s.PhaseDuration(PhaseSetup)
+		transfer, _ := s.PhaseDuration(PhaseTransfer)
+		ret["Total"] = total.Seconds()
+		ret["Setup"] = setup.Seconds()
+		ret["Transfer"] = transfer.Seconds()
+	}
+	if s.RequestHeaders["Expect"] != nil {
+		v, ok := phaseSeconds(s.Request.Got100Time, s.Request.Wait100Time)
+		ret["Continue"] = v
+		ret["ContinueReceived"] = ok
+	}
+	return ret, nil
+}
+
+// DnsReporter lists every address the host name resolved to, and which of
+// them was connected to
+type DnsReporter struct{}
+
+func (r DnsReporter) Name() string {
+	return "DNS Records"
+}
+
+func (r DnsReporter) Title() string {
+	return "DNS Lookup Results"
+}
+
+func (r DnsReporter) Description() string {
+	return "Lists every address the host resolved to, marking the one connected to"
+}
+
+// Return the IP address that was connected to, if we know it
+func (r DnsReporter) used(s *StatsCollector) net.IP {
+	if host, _, err := net.SplitHostPort(s.Connection.Address); err == nil {
+		return net.ParseIP(host)
+	}
+	return nil
+}
+
+// Make sure there was a lookup to report on, which there isn't when the
+// address was pinned or an earlier connection was reused
+func (r DnsReporter) check(s *StatsCollector) error {
+	if s.Dns.Pinned != "" {
+		return fmt.Errorf("No lookup was made, as %s was pinned to %s with -resolve", s.Session.HostPort, s.Dns.Pinned)
+	}
+	if s.Dns.EndTime == 0 {
+		return errors.New("No lookup was made, as an earlier connection was reused or the host is an IP address")
+	}
+	if len(s.Dns.Addrs) == 0 {
+		return fmt.Errorf("The lookup of %s failed", s.Dns.Host)
+	}
+	return nil
+}
+
+func (r DnsReporter) Report(s *StatsCollector) (ret string, e error) {
+	if err := r.check(s); err != nil {
+		return "", err
+	}
+
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"Address", "Family", "Used"})
+	used := r.used(s)
+	nth := 0
+	for i, a := range s.Dns.Addrs {
+		mark := ""
+		if a.IP.Equal(used) {
+			mark = "*"
+			nth = i + 1
+		}
+		t.Append([]string{a.IP.String(), ipFamily(a.IP), mark})
+	}
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+	d, _ := s.PhaseDuration(PhaseDns)
+	tw.Write([]byte(fmt.Sprintf("%s resolved to %d address(es) in %s via the %s resolver\n",
+		s.Dns.Host, len(s.Dns.Addrs), SecondsText(d.Seconds()), s.Dns.Resolver)))
+	if s.Dns.CnameError != nil {
+		tw.Write([]byte(fmt.Sprintf("The CNAME lookup failed: %s\n", s.Dns.CnameError)))
+	} else if len(s.Dns.Cnames) > 0 {
+		tw.Write([]byte(fmt.Sprintf("CNAME chain: %s -> %s\n", s.Dns.Host, strings.Join(s.Dns.Cnames, " -> "))))
+	}
+	if nth == 0 {
+		tw.Write([]byte("None of them were connected to\n"))
+	} else if len(s.Dns.Addrs) > 1 {
+		// Anything other than the first suggests earlier ones failed
+		// or were too slow
+		tw.Write([]byte(fmt.Sprintf("The connection was made to address %d of %d\n", nth, len(s.Dns.Addrs))))
+	}
+	ret = tw.String()
+	return
+}
+
+func (r DnsReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	if err := r.check(s); err != nil {
+		return nil, err
+	}
+	used := r.used(s)
+	addrs := []map[string]interface{}{}
+	for _, a := range s.Dns.Addrs {
+		addrs = append(addrs, map[string]interface{}{
+			"Address": a.IP.String(),
+			"Family":  ipFamily(a.IP),
+			"Used":    a.IP.Equal(used),
+		})
+	}
+	d, _ := s.PhaseDuration(PhaseDns)
+	return map[string]interface{}{
+		"Host":      s.Dns.Host,
+		"Resolver":  s.Dns.Resolver,
+		"Seconds":   d.Seconds(),
+		"Addresses": addrs,
+		"Cnames":    s.Dns.Cnames,
+	}, nil
+}
+
+// HeaderReporter shows various request and response headers
+type HeaderReporter struct{}
+
+func (r HeaderReporter) Name() string {
+	return "HTTP Headers"
+}
+
+func (r HeaderReporter) Title() string {
+	return "Request and Response Headers"
+}
+
+func (r HeaderReporter) Description() string {
+	return "Shows Request and Response headers from a HTTP/HTTPS request"
+}
+
+func (r HeaderReporter) Report(s *StatsCollector) (ret string, e error) {
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"", "Key", "Value"})
+	for k := range s.RequestHeaders {
+		for _, v := range s.RequestHeaders[k] {
+			t.Append([]string{"Request", k, v})
+		}
+	}
+	if s.Status != "" {
+		t.Append([]string{"Response", "Status", fmt.Sprintf("%s %s", s.Proto, s.Status)})
+	}
+	for k := range s.ResponseHeaders {
+		for _, v := range s.ResponseHeaders[k] {
+			t.Append([]string{"Response", k, v})
+		}
+	}
+	// Trailers came after the body, so get a section of their own
+	for k := range s.ResponseTrailers {
+		for _, v := range s.ResponseTrailers[k] {
+			t.Append([]string{"Trailer", k, v})
+		}
+	}
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetAutoMergeCells(true)
+	t.SetRowLine(true)
+	t.Render()
+	ret = tw.String()
+	return
+}
+
+func (r HeaderReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"StatusCode": s.StatusCode,
+		"Status":     s.Status,
+		"Request":    s.RequestHeaders,
+		"Response":   s.ResponseHeaders,
+		"Trailers":   s.ResponseTrailers,
+	}, nil
+}
+
+// CustomHeaderReporter shows just the response headers given with
+// -reportHeaders, for gateways that don't have a reporter of their own.
+type CustomHeaderReporter struct {
+	Headers []string
+}
+
+func (r CustomHeaderReporter) Name() string {
+	return "Selected Headers"
+}
+
+func (r CustomHeaderReporter) Title() string {
+	return "Selected Response Headers"
+}
+
+func (r CustomHeaderReporter) Description() string {
+	return "Shows the response headers given with -reportHeaders"
+}
+
+func (r CustomHeaderReporter) Report(s *StatsCollector) (ret string, e error) {
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"Header", "Value"})
+	for _, h := range r.Headers {
+		v := "n/a"
+		if vs := s.ResponseHeaders[http.CanonicalHeaderKey(h)]; len(vs) > 0 {
+			v = strings.Join(vs, ", ")
+		}
+		t.Append([]string{h, v})
+	}
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+	ret = tw.String()
+	return
+}
+
+func (r CustomHeaderReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	ret := map[string]interface{}{}
+	for _, h := range r.Headers {
+		// Headers that weren't sent are null
+		ret[h] = nil
+		if vs := s.ResponseHeaders[http.CanonicalHeaderKey(h)]; len(vs) > 0 {
+			ret[h] = strings.Join(vs, ", ")
+		}
+	}
+	return ret, nil
+}
+
+// IpfsReporter shows various aspects specific to IPFS
+type IpfsGwReporter struct{}
+
+func (r IpfsGwReporter) Name() string {
+	return "IPFS Gateway"
+}
+
+func (r IpfsGwReporter) Title() string {
+	return "IPFS Gateway Path"
+}
+
+func (r IpfsGwReporter) Description() string {
+	return "Shows Information about the path through the IPFS Gateway"
+}
+
+// Return the first value of a response header, or n/a if it wasn't sent
+func headerOrNa(s *StatsCollector, k string) string {
+	if v := s.ResponseHeaders[k]; len(v) > 0 {
+		return v[0]
+	}
+	return "n/a"
+}
+
+// Return the first value of a response header, or nil if it wasn't sent, for
+// Data
+func headerOrNil(s *StatsCollector, k string) interface{} {
+	if v := s.ResponseHeaders[k]; len(v) > 0 {
+		return v[0]
+	}
+	return nil
+}
+
+// Return an address of the connection, or n/a if there never was one
+func addrOrNa(a net.Addr) string {
+	if a == nil {
+		return "n/a"
+	}
+	return a.String()
+}
+
+// Return an address of the connection, or nil if there never was one, for
+// Data
+func addrOrNil(a net.Addr) interface{} {
+	if a == nil {
+		return nil
+	}
+	return a.String()
+}
+
+// Check that at least one of the given response headers was sent. Reporters
+// still show whatever they can when only some of them were.
+func anyHeader(s *StatsCollector, keys ...string) error {
+	for _, k := range keys {
+		if len(s.ResponseHeaders[k]) > 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("None of the headers %s are present in the response", strings.Join(keys, ", "))
+}
+
+// Check that there was a response, and that the gateway headers we report on
+// are present in it, or at least that the URL was a gateway one
+func (r IpfsGwReporter) check(s *StatsCollector) error {
+	if err := checkResponse(s); err != nil {
+		return err
+	}
+	if GatewayMode(s.FinalUri()) != "" {
+		return nil
+	}
+	return anyHeader(s, "X-Ipfs-Lb-Pop", "X-Ipfs-Pop", "X-Ipfs-Path")
+}
+
+// Return whether the content is a block or CAR, for which origin isolation
+// doesn't matter as browsers don't render them
+func (r IpfsGwReporter) verifiable(s *StatsCollector) bool {
+	t, _, _ := mime.ParseMediaType(http.Header(s.ResponseHeaders).Get("Content-Type"))
+	return strings.HasPrefix(t, "application/vnd.ipld.")
+}
+
+// Describe the gateway mode of the URL the content came from, and whether a
+// path request was redirected to subdomain mode to get there
+func (r IpfsGwReporter) mode(s *StatsCollector) string {
+	final := GatewayMode(s.FinalUri())
+	switch {
+	case final == gatewaySubdomain && GatewayMode(s.Uri) == gatewayPath:
+		u, _ := url.Parse(s.FinalUri())
+		return fmt.Sprintf("The path request was redirected to subdomain mode at %s, so the content has an origin of its own\n", u.Host)
+	case final == gatewaySubdomain:
+		return "The gateway was used in subdomain mode, so the content has an origin of its own\n"
+	case final == gatewayPath && r.verifiable(s):
+		return "The gateway was used in path mode, which is fine for blocks and CARs as browsers don't render them\n"
+	case final == gatewayPath:
+		u, _ := url.Parse(s.FinalUri())
+		return fmt.Sprintf("WARNING: the gateway was used in path mode, so there's no origin isolation: all content on it shares the origin %s://%s, along with its cookies and storage\n",
+			u.Scheme, u.Host)
+	}
+	return ""
+}
+
+func (r IpfsGwReporter) Report(s *StatsCollector) (ret string, e error) {
+	if err := r.check(s); err != nil {
+		return "", err
+	}
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"Client", "Gateway", "Load Balancer", "IPFS Node"})
+	t.Append([]string{
+		addrOrNa(s.Session.Local),
+		addrOrNa(s.Session.Remote),
+		headerOrNa(s, "X-Ipfs-Lb-Pop"),
+		headerOrNa(s, "X-Ipfs-Pop")})
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetAutoMergeCells(true)
+	t.SetRowLine(true)
+	t.Render()
+	if s.ResponseHeaders["X-Proxy-Cache"] != nil {
+		tw.Write([]byte(fmt.Sprintf("The request was an IPFS gateway cache %s\n",
+			s.ResponseHeaders["X-Proxy-Cache"][0])))
+	}
+	if s.Ipfs != nil && s.ResponseHeaders["X-Ipfs-Path"] != nil {
+		// The gateway tells us what it thinks it served, which should
+		// be what we asked for in the first place.
+		p := s.ResponseHeaders["X-Ipfs-Path"][0]
+		if p == s.Ipfs.ContentPath() {
+			tw.Write([]byte(fmt.Sprintf("The gateway served the requested path %s\n", p)))
+		} else {
+			tw.Write([]byte(fmt.Sprintf("WARNING: requested %s but the gateway served %s\n",
+				s.Ipfs.ContentPath(), p)))
+		}
+	}
+	tw.Write([]byte(r.mode(s)))
+	ret = tw.String()
+	return
+}
+
+func (r IpfsGwReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	if err := r.check(s); err != nil {
+		return nil, err
+	}
+	ret := map[string]interface{}{
+		"Client":       addrOrNil(s.Session.Local),
+		"Gateway":      addrOrNil(s.Session.Remote),
+		"LoadBalancer": headerOrNil(s, "X-Ipfs-Lb-Pop"),
+		"IpfsNode":     headerOrNil(s, "X-Ipfs-Pop"),
+	}
+	if s.ResponseHeaders["X-Proxy-Cache"] != nil {
+		ret["Cache"] = s.ResponseHeaders["X-Proxy-Cache"][0]
+	}
+	if s.Ipfs != nil && s.ResponseHeaders["X-Ipfs-Path"] != nil {
+		ret["RequestedPath"] = s.Ipfs.ContentPath()
+		ret["ServedPath"] = s.ResponseHeaders["X-Ipfs-Path"][0]
+	}
+	if mode := GatewayMode(s.FinalUri()); mode != "" {
+		ret["Mode"] = mode
+		ret["RedirectedToSubdomain"] = mode == gatewaySubdomain && GatewayMode(s.Uri) == gatewayPath
+		ret["OriginIsolation"] = mode == gatewaySubdomain
+	}
+	return ret, nil
+}
+
+// SaturnReporter shows various aspects specific to the Saturn web3 CDN
+type SaturnReporter struct{}
+
+func (r SaturnReporter) Name() string {
+	return "Saturn CDN"
+}
+
+func (r SaturnReporter) Title() string {
+	return "Saturn CDN"
+}
+
+func (r SaturnReporter) Description() string {
+	return "Shows information about Saturn CDN, where applicable"
+}
+
+// Check that the Saturn headers we report on are present
+func (r SaturnReporter) check(s *StatsCollector) error {
+	return anyHeader(s, "Saturn-Transfer-Id", "Saturn-Node-Id", "Saturn-Node-Version", "Saturn-Cache-Status")
+}
+
+func (r SaturnReporter) Report(s *StatsCollector) (ret string, e error) {
+	if err := r.check(s); err != nil {
+		return "", err
+	}
+
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"Client", "Transfer ID", "Saturn Node", "Saturn Node ID", "Node Version", "Cache Status"})
+	t.Append([]string{
+		addrOrNa(s.Session.Local),
+		headerOrNa(s, "Saturn-Transfer-Id"),
+		addrOrNa(s.Session.Remote),
+		headerOrNa(s, "Saturn-Node-Id"),
+		headerOrNa(s, "Saturn-Node-Version"),
+		headerOrNa(s, "Saturn-Cache-Status"),
+	})
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetAutoMergeCells(true)
+	t.SetRowLine(true)
+	t.Render()
+	ret = tw.String()
+	return
+}
+
+func (r SaturnReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	if err := r.check(s); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"Client":      addrOrNil(s.Session.Local),
+		"TransferId":  headerOrNil(s, "Saturn-Transfer-Id"),
+		"Node":        addrOrNil(s.Session.Remote),
+		"NodeId":      headerOrNil(s, "Saturn-Node-Id"),
+		"NodeVersion": headerOrNil(s, "Saturn-Node-Version"),
+		"CacheStatus": headerOrNil(s, "Saturn-Cache-Status"),
+	}, nil
+}
+
+// SaturnSummary shows which Saturn node served each of several runs and
+// whether it came from the node's cache, along with the overall hit ratio, to
+// show whether content is being cached on repeat fetches.
+func SaturnSummary(runs []*StatsCollector) string {
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"Run", "Saturn Node", "Saturn Node ID", "Cache Status"})
+	statuses := map[string]int{}
+	hits, seen := 0, 0
+	for i, s := range runs {
+		if s.ResponseHeaders == nil {
+			// The request failed before there was a response
+			continue
+		}
+		node := addrOrNa(s.Session.Remote)
+		status := headerOrNa(s, "Saturn-Cache-Status")
+		t.Append([]string{fmt.Sprintf("%d", i+1), node, headerOrNa(s, "Saturn-Node-Id"), status})
+		statuses[strings.ToUpper(status)]++
+		if status != "n/a" {
+			seen++
+			if strings.EqualFold(status, "HIT") {
+				hits++
+			}
+		}
+	}
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+
+	names := []string{}
+	for k := range statuses {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	counts := []string{}
+	for _, k := range names {
+		counts = append(counts, fmt.Sprintf("%s: %d", k, statuses[k]))
+	}
+	tw.Write([]byte(strings.Join(counts, ", ") + "\n"))
+	if seen > 0 {
+		tw.Write([]byte(fmt.Sprintf("Cache hit ratio: %d of %d (%.1f%%)\n",
+			hits, seen, float64(hits)/float64(seen)*100)))
+	} else {
+		tw.Write([]byte("None of the responses had a Saturn-Cache-Status header\n"))
+	}
+	return tw.String()
+}
+
+// RedirectReporter shows the chain of redirects followed to reach the content
+type RedirectReporter struct{}
+
+func (r RedirectReporter) Name() string {
+	return "Redirects"
+}
+
+func (r RedirectReporter) Title() string {
+	return "Redirect Chain"
+}
+
+func (r RedirectReporter) Description() string {
+	return "Shows each redirect followed and the latency it added"
+}
+
+// Describe how many redirects were to be followed, and whether one wasn't
+func (r RedirectReporter) policy(s *StatsCollector) string {
+	switch {
+	case s.MaxRedirects == 0 && s.UnfollowedRedirect != "":
+		return fmt.Sprintf("Not following the redirect to %s, as -maxRedirects is 0, so it's the response\n",
+			s.UnfollowedRedirect)
+	case s.MaxRedirects == 0:
+		return "Redirects weren't to be followed, with -maxRedirects 0\n"
+	case s.UnfollowedRedirect != "":
+		return fmt.Sprintf("WARNING: Gave up at the limit of %d redirect(s), without following the next to %s\n",
+			s.MaxRedirects, s.UnfollowedRedirect)
+	}
+	return fmt.Sprintf("Up to %d redirect(s) were to be followed\n", s.MaxRedirects)
+}
+
+func (r RedirectReporter) Report(s *StatsCollector) (ret string, e error) {
+	if len(s.Redirects) == 0 {
+		return "No redirects were followed\n" + r.policy(s), nil
+	}
+
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"Hop", "Status", "From", "Location", withUnit("Time", "s"), withUnit("Cumulative", "s")})
+	total := float64(0)
+	downgrades := 0
+	for i, h := range s.Redirects {
+		d, _ := phaseSeconds(h.EndTime, h.StartTime)
+		total += d
+		from := h.From
+		if h.Downgrade() {
+			from += "\n(https -> http downgrade)"
+			downgrades++
+		}
+		t.Append([]string{
+			fmt.Sprintf("%d", i+1),
+			fmt.Sprintf("%d", h.StatusCode),
+			from,
+			h.Location,
+			fmtSeconds(d),
+			fmtSeconds(total),
+		})
+	}
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+	tw.Write([]byte(fmt.Sprintf("%d redirect(s) added %s before the final request\n",
+		len(s.Redirects), SecondsText(total))))
+	if downgrades > 0 {
+		tw.Write([]byte(fmt.Sprintf("WARNING: %d redirect(s) downgraded from https to http\n",
+			downgrades)))
+	}
+	tw.Write([]byte(r.policy(s)))
+	ret = tw.String()
+	return
+}
+
+func (r RedirectReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	hops := []map[string]interface{}{}
+	total := float64(0)
+	for _, h := range s.Redirects {
+		d, _ := phaseSeconds(h.EndTime, h.StartTime)
+		total += d
+		hops = append(hops, map[string]interface{}{
+			"StatusCode": h.StatusCode,
+			"From":       h.From,
+			"To":         h.To,
+			"Location":   h.Location,
+			"Seconds":    d,
+			"Downgrade":  h.Downgrade(),
+		})
+	}
+	return map[string]interface{}{
+		"Hops":         hops,
+		"TotalSeconds": total,
+		"MaxRedirects": s.MaxRedirects,
+		"Unfollowed":   s.UnfollowedRedirect,
+	}, nil
+}
+
+// ThroughputReporter shows the spread of the per-second transfer rate
+type ThroughputReporter struct{}
+
+func (r ThroughputReporter) Name() string {
+	return "Throughput"
+}
+
+func (r ThroughputReporter) Title() string {
+	return "Transfer Throughput"
+}
+
+func (r ThroughputReporter) Description() string {
+	return "Shows percentiles and a sparkline of the per-second transfer rate"
+}
+
+// Render the samples as a single line of block characters scaled between
+// zero and the largest sample.
+func sparkline(samples []float64) string {
+	blocks := []rune("▁▂▃▄▅▆▇█")
+	peak := float64(0)
+	for _, v := range samples {
+		peak = math.Max(peak, v)
+	}
+	ret := make([]rune, 0, len(samples))
+	for _, v := range samples {
+		i := 0
+		if peak > 0 {
+			i = int(v / peak * float64(len(blocks)-1))
+		}
+		ret = append(ret, blocks[i])
+	}
+	return string(ret)
+}
+
+// Return the per-second transfer rate in kB/s. Only whole seconds are used, as
+// a rate over the odd few milliseconds at either end says little and would
+// skew the percentiles.
+func (r ThroughputReporter) samples(s *StatsCollector) []float64 {
+	samples := make([]float64, 0, len(s.PerSecond))
+	for _, v := range s.PerSecond {
+		if v.Millis == 1000 {
+			samples = append(samples, float64(v.Bytes)/float64(1024))
+		}
+	}
+	return samples
+}
+
+func (r ThroughputReporter) Report(s *StatsCollector) (ret string, e error) {
+	if err := checkBody(s); err != nil {
+		return "", err
+	}
+	notes := ""
+	if s.WireBytes() > 0 {
+		// The rates are of the decoded body, which can be quite
+		// different to how fast the network was if it was compressed
+		notes += fmt.Sprintf("%s were read from the connection for %s of content, with the response arriving at %s\n",
+			bytesText(s.WireBytes()), bytesText(s.DecodedBytes()), RateText(s.WireKBPerSecond()))
+	}
+	if d, ok := s.PhaseDuration(PhaseUpload); ok && s.Upload.Bytes > 0 {
+		notes += fmt.Sprintf("Uploaded %s in %s (%s)\n",
+			bytesText(s.Upload.Bytes), SecondsText(d.Seconds()), RateText(s.UploadKBPerSecond()))
+	}
+
+	samples := r.samples(s)
+	if len(samples) == 0 {
+		return fmt.Sprintf("The transfer didn't span a whole second, averaging %s\n%s",
+			RateText(s.KBPerSecond()), notes), nil
+	}
+
+	sum := Summarise(samples)
+
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"Seconds", "Min", "P50", "P90", "P99", "Max", "Average"})
+	t.Append([]string{
+		fmt.Sprintf("%d", sum.Count),
+		fmtRate(sum.Min),
+		fmtRate(sum.P50),
+		fmtRate(sum.P90),
+		fmtRate(sum.P99),
+		fmtRate(sum.Max),
+		fmtRate(s.KBPerSecond()),
+	})
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+	if Units == UnitsRaw {
+		tw.Write([]byte("Rates are in kB/s. "))
+	}
+	tw.Write([]byte(fmt.Sprintf("Per-second rate: %s\n", sparkline(samples))))
+	tw.Write([]byte(notes))
+	ret = tw.String()
+	return
+}
+
+func (r ThroughputReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	if err := checkBody(s); err != nil {
+		return nil, err
+	}
+	samples := r.samples(s)
+	ret := map[string]interface{}{
+		"Average":   s.KBPerSecond(),
+		"PerSecond": samples,
+		"Summary":   Summarise(samples),
+	}
+	if s.WireBytes() > 0 {
+		ret["WireBytes"] = s.WireBytes()
+		ret["DecodedBytes"] = s.DecodedBytes()
+		ret["WireAverage"] = s.WireKBPerSecond()
+	}
+	if d, ok := s.PhaseDuration(PhaseUpload); ok && s.Upload.Bytes > 0 {
+		ret["Upload"] = map[string]interface{}{
+			"Bytes":   s.Upload.Bytes,
+			"Seconds": d.Seconds(),
+			"Average": s.UploadKBPerSecond(),
+		}
+	}
+	return ret, nil
+}
+
+// StallReporter lists the points at which the transfer of the body froze
+type StallReporter struct{}
+
+func (r StallReporter) Name() string {
+	return "Transfer Stalls"
+}
+
+func (r StallReporter) Title() string {
+	return "Stalls During the Transfer"
+}
+
+func (r StallReporter) Description() string {
+	return "Lists gaps in the transfer of the body longer than -stallThreshold"
+}
+
+func (r StallReporter) Report(s *StatsCollector) (ret string, e error) {
+	if err := checkBody(s); err != nil {
+		return "", err
+	}
+	longest := time.Duration(s.Stall.Longest)
+	if len(s.Stall.Stalls) == 0 {
+		return fmt.Sprintf("No stalls of more than %s, the longest gap was %s\n",
+			s.Stall.Threshold, longest), nil
+	}
+
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"Stall", withUnit("Started At", "s"), withUnit("Length", "s"), "Bytes Received"})
+	stalled := int64(0)
+	for i, st := range s.Stall.Stalls {
+		stalled += st.EndTime - st.StartTime
+		// A stall is only recorded once the transfer has started and
+		// the gap is over, so has both ends
+		at, _ := phaseSeconds(st.StartTime, s.StartTime)
+		length, _ := phaseSeconds(st.EndTime, st.StartTime)
+		t.Append([]string{
+			fmt.Sprintf("%d", i+1),
+			fmtSeconds(at),
+			fmtSeconds(length),
+			fmtBytes(st.Bytes),
+		})
+	}
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+	tw.Write([]byte(fmt.Sprintf("Start times are from the start of the transfer. %d stall(s) of more than %s took %s of %s\n",
+		len(s.Stall.Stalls), s.Stall.Threshold, time.Duration(stalled), time.Duration(s.DurationNS()))))
+	ret = tw.String()
+	return
+}
+
+func (r StallReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	if err := checkBody(s); err != nil {
+		return nil, err
+	}
+	stalls := []map[string]interface{}{}
+	for _, st := range s.Stall.Stalls {
+		at, _ := phaseSeconds(st.StartTime, s.StartTime)
+		length, _ := phaseSeconds(st.EndTime, st.StartTime)
+		stalls = append(stalls, map[string]interface{}{
+			"StartedAt": at,
+			"Seconds":   length,
+			"Bytes":     st.Bytes,
+		})
+	}
+	return map[string]interface{}{
+		"Threshold": s.Stall.Threshold.Seconds(),
+		"Longest":   nsSeconds(s.Stall.Longest),
+		"Stalls":    stalls,
+	}, nil
+}
+
+// CertificateReporter shows the certificate chain presented by the server
+type CertificateReporter struct{}
+
+// Describe the cipher suite negotiated, and how many were offered if limited
+// with -ciphers
+func (r CertificateReporter) cipher(s *StatsCollector) string {
+	ret := s.Tls.CipherSuiteName
+	if n := len(s.Tls.CipherSuites); n > 0 && s.Tls.Version < tls.VersionTLS13 {
+		ret += fmt.Sprintf(", of the %d offered with -ciphers", n)
+	} else if n > 0 {
+		ret += ", as -ciphers doesn't apply to TLS 1.3"
+	}
+	return ret
+}
+
+// Describe whether the server asked for a client certificate and whether we had
+// one for it, or return an empty string if neither side had anything to do
+// with one
+func (r CertificateReporter) clientCert(s *StatsCollector) string {
+	switch {
+	case s.Tls.ClientCertSent:
+		return "asked for by the server, which accepted the one given with -clientCert"
+	case s.Tls.ClientCertRequested:
+		return "asked for by the server, but none was given (see -clientCert)"
+	case s.Tls.ClientCert:
+		return "given with -clientCert, but the server didn't ask for one"
+	}
+	return ""
+}
+
+// Describe the TLS version negotiated, and those asked for if limited with
+// -tlsMin or -tlsMax
+func (r CertificateReporter) version(s *StatsCollector) string {
+	ret := fmt.Sprintf("%s was negotiated", s.Tls.VersionName)
+	switch min, max := s.Tls.MinVersion, s.Tls.MaxVersion; {
+	case min != 0 && max != 0:
+		ret += fmt.Sprintf(", from %s to %s asked for", tlsVersionName(min), tlsVersionName(max))
+	case min != 0:
+		ret += fmt.Sprintf(", from %s up asked for", tlsVersionName(min))
+	case max != 0:
+		ret += fmt.Sprintf(", up to %s asked for", tlsVersionName(max))
+	}
+	return ret
+}
+
+// Certificates expiring within this long are flagged
+const certExpiryWarning = 14 * 24 * time.Hour
+
+func (r CertificateReporter) Name() string {
+	return "TLS Certificates"
+}
+
+func (r CertificateReporter) Title() string {
+	return "TLS Certificate Chain"
+}
+
+func (r CertificateReporter) Description() string {
+	return "Shows the certificate chain presented by the server and flags any close to expiry"
+}
+
+// Return any problems with the certificate chain or its stapled OCSP response
+func (r CertificateReporter) warnings(s *StatsCollector, now time.Time) []string {
+	warnings := []string{}
+	if s.Tls.Version != 0 && s.Tls.Version < tls.VersionTLS12 {
+		warnings = append(warnings, fmt.Sprintf("%s was negotiated, which is deprecated",
+			s.Tls.VersionName))
+	}
+	for i, c := range s.Tls.Certificates {
+		switch {
+		case now.Before(c.NotBefore):
+			warnings = append(warnings, fmt.Sprintf("certificate %d (%s) is not valid until %s",
+				i, c.Subject, c.NotBefore.UTC().Format(time.RFC3339)))
+		case now.After(c.NotAfter):
+			warnings = append(warnings, fmt.Sprintf("certificate %d (%s) expired on %s",
+				i, c.Subject, c.NotAfter.UTC().Format(time.RFC3339)))
+		case c.NotAfter.Sub(now) < certExpiryWarning:
+			warnings = append(warnings, fmt.Sprintf("certificate %d (%s) expires in %s",
+				i, c.Subject, c.NotAfter.Sub(now).Round(time.Hour)))
+		}
+	}
+
+	if s.Tls.Insecure {
+		if s.Tls.VerifyError != nil {
+			warnings = append(warnings, fmt.Sprintf("verification was skipped with -insecure, and would have failed: %s",
+				s.Tls.VerifyError))
+		} else {
+			warnings = append(warnings, "verification was skipped with -insecure, though it would have passed")
+		}
+	}
+
+	switch o := s.Tls.Ocsp; {
+	case s.Tls.OcspError != nil:
+		warnings = append(warnings, fmt.Sprintf("stapled OCSP response could not be parsed: %s",
+			s.Tls.OcspError))
+	case o == nil:
+	case o.Status == "revoked":
+		warnings = append(warnings, fmt.Sprintf("certificate was revoked at %s",
+			o.RevokedAt.UTC().Format(time.RFC3339)))
+	case !o.NextUpdate.IsZero() && now.After(o.NextUpdate):
+		warnings = append(warnings, "stapled OCSP response is stale")
+	}
+	return warnings
+}
+
+func (r CertificateReporter) Report(s *StatsCollector) (ret string, e error) {
+	if len(s.Tls.Certificates) == 0 {
+		return "", errors.New("No TLS certificates were presented")
+	}
+
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"Depth", "Subject", "Issuer", "Not Before", "Not After", "Names"})
+	for i, c := range s.Tls.Certificates {
+		names := append(append([]string{}, c.DnsNames...), c.IpAddresses...)
+		t.Append([]string{
+			fmt.Sprintf("%d", i),
+			c.Subject,
+			c.Issuer,
+			c.NotBefore.UTC().Format(time.RFC3339),
+			c.NotAfter.UTC().Format(time.RFC3339),
+			strings.Join(names, "\n"),
+		})
+	}
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+	tw.Write([]byte(fmt.Sprintf("Chain length: %d\n", len(s.Tls.Certificates))))
+	tw.Write([]byte(fmt.Sprintf("Version: %s\n", r.version(s))))
+	tw.Write([]byte(fmt.Sprintf("Cipher suite: %s\n", r.cipher(s))))
+	if c := r.clientCert(s); c != "" {
+		tw.Write([]byte(fmt.Sprintf("Client certificate: %s\n", c)))
+	}
+
+	if o := s.Tls.Ocsp; o != nil {
+		tw.Write([]byte(fmt.Sprintf("OCSP: stapled response says %s (produced %s, next update %s)\n",
+			o.Status, o.ProducedAt.UTC().Format(time.RFC3339),
+			o.NextUpdate.UTC().Format(time.RFC3339))))
+	} else if s.Tls.OcspError == nil {
+		tw.Write([]byte("OCSP: no response was stapled\n"))
+	}
+
+	for _, w := range r.warnings(s, time.Now()) {
+		tw.Write([]byte("WARNING: " + w + "\n"))
+	}
+	ret = tw.String()
+	return
+}
+
+// Return the code and name of a TLS version asked for, or nils if it wasn't,
+// for Data
+func versionOrNil(v uint16) (interface{}, interface{}) {
+	if v == 0 {
+		return nil, nil
+	}
+	return v, tlsVersionName(v)
+}
+
+func (r CertificateReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	if len(s.Tls.Certificates) == 0 {
+		return nil, errors.New("No TLS certificates were presented")
+	}
+	// Versions and cipher suites are the codes, as in the stats, with
+	// the names alongside
+	min, minName := versionOrNil(s.Tls.MinVersion)
+	max, maxName := versionOrNil(s.Tls.MaxVersion)
+	return map[string]interface{}{
+		"Certificates":    s.Tls.Certificates,
+		"Version":         s.Tls.Version,
+		"VersionName":     s.Tls.VersionName,
+		"MinVersion":      min,
+		"MinVersionName":  minName,
+		"MaxVersion":      max,
+		"MaxVersionName":  maxName,
+		"CipherSuite":     s.Tls.CipherSuite,
+		"CipherSuiteName": s.Tls.CipherSuiteName,
+		"ClientCert": map[string]interface{}{
+			"Given":     s.Tls.ClientCert,
+			"Requested": s.Tls.ClientCertRequested,
+			"Sent":      s.Tls.ClientCertSent,
+		},
+		"Ocsp":        s.Tls.Ocsp,
+		"Insecure":    s.Tls.Insecure,
+		"VerifyError": s.Tls.VerifyError,
+		"Warnings":    r.warnings(s, time.Now()),
+	}, nil
+}
+
+// RangeReporter shows whether a byte range request was honoured
+type RangeReporter struct{}
+
+func (r RangeReporter) Name() string {
+	return "Byte Range"
+}
+
+func (r RangeReporter) Title() string {
+	return "Byte Range Request"
+}
+
+func (r RangeReporter) Description() string {
+	return "Shows whether the server honoured the byte range requested with -range"
+}
+
+func (r RangeReporter) Report(s *StatsCollector) (ret string, e error) {
+	if s.Range.Requested == "" {
+		return "", errors.New("No byte range was requested (see -range)")
+	}
+
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"Requested", "Status", "Content-Range", "Bytes Received"})
+	t.Append([]string{
+		"bytes=" + s.Range.Requested,
+		fmt.Sprintf("%d", s.Range.StatusCode),
+		s.Range.ContentRange,
+		fmt.Sprintf("%d", s.TotalBytesTransferred()),
+	})
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+
+	if !s.Range.Honoured {
+		tw.Write([]byte("The server ignored the range and returned the whole body\n"))
+	} else {
+		tw.Write([]byte("The server honoured the range with 206 Partial Content\n"))
+		if n, ok := r.expected(s); ok && s.TotalBytesTransferred() != n {
+			tw.Write([]byte(fmt.Sprintf("WARNING: expected %d bytes but received %d\n",
+				n, s.TotalBytesTransferred())))
+		}
+	}
+	ret = tw.String()
+	return
+}
+
+// For an explicit start-end range, we know how much to expect
+func (r RangeReporter) expected(s *StatsCollector) (uint64, bool) {
+	var start, end uint64
+	if n, _ := fmt.Sscanf(s.Range.Requested, "%d-%d", &start, &end); n == 2 && end >= start {
+		return end - start + 1, true
+	}
+	return 0, false
+}
+
+func (r RangeReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	if s.Range.Requested == "" {
+		return nil, errors.New("No byte range was requested (see -range)")
+	}
+	ret := map[string]interface{}{
+		"Requested":     s.Range.Requested,
+		"StatusCode":    s.Range.StatusCode,
+		"ContentRange":  s.Range.ContentRange,
+		"Honoured":      s.Range.Honoured,
+		"BytesReceived": s.TotalBytesTransferred(),
+	}
+	if n, ok := r.expected(s); ok {
+		ret["BytesExpected"] = n
+	}
+	return ret, nil
+}
+
+// ContentReporter checks the body received against what the server said it
+// would send
+type ContentReporter struct{}
+
+func (r ContentReporter) Name() string {
+	return "Content"
+}
+
+func (r ContentReporter) Title() string {
+	return "Content Type and Length"
+}
+
+func (r ContentReporter) Description() string {
+	return "Shows the type and size of the content, and whether the size matches Content-Length"
+}
+
+// Compare the declared Content-Length with what was received, returning a
+// warning if they differ. Nothing can be said if no length was given, and
+// responses to HEAD requests and 204s and 304s never have a body.
+// The length is of the body as sent, so before any decompression. If we
+// stopped early with -maxBytes, it's bound to be short, and with -ttfbOnly
+// there's nothing to compare.
+func (r ContentReporter) mismatch(s *StatsCollector) string {
+	if s.ContentLength < 0 || uint64(s.ContentLength) == s.EncodedBytes() || s.Truncated || s.TransferSkipped {
+		return ""
+	}
+	if s.Request.Method == http.MethodHead || s.StatusCode == http.StatusNoContent ||
+		s.StatusCode == http.StatusNotModified {
+		return ""
+	}
+	if uint64(s.ContentLength) > s.EncodedBytes() {
+		return fmt.Sprintf("the body was truncated: Content-Length was %d but only %d bytes were received",
+			s.ContentLength, s.EncodedBytes())
+	}
+	return fmt.Sprintf("more was received than declared: Content-Length was %d but %d bytes were received",
+		s.ContentLength, s.EncodedBytes())
+}
+
+func (r ContentReporter) Report(s *StatsCollector) (ret string, e error) {
+	if err := checkResponse(s); err != nil {
+		return "", err
+	}
+	length := "not given"
+	if s.ContentLength >= 0 {
+		length = fmt.Sprintf("%d", s.ContentLength)
+	}
+	header := func(k string) string {
+		return strings.Join(s.ResponseHeaders[k], ", ")
+	}
+
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"Content-Type", "Content-Length", "Content-Encoding", "ETag", "Bytes Received", "Decompressed"})
+	received, decompressed := fmt.Sprintf("%d", s.EncodedBytes()), "-"
+	if s.Compression.Decompressed {
+		decompressed = fmt.Sprintf("%d", s.TotalBytesTransferred())
+	}
+	if s.TransferSkipped {
+		received = "skipped"
+	}
+	t.Append([]string{
+		header("Content-Type"),
+		length,
+		header("Content-Encoding"),
+		header("Etag"),
+		received,
+		decompressed,
+	})
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+	if s.TransferSkipped {
+		tw.Write([]byte("The body wasn't read, as -ttfbOnly was given\n"))
+	} else if s.Truncated {
+		tw.Write([]byte("The transfer was stopped early by -maxBytes, so the body is larger than was received\n"))
+	} else if m := r.mismatch(s); m != "" {
+		tw.Write([]byte("WARNING: " + m + "\n"))
+	} else if s.ContentLength < 0 {
+		tw.Write([]byte("The server didn't give a Content-Length, so the size can't be checked\n"))
+	}
+	if s.Compression.Decompressed && s.EncodedBytes() > 0 && !s.Truncated {
+		tw.Write([]byte(fmt.Sprintf("The body was %s compressed to %.1f%% of its size\n",
+			s.Compression.Encoding, float64(s.EncodedBytes())/float64(s.TotalBytesTransferred())*100)))
+	} else if s.Compression.Encoding != "" {
+		tw.Write([]byte(fmt.Sprintf("The body was left %s encoded, so what was saved is still compressed\n",
+			s.Compression.Encoding)))
+	}
+	if s.Resume.Resumed {
+		tw.Write([]byte(fmt.Sprintf("The download was resumed, with the first %d bytes already in -outFile, so only the rest was received\n",
+			s.Resume.Offset)))
+	}
+	ret = tw.String()
+	return
+}
+
+func (r ContentReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	if err := checkResponse(s); err != nil {
+		return nil, err
+	}
+	ret := map[string]interface{}{
+		"ContentType":     strings.Join(s.ResponseHeaders["Content-Type"], ", "),
+		"ContentEncoding": strings.Join(s.ResponseHeaders["Content-Encoding"], ", "),
+		"Etag":            strings.Join(s.ResponseHeaders["Etag"], ", "),
+		"BytesReceived":   s.EncodedBytes(),
+		"Decompressed":    s.Compression.Decompressed,
+		"LengthMatches":   r.mismatch(s) == "",
+		"Truncated":       s.Truncated,
+		"TransferSkipped": s.TransferSkipped,
+	}
+	if s.ContentLength >= 0 {
+		ret["ContentLength"] = s.ContentLength
+	}
+	if s.Compression.Decompressed {
+		ret["DecompressedBytes"] = s.TotalBytesTransferred()
+	}
+	if s.Resume.Resumed {
+		ret["ResumedFrom"] = s.Resume.Offset
+	}
+	return ret, nil
+}
+
+// DigestReporter shows checksums of the downloaded content
+type DigestReporter struct{}
+
+func (r DigestReporter) Name() string {
+	return "Content Digest"
+}
+
+func (r DigestReporter) Title() string {
+	return "Content Checksums"
+}
+
+func (r DigestReporter) Description() string {
+	return "Shows the SHA-256 and MD5 of the content, and whether they match -sha256 and -md5"
+}
+
+// Describe how a digest compares with the one expected, if any
+func digestResult(actual string, expected string) string {
+	switch {
+	case expected == "":
+		return ""
+	case actual == expected:
+		return "match"
+	}
+	return "MISMATCH"
+}
+
+func (r DigestReporter) Report(s *StatsCollector) (ret string, e error) {
+	if err := checkBody(s); err != nil {
+		return "", err
+	}
+	if s.Digest.Sha256 == "" {
+		return "", errors.New("The transfer didn't complete, so no digest was computed")
+	}
+
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"Algorithm", "Digest", "Expected", "Result"})
+	t.Append([]string{"SHA-256", s.Digest.Sha256, s.Digest.ExpectedSha256,
+		digestResult(s.Digest.Sha256, s.Digest.ExpectedSha256)})
+	t.Append([]string{"MD5", s.Digest.Md5, s.Digest.ExpectedMd5,
+		digestResult(s.Digest.Md5, s.Digest.ExpectedMd5)})
+	if s.Verify.Cid != "" {
+		t.Append([]string{"CID (" + s.Verify.Hash + ")", s.Verify.Actual, s.Verify.Expected,
+			digestResult(s.Verify.Actual, s.Verify.Expected)})
+	}
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+	ret = tw.String()
+	return
+}
+
+func (r DigestReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	if err := checkBody(s); err != nil {
+		return nil, err
+	}
+	if s.Digest.Sha256 == "" {
+		return nil, errors.New("The transfer didn't complete, so no digest was computed")
+	}
+	ret := map[string]interface{}{
+		"Sha256": s.Digest.Sha256,
+		"Md5":    s.Digest.Md5,
+	}
+	if s.Digest.ExpectedSha256 != "" {
+		ret["Sha256Match"] = s.Digest.Sha256 == s.Digest.ExpectedSha256
+	}
+	if s.Digest.ExpectedMd5 != "" {
+		ret["Md5Match"] = s.Digest.Md5 == s.Digest.ExpectedMd5
+	}
+	if s.Verify.Cid != "" {
+		ret["Cid"] = s.Verify.Cid
+		ret["CidMatch"] = s.Verify.Match
+	}
+	return ret, nil
+}
@@ -0,0 +1,145 @@
+package diag
+
+import (
+	"context"
+	"crypto/tls"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConnectionReporterPhases(t *testing.T) {
+	d, err := ConnectionReporter{}.Data(phaseStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]float64{
+		"DnsLookup":  0.010,
+		"Connection": 0.020,
+		"Tls":        0.030,
+		"Request":    0.005,
+		"FirstByte":  0.050,
+	} {
+		got, ok := d[key].(float64)
+		if !ok {
+			t.Errorf("%s: got %v, want %g", key, d[key], want)
+		} else if math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s: got %g, want %g", key, got, want)
+		}
+	}
+}
+
+func TestConnectionReporterHttp(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+	s, err := Probe(context.Background(), Options{Uri: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without TLS, its phase never happened, but the others did
+	if d, ok := s.PhaseDuration(PhaseTls); ok {
+		t.Errorf("TLS over http:// took %s, want not ok", d)
+	}
+	for _, p := range []Phase{PhaseConnect, PhaseRequest, PhaseFirstByte} {
+		if _, ok := s.PhaseDuration(p); !ok {
+			t.Errorf("%s: not ok", p)
+		}
+	}
+
+	r := ConnectionReporter{}
+	d, err := r.Data(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d["Tls"] != nil {
+		t.Errorf("Tls: got %v, want nil", d["Tls"])
+	}
+	report, err := r.Report(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first line of the row of timings, after the border, header and
+	// border, with TLS the third column
+	lines := strings.Split(report, "\n")
+	if len(lines) < 4 {
+		t.Fatalf("No timings in:\n%s", report)
+	}
+	if cells := strings.Split(lines[3], "|"); len(cells) < 4 || strings.TrimSpace(cells[3]) != "n/a" {
+		t.Errorf("TLS not shown as n/a in:\n%s", report)
+	}
+	if v, ok := r.NsDiffInSeconds(s.Tls.EndTime, s.Tls.StartTime); ok || v != 0 {
+		t.Errorf("NsDiffInSeconds of an unset phase: got %g, %t", v, ok)
+	}
+}
+
+func TestReportersFailedConnection(t *testing.T) {
+	// Nothing listens on port 1, so the request never gets a connection
+	s, err := Probe(context.Background(), Options{
+		Uri:     "ipfs://bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku",
+		Gateway: "http://127.0.0.1:1",
+	})
+	if err == nil {
+		t.Fatal("Got no error requesting from a closed port")
+	}
+	if s == nil {
+		t.Fatal("Got no stats for the failed request")
+	}
+	// The gateway URL alone isn't enough for IPFSGW to report on
+	if _, err := (IpfsGwReporter{}).Data(s); err == nil {
+		t.Error("IPFSGW reported on a request that never got a response")
+	}
+	// Each reporter either reports what it can or says why it can't, but
+	// none of them should panic
+	for name, r := range ReportersList {
+		if _, err := r.Report(s); err != nil {
+			t.Logf("%s: Report: %s", name, err)
+		}
+		if _, err := r.Data(s); err != nil {
+			t.Logf("%s: Data: %s", name, err)
+		}
+	}
+}
+
+func TestTlsDataSchema(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	s, err := Probe(context.Background(), Options{Uri: srv.URL, Insecure: true, TlsMin: tls.VersionTLS12})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each version and cipher suite is its code, with its name alongside
+	cert, err := CertificateReporter{}.Data(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := ConnectionReporter{}.Data(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		data       map[string]interface{}
+		code, name string
+	}{
+		{cert, "Version", "VersionName"},
+		{cert, "MinVersion", "MinVersionName"},
+		{cert, "CipherSuite", "CipherSuiteName"},
+		{conn, "TlsVersion", "TlsVersionName"},
+		{conn, "TlsCipherSuite", "TlsCipherSuiteName"},
+	} {
+		if _, ok := tt.data[tt.code].(uint16); !ok {
+			t.Errorf("%s: got %#v, want a code", tt.code, tt.data[tt.code])
+		}
+		if name, ok := tt.data[tt.name].(string); !ok || name == "" {
+			t.Errorf("%s: got %#v, want a name", tt.name, tt.data[tt.name])
+		}
+	}
+	if cert["MaxVersion"] != nil || cert["MaxVersionName"] != nil {
+		t.Errorf("MaxVersion wasn't limited, but got %v, %v", cert["MaxVersion"], cert["MaxVersionName"])
+	}
+}
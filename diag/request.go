@@ -0,0 +1,792 @@
+package diag
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The most redirects followed when there's no limit, so that a loop still ends
+const maxRedirectsCap = 100
+
+// The redirects followed when Options doesn't say, as for -maxRedirects
+const defaultMaxRedirects = 10
+
+// The headers that ask for content not to come from a cache, by the names
+// -noCacheHeaders picks them out with, in the order they're added. -noCache
+// adds all of them.
+var NoCacheHeaders = []struct {
+	Name  string
+	Key   string
+	Value string
+}{
+	{"pragma", "Pragma", "no-cache"},
+	{"no-cache", "Cache-Control", "no-cache"},
+	{"no-store", "Cache-Control", "no-store"},
+	{"must-revalidate", "Cache-Control", "must-revalidate"},
+	{"expires", "Expires", "0"},
+}
+
+// Write the status line and headers of resp to path in the form they're sent
+// in over HTTP/1.1, preceded by the request that got it and any body sent if
+// opts.ReqDump is set. Sensitive headers are redacted, as in the stats.
+func writeHeaderDump(path string, resp *http.Response, opts Options, redact []string) error {
+	LogInfo("Writing headers to '%s'", path)
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	if req := resp.Request; opts.ReqDump && req != nil {
+		writeRequestHead(w, resp, redactHeaders(req.Header, redact))
+		w.Write(opts.Body)
+		if len(opts.Body) > 0 {
+			w.WriteString("\r\n\r\n")
+		}
+	}
+	writeResponseHead(w, resp, redactHeaders(resp.Header, redact))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// Write the request line and headers of the request that got resp, with the
+// headers h, as they're sent over HTTP/1.1
+func writeRequestHead(w io.Writer, resp *http.Response, h http.Header) {
+	req := resp.Request
+	// Go sends HTTP/1.1 requests, whatever the server answers with,
+	// unless HTTP/2 was negotiated or HTTP/3 used
+	proto := "HTTP/1.1"
+	if resp.ProtoMajor >= 2 {
+		proto = resp.Proto
+	}
+	fmt.Fprintf(w, "%s %s %s\r\n", req.Method, req.URL.RequestURI(), proto)
+	fmt.Fprintf(w, "Host: %s\r\n", req.Host)
+	if req.ContentLength > 0 {
+		fmt.Fprintf(w, "Content-Length: %d\r\n", req.ContentLength)
+	}
+	h.Write(w)
+	io.WriteString(w, "\r\n")
+}
+
+// Write the status line and headers of resp, with the headers h, as they're
+// sent over HTTP/1.1. Go takes Transfer-Encoding and Trailer out of the
+// headers, so they're put back.
+func writeResponseHead(w io.Writer, resp *http.Response, h http.Header) {
+	fmt.Fprintf(w, "%s %s\r\n", resp.Proto, resp.Status)
+	if len(resp.TransferEncoding) > 0 {
+		fmt.Fprintf(w, "Transfer-Encoding: %s\r\n", strings.Join(resp.TransferEncoding, ", "))
+	}
+	if len(resp.Trailer) > 0 {
+		names := []string{}
+		for k := range resp.Trailer {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(w, "Trailer: %s\r\n", strings.Join(names, ", "))
+	}
+	h.Write(w)
+	io.WriteString(w, "\r\n")
+}
+
+// Return how many bytes write puts out
+func headSize(write func(io.Writer)) uint64 {
+	b := &bytes.Buffer{}
+	write(b)
+	return uint64(b.Len())
+}
+
+// stdoutFile is stdout as the file the body is written to, which mustn't be
+// closed once the body is done, as there may be more to come
+type stdoutFile struct {
+	io.Writer
+}
+
+func (f stdoutFile) Close() error {
+	return nil
+}
+
+// Open path to write the body of resp to, which is stdout if it's "-". When
+// resuming, the body is added to
+// the end of what's already there if the server sent the rest of it, and the
+// file is only started again if the server sent the whole of it instead.
+// Otherwise, what's there is left alone and the body is thrown away.
+func openOutFile(path string, stdout io.Writer, resp *http.Response, s *StatsCollector) (io.WriteCloser, *RequestError) {
+	var (
+		f   *os.File
+		err error
+	)
+	switch {
+	case path == "-":
+		return stdoutFile{stdout}, nil
+	case s.Resume.Offset == 0:
+		f, err = os.Create(path)
+	case resp.StatusCode == http.StatusPartialContent:
+		cr := resp.Header.Get("Content-Range")
+		if !strings.HasPrefix(cr, fmt.Sprintf("bytes %d-", s.Resume.Offset)) {
+			return nil, &RequestError{ExitTransfer,
+				fmt.Errorf("asked for the rest of '%s' from byte %d, but the server sent '%s'",
+					path, s.Resume.Offset, cr)}
+		}
+		s.Resume.Resumed = true
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	case resp.StatusCode == http.StatusOK:
+		LogInfo("The server sent all of the content rather than the rest, so starting '%s' again", path)
+		f, err = os.Create(path)
+	default:
+		LogInfo("Leaving '%s' as it is, as the server returned status %d", path, resp.StatusCode)
+		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			LogInfo("There's nothing after byte %d, so '%s' may already be complete", s.Resume.Offset, path)
+		}
+		f, err = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	}
+	if err != nil {
+		return nil, &RequestError{ExitOutput,
+			fmt.Errorf("unable to open '%s': %w", path, err)}
+	}
+	return f, nil
+}
+
+// Write the contents of the file at path to w
+func hashFile(path string, w io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// statsKey is the key the StatsCollector for a request is kept under in its
+// context, for anything that's only handed the context
+type statsKey struct{}
+
+// Return a GetClientCertificate function for the transport's TLS config, which
+// offers cert if the server asks for a client certificate, and records that
+// it did in the stats of the request the handshake is for. With no cert,
+// none is offered.
+func ClientCertificate(cert *tls.Certificate) func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		if s, ok := info.Context().Value(statsKey{}).(*StatsCollector); ok {
+			s.ClientCertRequested(cert != nil)
+		}
+		if cert == nil {
+			return &tls.Certificate{}, nil
+		}
+		return cert, nil
+	}
+}
+
+// Options holds the settings that control how each request is made.
+type Options struct {
+	// Uri is what Probe requests, and Gateway the gateway it requests
+	// ipfs:// and ipns:// URIs from, which is DefaultGateway if it's empty
+	Uri     string
+	Gateway string
+	// Transport, if set, is what Probe makes its requests over, so that
+	// connections can be shared between probes. Otherwise one is made
+	// with NewTransport.
+	Transport Transport
+	// Version is that of whatever is making the request, which is
+	// recorded in the stats
+	Version string
+	// NoCache names which of NoCacheHeaders to add to the request
+	NoCache []string
+	// OutFile is where to write the body, which is stdout if it's "-",
+	// and Tee also writes it to stdout. Stdout is what's used as stdout
+	// for those, which is os.Stdout if it's nil.
+	OutFile string
+	Tee     bool
+	Stdout  io.Writer
+	// HeaderOut, if set, is a file to write the response headers to as
+	// they came over the wire, preceded by the request if ReqDump is set
+	HeaderOut string
+	ReqDump   bool
+	Timeout   time.Duration
+	// Reuse allows connections to be kept open between requests, and
+	// NoKeepAlive is set if the transport has keepalives disabled, so that
+	// no connection is ever used twice
+	Reuse       bool
+	NoKeepAlive bool
+	// Resolver describes what is being used for DNS lookups
+	Resolver string
+	// Range is the byte range to request, e.g. 0-1023
+	Range string
+	// Resume asks for just the rest of the content if OutFile already
+	// has the start of it, and adds that to the end
+	Resume bool
+	// Method is the HTTP method to use, and Body the request body, if any
+	Method string
+	Body   []byte
+	// Headers are added to the request, alongside any we set ourselves
+	Headers http.Header
+	// Progress has a progress bar drawn on stderr during the transfer
+	Progress bool
+	// VerifyCid, if set, is checked against a hash of the content
+	VerifyCid *Cid
+	// Sha256 and Md5 are the checksums the content is expected to have,
+	// in lower case hex, if known
+	Sha256 string
+	Md5    string
+	// Retries is how many times to retry a failed request, waiting
+	// RetryDelay before the first retry and doubling it each time after
+	Retries    int
+	RetryDelay time.Duration
+	// ForceRetry retries methods that aren't idempotent, such as POST,
+	// which otherwise aren't retried at all
+	ForceRetry bool
+	// Resolve maps host:port to the IP address to connect to instead,
+	// with -resolve
+	Resolve map[string]string
+	// PtrResolver, if set, is used for a reverse lookup of the server's
+	// address once the transfer is done, with -ptr
+	PtrResolver *net.Resolver
+	// CnameResolver, if set, is used to look up the chain of CNAMEs for
+	// the host once the transfer is done, with -cname
+	CnameResolver *net.Resolver
+	// DnsLinkResolver, if set, is used to look up the DNSLink record of an
+	// ipns:// URI with a DNS name once the transfer is done
+	DnsLinkResolver *net.Resolver
+	// Insecure is set if the transport skips certificate verification
+	Insecure bool
+	// ClientCert is set if the transport has a client certificate to offer,
+	// which NewTransport offers Certificate as
+	ClientCert  bool
+	Certificate *tls.Certificate
+	// DialTimeout and TlsTimeout limit how long NewTransport's transport
+	// takes to connect and for the TLS handshake, if set
+	DialTimeout time.Duration
+	TlsTimeout  time.Duration
+	// Network is tcp4 or tcp6 to only connect over IPv4 or IPv6, or empty
+	// for either
+	Network string
+	// Http3 has NewTransport make requests over HTTP/3 (QUIC) rather than
+	// HTTP/1.1 or HTTP/2 over TCP. It can't be used with a proxy.
+	Http3 bool
+	// NameResolver, if set, is used for DNS lookups instead of the system
+	// resolver, as with -dns and -doh
+	NameResolver *net.Resolver
+	// Redact lists headers whose values are hidden in the stats, as well
+	// as those that always are
+	Redact []string
+	// TlsMin and TlsMax are the TLS versions the transport was limited to
+	// with -tlsMin and -tlsMax, or 0 if left to the defaults
+	TlsMin uint16
+	TlsMax uint16
+	// CipherSuites are those the transport was limited to with -ciphers,
+	// if any
+	CipherSuites []uint16
+	// Proxy picks the proxy for each request, as for http.Transport
+	Proxy func(*http.Request) (*url.URL, error)
+	// StallThreshold is how long a gap in the transfer has to be to count
+	// as a stall
+	StallThreshold time.Duration
+	// NoCompress stops us asking for a compressed body, with -noCompress
+	NoCompress bool
+	// MaxBytes, if set, is how much of the content to download before
+	// giving up on the rest
+	MaxBytes int64
+	// FailOn is the lowest status code that counts as a failed request,
+	// with 0 meaning the status never does
+	FailOn int
+	// TtfbOnly stops once the response headers arrive, without reading
+	// the body
+	TtfbOnly bool
+	// UserAgent is sent unless a User-Agent was given in Headers. If it's
+	// empty, none is sent at all.
+	UserAgent string
+	// MaxRedirects is how many redirects to follow, or the default of 10
+	// if it's nil. 0 follows none, so that the first redirect is the
+	// response, and a negative number follows as many as maxRedirectsCap.
+	MaxRedirects *int
+}
+
+// Whether err is down to running out of time, with -timeout, -dialTimeout or
+// -tlsTimeout
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// uploadCounter passes the request body through, counting it as it's sent
+type uploadCounter struct {
+	r io.Reader
+	s *StatsCollector
+}
+
+func (u *uploadCounter) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	u.s.Uploaded(n)
+	return n, err
+}
+
+// maxBytesReader passes on up to left bytes of the body for -maxBytes. It
+// reads from r, which is limited to one more byte than that, so that whether
+// there was any more shows as part of the transfer without waiting on the body
+// again afterwards. That extra byte isn't passed on or counted.
+type maxBytesReader struct {
+	r    io.Reader
+	left int64
+	more bool
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	if int64(n) > m.left {
+		m.more = true
+		n = int(m.left)
+	}
+	m.left -= int64(n)
+	if m.more {
+		return n, io.EOF
+	}
+	return n, err
+}
+
+// encodedCounter passes a compressed response body through, counting it as it's
+// received and before it's decompressed
+type encodedCounter struct {
+	r io.Reader
+	s *StatsCollector
+}
+
+func (w *encodedCounter) Read(p []byte) (int, error) {
+	n, err := w.r.Read(p)
+	w.s.Compression.Bytes += uint64(n)
+	return n, err
+}
+
+// Make a single request for uri over the given transport, tracing it into s
+// and writing the body to opts.OutFile. On failure a *RequestError is returned
+// carrying the exit code for the class of failure. Cancelling ctx stops the
+// request wherever it's got to, as does opts.Timeout running out, and s has
+// the timings up to then.
+func doRequest(ctx context.Context, t http.RoundTripper, uri string, opts Options, s *StatsCollector) error {
+	LogInfo("Downloading '%s'", uri)
+	s.Uri = uri
+	s.Version = opts.Version
+	s.Dns.Resolver = opts.Resolver
+	s.Session.KeepAliveDisabled = opts.NoKeepAlive
+	s.Tls.MinVersion, s.Tls.MaxVersion = opts.TlsMin, opts.TlsMax
+	s.Tls.CipherSuites = opts.CipherSuites
+	s.Tls.ClientCert = opts.ClientCert
+	s.redact = opts.Redact
+	h3, _ := t.(*http3Transport)
+	if h3 != nil {
+		s.Quic = &QuicInfo{}
+	}
+	s.Digest.ExpectedSha256 = opts.Sha256
+	s.Digest.ExpectedMd5 = opts.Md5
+
+	method := opts.Method
+	if method == "" {
+		method = "GET"
+	}
+	s.Request.Method = method
+	// The lookups once the transfer is done aren't part of the timeout
+	lookupCtx := ctx
+	if opts.Timeout > 0 {
+		// A deadline rather than the client's Timeout, so that it
+		// covers reading the body too and ends it the same way as
+		// being cancelled
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	// So that the TLS handshake can say whether a client certificate
+	// was asked for
+	ctx = context.WithValue(ctx, statsKey{}, s)
+	req, err := http.NewRequestWithContext(ctx, method, uri, nil)
+	if err != nil {
+		return &RequestError{ExitRequest, fmt.Errorf("request for %s failed: %w", uri, err)}
+	}
+	if opts.Body != nil {
+		// Set the body up by hand so that we can count it as it goes,
+		// and so it can be sent again if we're redirected. Only the
+		// last time it's sent is counted.
+		req.GetBody = func() (io.ReadCloser, error) {
+			s.RestartUpload()
+			return io.NopCloser(&uploadCounter{bytes.NewReader(opts.Body), s}), nil
+		}
+		req.Body, _ = req.GetBody()
+		req.ContentLength = int64(len(opts.Body))
+	}
+
+	// Hook into certain HTTP tracing points
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(dnsInfo httptrace.DNSStartInfo) {
+			s.StartDns(dnsInfo.Host)
+		},
+		DNSDone: func(dnsInfo httptrace.DNSDoneInfo) {
+			s.EndDns(dnsInfo.Addrs)
+		},
+		TLSHandshakeStart: func() {
+			s.StartTls()
+		},
+		TLSHandshakeDone: func(t tls.ConnectionState, err error) {
+			s.EndTls(t)
+			if opts.Insecure {
+				s.CheckVerification(t)
+			}
+		},
+		ConnectStart: func(net string, addr string) {
+			s.StartConnect(net, addr)
+		},
+		ConnectDone: func(net string, addr string, err error) {
+			s.EndConnect(net, addr, err)
+		},
+		GetConn: func(hostPort string) {
+			s.StartSession(hostPort)
+			if ip, ok := opts.Resolve[strings.ToLower(hostPort)]; ok {
+				s.PinnedAddress(ip)
+			}
+		},
+		GotConn: func(connInfo httptrace.GotConnInfo) {
+			s.GotSession(connInfo)
+		},
+		WroteHeaders: func() {
+			s.WroteHeaders()
+		},
+		Wait100Continue: func() {
+			s.Wait100Continue()
+		},
+		Got100Continue: func() {
+			s.Got100Continue()
+		},
+		WroteRequest: func(w httptrace.WroteRequestInfo) {
+			s.WroteRequest(w.Err)
+		},
+		GotFirstResponseByte: func() {
+			s.FirstByteReceived()
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	if len(opts.NoCache) > 0 {
+		LogInfo("Requesting that content not come from cache with %s", strings.Join(opts.NoCache, ", "))
+		for _, h := range NoCacheHeaders {
+			for _, name := range opts.NoCache {
+				if name == h.Name {
+					req.Header.Add(h.Key, h.Value)
+				}
+			}
+		}
+	}
+	for k, vs := range opts.Headers {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	if _, ok := req.Header["User-Agent"]; !ok {
+		// Setting it to nothing stops Go sending its own
+		req.Header.Set("User-Agent", opts.UserAgent)
+	}
+	// Go takes the Host header from the request rather than the headers
+	if h := opts.Headers.Get("Host"); h != "" {
+		req.Host = h
+	}
+	if opts.Resume {
+		if fi, err := os.Stat(opts.OutFile); err == nil && fi.Size() > 0 {
+			LogInfo("Resuming '%s' from byte %d", opts.OutFile, fi.Size())
+			s.Resume.Offset = fi.Size()
+			opts.Range = fmt.Sprintf("%d-", fi.Size())
+		}
+	}
+	if opts.Range != "" {
+		req.Header.Set("Range", "bytes="+opts.Range)
+		s.Range.Requested = opts.Range
+	}
+	if opts.VerifyCid != nil && req.Header.Get("Accept") == "" {
+		// The CID is the hash of the block, rather than of the file it
+		// may be the root of, so ask the gateway for the block itself.
+		req.Header.Set("Accept", "application/vnd.ipld.raw")
+	}
+	if !opts.NoCompress && opts.Range == "" && req.Header.Get("Accept-Encoding") == "" {
+		// Ask for gzip ourselves rather than leaving it to the
+		// transport, which would hide how much came over the wire.
+		// As with the transport, not for ranges, which would be of
+		// the compressed body.
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	s.SetRequestHeaders(req.Header)
+	if opts.Proxy != nil && h3 == nil {
+		if u, err := opts.Proxy(req); err == nil && u != nil {
+			s.SetProxy(u)
+		}
+	}
+	switch {
+	case opts.MaxRedirects == nil:
+		s.MaxRedirects = defaultMaxRedirects
+	case *opts.MaxRedirects < 0:
+		s.MaxRedirects = maxRedirectsCap
+	default:
+		s.MaxRedirects = *opts.MaxRedirects
+	}
+	cli := &http.Client{
+		CheckRedirect: func(r *http.Request, via []*http.Request) error {
+			// via has the request for each redirect followed so far,
+			// as well as the first
+			if len(via) > s.MaxRedirects {
+				s.UnfollowedRedirect = r.URL.String()
+				if s.MaxRedirects == 0 {
+					// The redirect is what's being looked at
+					LogInfo("Not following the redirect to %s, as -maxRedirects is 0", r.URL)
+					return http.ErrUseLastResponse
+				}
+				return fmt.Errorf("stopped after %d redirects", s.MaxRedirects)
+			}
+			code, loc := 0, ""
+			if r.Response != nil {
+				code = r.Response.StatusCode
+				loc = r.Response.Header.Get("Location")
+			}
+			s.AddRedirect(via[len(via)-1].URL.String(), r.URL.String(), code, loc)
+			return nil
+		},
+		Transport: t,
+	}
+	s.Begin()
+	// However the request ends, there's a total time for it, even if it
+	// failed or was cancelled before the body
+	defer func() {
+		if s.Total.EndTime == 0 {
+			s.Finish()
+		}
+	}()
+	resp, err := cli.Do(req)
+	if err != nil {
+		if s.Tls.ClientCertRequested && !s.Tls.ClientCertSent {
+			LogError("The server asked for a client certificate, which can be given with -clientCert")
+		} else if s.Tls.ClientCertRequested {
+			LogError("The server asked for a client certificate, and may have rejected the one given with -clientCert")
+		}
+		if s.UnfollowedRedirect != "" {
+			// Most likely a redirect loop, which is down to the server
+			return &RequestError{ExitStatus, fmt.Errorf("request for %s failed: %w", uri, err)}
+		}
+		if p, ok := s.UnfinishedPhase(); ok && (isTimeout(err) || errors.Is(err, context.Canceled)) {
+			// Say where it got stuck, rather than just that it did
+			s.Finish()
+			s.StoppedDuring = p.String()
+			what := "timed out"
+			if errors.Is(err, context.Canceled) {
+				what = "was interrupted"
+			}
+			total, _ := s.PhaseDuration(PhaseTotal)
+			return &RequestError{failureClass(s, err),
+				fmt.Errorf("request for %s %s during the %s after %f seconds: %w", uri, what, p,
+					total.Seconds(), err)}
+		}
+		return &RequestError{failureClass(s, err),
+			fmt.Errorf("request for %s failed: %w", uri, err)}
+	}
+	defer resp.Body.Close()
+
+	s.StatusCode, s.Status = resp.StatusCode, resp.Status
+	s.Proto, s.ProtoMajor, s.ProtoMinor = resp.Proto, resp.ProtoMajor, resp.ProtoMinor
+	s.Close = resp.Close
+	if h3 != nil {
+		h3.describe(s)
+	}
+	LogInfo("Response was %s %s", resp.Proto, resp.Status)
+	s.SetResponseHeaders(resp.Header)
+	// Counted before redaction, as that's what went over the wire
+	s.HeaderBytes.Request = headSize(func(w io.Writer) { writeRequestHead(w, resp, resp.Request.Header) })
+	s.HeaderBytes.Response = headSize(func(w io.Writer) { writeResponseHead(w, resp, resp.Header) })
+	s.ContentLength = resp.ContentLength
+	s.TransferEncoding = resp.TransferEncoding
+	if opts.HeaderOut != "" {
+		if err := writeHeaderDump(opts.HeaderOut, resp, opts, s.redact); err != nil {
+			return &RequestError{ExitOutput,
+				fmt.Errorf("unable to write headers to '%s': %w", opts.HeaderOut, err)}
+		}
+	}
+	if opts.Range != "" {
+		s.SetRangeResponse(resp.StatusCode, resp.Header.Get("Content-Range"))
+	}
+
+	if opts.TtfbOnly {
+		// Closing the body unread drops the connection rather than
+		// waiting for the rest of it
+		s.TransferSkipped = true
+		s.Finish()
+		resp.Body.Close()
+		LogInfo("Skipping the body, as asked to with -ttfbOnly")
+		return nil
+	}
+
+	LogInfo("Writing retrieved data to '%s'", opts.OutFile)
+	stdout := opts.Stdout
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	out, rerr := openOutFile(opts.OutFile, stdout, resp, s)
+	if rerr != nil {
+		return rerr
+	}
+	defer out.Close()
+	dst := io.Writer(out)
+	if opts.Tee {
+		dst = io.MultiWriter(out, stdout)
+	}
+
+	if opts.Progress {
+		s.ShowProgress(os.Stderr)
+	}
+	// Checksum the content as it streams past, along with hashing it for
+	// its CID if we're to check that
+	sha, md := sha256.New(), md5.New()
+	sink := io.MultiWriter(s, sha, md)
+	var h hash.Hash
+	if opts.VerifyCid != nil {
+		if h, err = opts.VerifyCid.NewHash(); err != nil {
+			return &RequestError{ExitVerify, err}
+		}
+		sink = io.MultiWriter(sink, h)
+	}
+	var car *carVerifier
+	if isCar(resp) {
+		// Parsed as it streams past, like the hashes
+		car = newCarVerifier()
+		sink = io.MultiWriter(sink, car)
+	}
+	if s.Resume.Resumed {
+		// The digests are of the whole of the content, so start them
+		// off with what we already had
+		hashes := io.MultiWriter(sha, md)
+		if h != nil {
+			hashes = io.MultiWriter(hashes, h)
+		}
+		if car != nil {
+			hashes = io.MultiWriter(hashes, car)
+		}
+		if err := hashFile(opts.OutFile, hashes); err != nil {
+			return &RequestError{ExitOutput,
+				fmt.Errorf("unable to read '%s' to resume it: %w", opts.OutFile, err)}
+		}
+	}
+
+	body := io.Reader(resp.Body)
+	s.Compression.Encoding = resp.Header.Get("Content-Encoding")
+	if !opts.NoCompress && strings.EqualFold(s.Compression.Encoding, "gzip") {
+		gz, err := gzip.NewReader(&encodedCounter{resp.Body, s})
+		if err != nil {
+			return &RequestError{ExitTransfer,
+				fmt.Errorf("unable to decompress the body from %s: %w", uri, err)}
+		}
+		defer gz.Close()
+		body = gz
+		s.Compression.Decompressed = true
+	}
+
+	var limit *maxBytesReader
+	if opts.MaxBytes > 0 {
+		limit = &maxBytesReader{io.LimitReader(body, opts.MaxBytes+1), opts.MaxBytes, false}
+		body = limit
+	}
+	src := io.TeeReader(body, sink)
+
+	s.Stall.Threshold = opts.StallThreshold
+	s.Start()
+	_, err = io.Copy(dst, src)
+	s.Stop()
+	s.Finish()
+	s.SetResponseTrailers(resp.Trailer)
+	if len(s.ResponseTrailers) > 0 {
+		s.HeaderBytes.Trailers = headSize(func(w io.Writer) { resp.Trailer.Write(w) })
+	}
+	if car != nil {
+		s.Car = car.Close()
+	}
+	if err == nil && limit != nil && limit.more {
+		s.Truncated = true
+		LogInfo("Stopped after %d bytes, as limited by -maxBytes", opts.MaxBytes)
+		// Closing the body before the end drops the connection,
+		// rather than it reading the rest
+		resp.Body.Close()
+	}
+	if err != nil && (errors.Is(err, context.Canceled) || isTimeout(err)) {
+		s.StoppedDuring = PhaseTransfer.String()
+	}
+	if err != nil && errors.Is(err, context.Canceled) {
+		return &RequestError{ExitInterrupted,
+			fmt.Errorf("transfer from %s was interrupted after %d bytes in %f seconds (%f kB/s): %w", uri,
+				s.TotalBytesTransferred(), float64(s.DurationNS())/float64(time.Second), s.KBPerSecond(), err)}
+	}
+	if err != nil && isTimeout(err) {
+		return &RequestError{ExitTransfer,
+			fmt.Errorf("transfer from %s timed out after %d bytes in %f seconds: %w", uri,
+				s.TotalBytesTransferred(), float64(s.DurationNS())/float64(time.Second), err)}
+	}
+	if err != nil {
+		return &RequestError{ExitTransfer,
+			fmt.Errorf("transfer from %s failed after %d bytes: %w", uri,
+				s.TotalBytesTransferred(), err)}
+	}
+	LogInfo("Total transferred: %d in %d (%f kB/s), %d end to end",
+		s.TotalBytesTransferred(), s.DurationNS(), s.KBPerSecond(), s.TotalDurationNS())
+	if s.Compression.Decompressed {
+		LogInfo("Received %d bytes of %s compressed content", s.EncodedBytes(), s.Compression.Encoding)
+	}
+	logVerbose("Read %d bytes from the connection and wrote %d", s.Wire.Read, s.Wire.Written)
+
+	if opts.PtrResolver != nil {
+		// Left until now so that it can't get in the way of the timings
+		if ip, err := remoteIp(s); err == nil {
+			ctx, cancel := context.WithTimeout(lookupCtx, 5*time.Second)
+			names, err := opts.PtrResolver.LookupAddr(ctx, ip.String())
+			cancel()
+			s.SetPtr(names, err)
+		}
+	}
+	if opts.CnameResolver != nil && s.Dns.Host != "" && s.Dns.Pinned == "" {
+		// Also left until now, so that the lookup being timed can't
+		// have been answered from a cache this warmed up
+		s.SetCnames(LookupCnames(lookupCtx, opts.CnameResolver, s.Dns.Host))
+	}
+	if opts.DnsLinkResolver != nil && s.Ipfs != nil && s.Ipfs.Namespace == "ipns" && isDnsLinkName(s.Ipfs.Cid) {
+		records, err := LookupDnsLink(lookupCtx, opts.DnsLinkResolver, s.Ipfs.Cid)
+		s.SetDnsLink(s.Ipfs.Cid, records, err)
+	}
+
+	if err := s.SetDigests(sha.Sum(nil), md.Sum(nil)); err != nil {
+		return &RequestError{ExitVerify, fmt.Errorf("content from %s failed its checksum: %w", uri, err)}
+	}
+	if h != nil {
+		s.Verified(opts.VerifyCid, h.Sum(nil))
+		if !s.Verify.Match {
+			return &RequestError{ExitVerify,
+				fmt.Errorf("content from %s does not match CID %s", uri, opts.VerifyCid.Raw)}
+		}
+	}
+	if s.Car != nil {
+		if s.Car.Failed > 0 {
+			return &RequestError{ExitVerify,
+				fmt.Errorf("%d blocks of the CAR from %s do not match their CIDs", s.Car.Failed, uri)}
+		}
+		if s.Car.Error != nil && !s.Truncated {
+			return &RequestError{ExitVerify, fmt.Errorf("the CAR from %s is invalid: %w", uri, s.Car.Error)}
+		}
+		LogInfo("All %d blocks of the CAR were checked, %d against their CIDs", s.Car.Blocks, s.Car.Verified)
+	}
+
+	return nil
+}
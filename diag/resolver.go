@@ -0,0 +1,152 @@
+package diag
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Return a resolver that sends all DNS queries to the given server, rather
+// than the ones the system is configured with. The port defaults to 53.
+func NewDnsResolver(server string) *net.Resolver {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			d := net.Dialer{}
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// Return a DialContext function that connects to the IP address given in pins
+// for any host:port in it, rather than looking the host up. Everything else,
+// such as the Host header and SNI, still uses the original host.
+func PinnedDialContext(d *net.Dialer, pins map[string]string) func(context.Context, string, string) (net.Conn, error) {
+	if len(pins) == 0 {
+		return d.DialContext
+	}
+	return func(ctx context.Context, network string, address string) (net.Conn, error) {
+		if ip, ok := pins[strings.ToLower(address)]; ok {
+			_, port, _ := net.SplitHostPort(address)
+			address = net.JoinHostPort(ip, port)
+		}
+		return d.DialContext(ctx, network, address)
+	}
+}
+
+// Return a DialContext function that always connects over the given network,
+// tcp4 or tcp6, rather than whichever the transport asks for, as for -ipv4
+// and -ipv6. Only addresses of that family are looked up or tried.
+func NetworkDialContext(dial func(context.Context, string, string) (net.Conn, error), network string) func(context.Context, string, string) (net.Conn, error) {
+	if network == "" {
+		return dial
+	}
+	return func(ctx context.Context, _ string, address string) (net.Conn, error) {
+		return dial(ctx, network, address)
+	}
+}
+
+// Return a resolver that sends all DNS queries to a DNS-over-HTTPS server
+// (RFC 8484) at the given URL, e.g. https://cloudflare-dns.com/dns-query
+func NewDohResolver(url string) *net.Resolver {
+	// The DoH server itself is looked up with the system resolver.
+	client := &http.Client{Timeout: time.Second * 10}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			return &dohConn{ctx: ctx, url: url, client: client}, nil
+		},
+	}
+}
+
+// dohConn pretends to be a TCP connection to a DNS server for the benefit of
+// the Go resolver. Queries written to it are POSTed to a DoH server, and the
+// answers are handed back when read.
+type dohConn struct {
+	ctx      context.Context
+	url      string
+	client   *http.Client
+	deadline time.Time
+	wbuf     bytes.Buffer
+	rbuf     bytes.Buffer
+}
+
+// dohAddr is the net.Addr of a DoH server
+type dohAddr string
+
+func (a dohAddr) Network() string { return "doh" }
+func (a dohAddr) String() string  { return string(a) }
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	return c.wbuf.Write(b)
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	if c.rbuf.Len() == 0 {
+		if err := c.roundTrip(); err != nil {
+			return 0, err
+		}
+	}
+	return c.rbuf.Read(b)
+}
+
+// Send the query that has been written so far to the DoH server, and buffer
+// up the answer. As with DNS over TCP, messages in both directions are
+// prefixed with their length as two bytes.
+func (c *dohConn) roundTrip() error {
+	b := c.wbuf.Bytes()
+	if len(b) < 2 {
+		return io.EOF
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return io.ErrUnexpectedEOF
+	}
+	query := b[2 : 2+n]
+
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewReader(query))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("DoH server %s returned %s", c.url, resp.Status)
+	}
+	answer, err := io.ReadAll(io.LimitReader(resp.Body, 65535))
+	if err != nil {
+		return err
+	}
+
+	c.wbuf.Next(2 + n)
+	binary.Write(&c.rbuf, binary.BigEndian, uint16(len(answer)))
+	c.rbuf.Write(answer)
+	return nil
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return dohAddr("") }
+func (c *dohConn) RemoteAddr() net.Addr               { return dohAddr(c.url) }
+func (c *dohConn) SetDeadline(t time.Time) error      { c.deadline = t; return nil }
+func (c *dohConn) SetReadDeadline(t time.Time) error  { c.deadline = t; return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,223 @@
+package diag
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Make total requests for uri, spread across the given number of workers
+// running at the same time. Each request gets its own StatsCollector, and
+// the stats and error (if any) for each are returned in the order the
+// requests were started. Once ctx is cancelled, any requests still going are
+// stopped, and those not yet started fail straight away.
+func RunRequests(ctx context.Context, t Transport, uri string, opts Options, ipfs *IpfsUri,
+	total int, workers int) ([]*StatsCollector, []error) {
+	runs := make([]*StatsCollector, total)
+	errs := make([]error, total)
+
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if total > 1 {
+					LogInfo("Starting run %d of %d", i+1, total)
+				}
+				runs[i], errs[i] = retryRequest(ctx, t, uri, opts, ipfs)
+				if errs[i] != nil {
+					LogError("Run %d failed: %s", i+1, errs[i])
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < total; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return runs, errs
+}
+
+// Request uri every interval until ctx is cancelled, calling probed with the
+// stats and error for each request as it finishes, and then return them all.
+// If a request takes longer than the interval, the next is made as soon as
+// it's done. A request still going when ctx is cancelled is stopped and left
+// out, as it's not a failure of whatever is being watched.
+func WatchRequests(ctx context.Context, t Transport, uri string, opts Options, ipfs *IpfsUri,
+	interval time.Duration, probed func(*StatsCollector, error)) ([]*StatsCollector, []error) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	var runs []*StatsCollector
+	var errs []error
+	type result struct {
+		s   *StatsCollector
+		err error
+	}
+	for i := 1; ; i++ {
+		done := make(chan result, 1)
+		go func() {
+			s, err := retryRequest(ctx, t, uri, opts, ipfs)
+			done <- result{s, err}
+		}()
+		select {
+		case r := <-done:
+			if r.err != nil {
+				LogError("Probe %d failed: %s", i, r.err)
+			}
+			runs, errs = append(runs, r.s), append(errs, r.err)
+			probed(r.s, r.err)
+		case <-ctx.Done():
+			LogInfo("Interrupted, abandoning probe %d", i)
+			// Wait for it to stop, so that it can't log over
+			// whatever comes next
+			<-done
+			return runs, errs
+		}
+
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return runs, errs
+		}
+	}
+}
+
+// Make n requests for uri whose stats are thrown away, so that caches along
+// the way are warm before the requests we measure. Failures are logged but
+// otherwise ignored, and no more are made once ctx is cancelled.
+func WarmUp(ctx context.Context, t Transport, uri string, opts Options, ipfs *IpfsUri, n int) {
+	opts.OutFile = os.DevNull
+	opts.HeaderOut = ""
+	opts.Progress = false
+	opts.PtrResolver = nil
+	opts.DnsLinkResolver = nil
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		LogInfo("Warmup request %d of %d for %s (not counted)", i+1, n, uri)
+		if err := doRequest(ctx, t, uri, opts, &StatsCollector{Ipfs: ipfs}); err != nil {
+			LogError("Warmup request %d failed: %s", i+1, err)
+		}
+	}
+	// Leave the connection to be made from scratch, so that the first
+	// measured request is the same as it would be without a warmup
+	t.CloseIdleConnections()
+}
+
+// Make a request, retrying transient failures up to opts.Retries times with
+// the delay between attempts doubling each time. Each attempt gets its own
+// StatsCollector, and the last is returned with the earlier ones attached.
+// Cancelling ctx stops the attempt being made, or the wait for the next one.
+func retryRequest(ctx context.Context, t Transport, uri string, opts Options, ipfs *IpfsUri) (*StatsCollector, error) {
+	delay := opts.RetryDelay
+	retries := opts.Retries
+	// Sending a POST again could pin or post twice, so only methods that
+	// are safe to repeat are retried unless we're told otherwise
+	guarded := retries > 0 && !opts.ForceRetry && !idempotent(opts.Method)
+	if guarded {
+		retries = 0
+	}
+	var previous []*StatsCollector
+	for attempt := 1; ; attempt++ {
+		s := &StatsCollector{Ipfs: ipfs, Attempt: attempt}
+		err := doRequest(ctx, t, uri, opts, s)
+		// Before a status is turned into an error below
+		again := retryable(s, err)
+		if err == nil && retries > 0 && retryableStatus(s.StatusCode) && attempt > retries {
+			// When retrying, a server that never recovers is a
+			// failure rather than just a response.
+			err = &RequestError{ExitStatus,
+				fmt.Errorf("%s still returned status %d after %d attempts", uri, s.StatusCode, attempt)}
+		} else if err == nil && opts.FailOn > 0 && s.StatusCode >= opts.FailOn &&
+			(attempt > retries || !retryableStatus(s.StatusCode)) {
+			// Only once we're not going to try again
+			err = &RequestError{ExitStatus,
+				fmt.Errorf("%s returned status %d", uri, s.StatusCode)}
+		}
+		s.Error = NewErrorMessage(err)
+		logStats(s)
+		if !opts.Reuse {
+			// Drop the connection so the next request has to
+			// start from scratch.
+			t.CloseIdleConnections()
+		}
+
+		if attempt > retries || !again {
+			if guarded && again {
+				LogError("WARNING: not retrying %s, as %s isn't idempotent and may not be safe to send again (use -forceRetry to retry anyway)",
+					uri, s.Request.Method)
+			}
+			s.Retried = previous
+			if attempt > 1 {
+				result := "succeeded"
+				if err != nil {
+					result = "failed"
+				}
+				LogInfo("Made %d attempts, the last of which %s", attempt, result)
+			}
+			return s, err
+		}
+
+		reason := fmt.Sprintf("status %d", s.StatusCode)
+		if err != nil {
+			reason = err.Error()
+		}
+		LogInfo("Attempt %d failed (%s), retrying in %s", attempt, reason, delay)
+		wait := time.NewTimer(delay)
+		select {
+		case <-wait.C:
+		case <-ctx.Done():
+			// Give up with what we've got, rather than making an
+			// attempt that would be cancelled straight away
+			wait.Stop()
+			s.Retried = previous
+			if err == nil {
+				err = &RequestError{ExitInterrupted,
+					fmt.Errorf("gave up retrying %s after %d attempts: %w", uri, attempt, ctx.Err())}
+				s.Error = NewErrorMessage(err)
+			}
+			return s, err
+		}
+		previous = append(previous, s)
+		delay *= 2
+	}
+}
+
+// Gateway and proxy errors that are often down to a momentary problem
+func retryableStatus(code int) bool {
+	return code == http.StatusBadGateway || code == http.StatusServiceUnavailable ||
+		code == http.StatusGatewayTimeout
+}
+
+// Whether a request with the given method can be sent again without any
+// more effect than sending it once, as defined by RFC 9110
+func idempotent(method string) bool {
+	switch strings.ToUpper(method) {
+	case "", http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete,
+		http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// Work out whether a failed attempt is worth trying again. Connection level
+// failures are, but problems with the request or writing the output aren't
+// going to go away by themselves.
+func retryable(s *StatsCollector, err error) bool {
+	if err == nil {
+		return retryableStatus(s.StatusCode)
+	}
+	switch ExitCode(err) {
+	case ExitDns, ExitConnect, ExitTls, ExitTransfer:
+		return true
+	}
+	return false
+}
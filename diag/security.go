@@ -0,0 +1,175 @@
+package diag
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// The security headers the Security reporter looks for, in the order shown
+var securityHeaders = []string{
+	"Strict-Transport-Security",
+	"Content-Security-Policy",
+	"X-Content-Type-Options",
+	"X-Frame-Options",
+}
+
+// The max-age HSTS is usually recommended to have, of a year
+const hstsRecommendedAge = 365 * 24 * 60 * 60
+
+// SecurityReporter shows which of the headers that harden web content in a
+// browser the response had. Gateways serve whatever is in IPFS from their own
+// origin, so these matter for anything that might be opened in a browser.
+type SecurityReporter struct{}
+
+func (r SecurityReporter) Name() string {
+	return "Security"
+}
+
+func (r SecurityReporter) Title() string {
+	return "Security Headers"
+}
+
+func (r SecurityReporter) Description() string {
+	return "Shows which of the usual security headers the response had, with a note on each"
+}
+
+// Return a note on a security header, and whether it's set as it should be
+func (r SecurityReporter) note(s *StatsCollector, name string) (string, bool) {
+	v := strings.Join(s.ResponseHeaders[name], ", ")
+	https := strings.HasPrefix(strings.ToLower(s.FinalUri()), "https://")
+	switch name {
+	case "Strict-Transport-Security":
+		if v == "" && !https {
+			return "missing, though it's only honoured over https anyway", false
+		}
+		if v == "" {
+			return "missing, so browsers may be downgraded to http", false
+		}
+		return r.hsts(v, https)
+	case "Content-Security-Policy":
+		if v != "" {
+			return "limits what the content can load and run", true
+		}
+		if len(s.ResponseHeaders["Content-Security-Policy-Report-Only"]) > 0 {
+			return "missing, though there's a report-only policy that isn't enforced", false
+		}
+		return "missing, so nothing limits what the content can load and run", false
+	case "X-Content-Type-Options":
+		if strings.EqualFold(strings.TrimSpace(v), "nosniff") {
+			return "browsers won't guess at a different Content-Type", true
+		}
+		if v != "" {
+			return "WARNING: should be nosniff, which is the only value there is", false
+		}
+		return "missing, so browsers may guess at a different Content-Type and run it", false
+	case "X-Frame-Options":
+		csp := strings.ToLower(strings.Join(s.ResponseHeaders["Content-Security-Policy"], ", "))
+		switch strings.ToUpper(strings.TrimSpace(v)) {
+		case "DENY":
+			return "can't be framed at all", true
+		case "SAMEORIGIN":
+			return "can only be framed by the same origin", true
+		case "":
+			if strings.Contains(csp, "frame-ancestors") {
+				return "missing, but covered by frame-ancestors in the Content-Security-Policy", true
+			}
+			return "missing, so any site can frame the content (clickjacking)", false
+		}
+		return "WARNING: should be DENY or SAMEORIGIN", false
+	}
+	return "", v != ""
+}
+
+// Return a note on a Strict-Transport-Security header, and whether it does
+// what it should
+func (r SecurityReporter) hsts(v string, https bool) (string, bool) {
+	age := int64(-1)
+	sub, preload := false, false
+	for _, d := range strings.Split(v, ";") {
+		k, val, _ := strings.Cut(strings.TrimSpace(d), "=")
+		switch strings.ToLower(strings.TrimSpace(k)) {
+		case "max-age":
+			if n, err := strconv.ParseInt(strings.Trim(strings.TrimSpace(val), `"`), 10, 64); err == nil && n >= 0 {
+				age = n
+			}
+		case "includesubdomains":
+			sub = true
+		case "preload":
+			preload = true
+		}
+	}
+	switch {
+	case !https:
+		return "ignored, as it's only honoured over https", false
+	case age < 0:
+		return "WARNING: there's no valid max-age, so it's ignored", false
+	case age == 0:
+		return "max-age=0 tells browsers to forget the policy", false
+	}
+	note := fmt.Sprintf("enforced for %d day(s)", age/(24*60*60))
+	if sub {
+		note += ", including subdomains"
+	}
+	if preload {
+		note += ", and asks to be preloaded"
+	}
+	if age < hstsRecommendedAge {
+		note += ", which is less than the year usually recommended"
+	}
+	return note, true
+}
+
+func (r SecurityReporter) Report(s *StatsCollector) (ret string, e error) {
+	if err := checkResponse(s); err != nil {
+		return "", err
+	}
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"Header", "Value", "Note"})
+	missing := []string{}
+	for _, h := range securityHeaders {
+		v := strings.Join(s.ResponseHeaders[h], ", ")
+		if v == "" {
+			v = "n/a"
+			missing = append(missing, h)
+		}
+		note, _ := r.note(s, h)
+		t.Append([]string{h, v, note})
+	}
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+	tw.Write([]byte(fmt.Sprintf("%d of the %d security headers were present\n",
+		len(securityHeaders)-len(missing), len(securityHeaders))))
+	if len(missing) > 0 {
+		tw.Write([]byte(fmt.Sprintf("Missing: %s\n", strings.Join(missing, ", "))))
+	}
+	ret = tw.String()
+	return
+}
+
+func (r SecurityReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	if err := checkResponse(s); err != nil {
+		return nil, err
+	}
+	ret := map[string]interface{}{}
+	present := 0
+	for _, h := range securityHeaders {
+		note, ok := r.note(s, h)
+		d := map[string]interface{}{
+			"Value": nil,
+			"Ok":    ok,
+			"Note":  note,
+		}
+		if v := s.ResponseHeaders[h]; len(v) > 0 {
+			d["Value"] = strings.Join(v, ", ")
+			present++
+		}
+		ret[h] = d
+	}
+	ret["Present"] = present
+	return ret, nil
+}
@@ -0,0 +1,1103 @@
+package diag
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// StatsCollector collects pre-transfer stats and info, and also implements the
+// io.Writer interface to track the amount/rate of data written while being
+// downloaded. The latter is buffered and on the Write side of the equation,
+// but should generally still be pretty close to the rate we're downloading at.
+type StatsCollector struct {
+	// Uri is the URI that was requested, and Version that of the
+	// web3diag that requested it
+	Uri     string
+	Version string
+	// StatusCode and the protocol version are from the final response,
+	// after any redirects. Status is the code with its reason, as sent,
+	// e.g. "404 Not Found".
+	StatusCode int
+	Status     string
+	Proto      string
+	ProtoMajor int
+	ProtoMinor int
+	// Close is set if the server won't keep the connection open after the
+	// response, whether it said Connection: close or it's HTTP/1.0 without
+	// keep-alive. Go takes the close out of the response headers.
+	Close bool
+	// Attempt counts from 1 when retrying with -retries, and Retried holds
+	// the stats from any earlier attempts that failed
+	Attempt int
+	Retried []*StatsCollector
+	// Error is why the request failed, if it did
+	Error *ErrorMessage
+	// Truncated is set if the transfer was stopped early by -maxBytes, in
+	// which case TotalBytes is only a lower bound on the size of the body
+	Truncated bool
+	// TransferSkipped is set if the body wasn't read at all, with
+	// -ttfbOnly, so there are no transfer timings or byte counts
+	TransferSkipped bool
+	// StoppedDuring is the phase a request that timed out or was
+	// interrupted was in at the time, such as "TLS handshake"
+	StoppedDuring string
+	// ContentLength is the size of the body given by the server, or -1 if
+	// it wasn't given. TransferEncoding is as in the response, which for
+	// HTTP/1.1 means chunked if there's no length.
+	ContentLength    int64
+	TransferEncoding []string
+	TotalBytes       uint64
+	CurrentSecond    int64
+	CurrentSecBytes  uint64
+	// PerSecond breaks the transfer down by second of the clock, from the
+	// first byte to the end of the transfer, with an empty sample for any
+	// second in which nothing arrived
+	PerSecond []SecondSample
+	StartTime int64
+	EndTime   int64
+	// Total brackets the whole request, from just before it's made until
+	// the body has been transferred, so includes any redirects as well as
+	// the connection setup
+	Total struct {
+		StartTime int64
+		EndTime   int64
+	}
+	// Dns represents the DNS lookup(s) before connecting.
+	Dns struct {
+		StartTime int64
+		EndTime   int64
+		Host      string
+		Addrs     []net.IPAddr
+		// Pinned is the IP address given with -resolve, in which case
+		// there's no lookup at all
+		Pinned string
+		// Resolver describes what was used to do the lookup
+		Resolver string
+		// Cnames is the chain of names the host is an alias for, in
+		// order, with -cname
+		Cnames     []string
+		CnameError *ErrorMessage
+	}
+	// Tls represents the TLS work, if applicable
+	Tls struct {
+		StartTime   int64
+		EndTime     int64
+		Version     uint16
+		ServerName  string
+		CipherSuite uint16
+		// VersionName and CipherSuiteName are Version and CipherSuite
+		// as they're usually written, e.g. TLS 1.3 and
+		// TLS_AES_128_GCM_SHA256
+		VersionName     string
+		CipherSuiteName string
+		// NegotiatedProtocol is the application protocol agreed with
+		// ALPN, e.g. h2, or empty if none was
+		NegotiatedProtocol string
+		// Certificates is the chain presented by the server, leaf first
+		Certificates []CertInfo
+		// Ocsp is the stapled OCSP response, if the server sent one
+		Ocsp      *OcspInfo
+		OcspError *ErrorMessage
+		// MinVersion and MaxVersion are the versions asked for with
+		// -tlsMin and -tlsMax, or 0 if left to the defaults
+		MinVersion uint16
+		MaxVersion uint16
+		// CipherSuites are those asked for with -ciphers, if any
+		CipherSuites []uint16
+		// ClientCert is set if a client certificate was given with
+		// -clientCert. ClientCertRequested is set if the server asked
+		// for one, and ClientCertSent if we had one to give it.
+		ClientCert          bool
+		ClientCertRequested bool
+		ClientCertSent      bool
+		// Insecure is set if certificate verification was skipped with
+		// -insecure, in which case VerifyError is why it would have failed
+		Insecure    bool
+		VerifyError *ErrorMessage
+		// TODO: include parms from tls.ConnectionState here
+	}
+	// Connection is just the TCP portion of the pre-transfer work
+	Connection struct {
+		StartTime int64
+		EndTime   int64
+		Protocol  string
+		Address   string
+		Error     *ErrorMessage
+		// Family is ipv4 or ipv6, for the address actually used
+		Family string
+	}
+	// Quic describes the QUIC connection of a request made over HTTP/3,
+	// with -http3, and is nil otherwise
+	Quic *QuicInfo
+	// Proxy is the proxy the request was made through, if any, without
+	// any credentials
+	Proxy string
+	// Session covers the whole of the pre-transfer work (DNS, TCP, TLS)
+	Session struct {
+		StartTime int64
+		EndTime   int64
+		HostPort  string
+		Local     net.Addr
+		Remote    net.Addr
+		// Reused is set if the connection had been used for an earlier
+		// request, and WasIdle if it was sitting in the idle pool, for
+		// IdleTime (in ns)
+		Reused   bool
+		WasIdle  bool
+		IdleTime int64
+		// KeepAliveDisabled is set with -noKeepAlive, when every
+		// request has a connection of its own
+		KeepAliveDisabled bool
+		// Ptr holds the names from a reverse lookup of Remote, with -ptr
+		Ptr      []string
+		PtrError *ErrorMessage
+		// Tcp is the kernel's view of the connection once the transfer
+		// was done, on Linux
+		Tcp      *TcpInfo
+		TcpError *ErrorMessage
+	}
+	Request struct {
+		Method string
+		// WroteHeadersTime is when the headers had been written, and
+		// StartTime when the whole request (including any body) had
+		WroteHeadersTime int64
+		StartTime        int64
+		Error            *ErrorMessage
+		// With Expect: 100-continue, Wait100Time is when we started
+		// waiting for the server to accept the body, and Got100Time when
+		// it did
+		Wait100Time int64
+		Got100Time  int64
+	}
+	// Upload covers the request body, if one was sent
+	Upload struct {
+		StartTime int64
+		EndTime   int64
+		Bytes     uint64
+	}
+	// Stall tracks gaps in the transfer of the body. Any gap between
+	// writes longer than Threshold is recorded in Stalls.
+	Stall struct {
+		Threshold time.Duration
+		LastWrite int64
+		Longest   int64
+		Stalls    []StallInfo
+	}
+	// Compression is the Content-Encoding of the body, if any. When it was
+	// Decompressed, TotalBytes counts the decompressed body and Bytes the
+	// body as it was sent.
+	Compression struct {
+		Encoding     string
+		Decompressed bool
+		Bytes        uint64
+	}
+	// HeaderBytes is the size of the request and response headers of the
+	// final request, including the request and status lines, as they're
+	// sent over HTTP/1.1. HTTP/2 compresses headers, so sends less.
+	// Trailers is the size of any trailers after the body.
+	HeaderBytes struct {
+		Request  uint64
+		Response uint64
+		Trailers uint64
+	}
+	// Wire counts what was actually read from and written to the
+	// connection the final response came over, including headers, TLS and
+	// HTTP/2 framing, and how much of what was read was the response.
+	// Requests sharing a HTTP/2 connection at the same time are counted
+	// together.
+	Wire struct {
+		Read     uint64
+		Written  uint64
+		Response uint64
+	}
+	FirstByteTime   int64
+	RequestHeaders  map[string][]string
+	ResponseHeaders map[string][]string
+	// ResponseTrailers are any trailers sent after the body, as with
+	// chunked or HTTP/2 streaming responses that only know their status
+	// once the body is done
+	ResponseTrailers map[string][]string
+	// Ipfs is the original URI, if an ipfs:// or ipns:// one was requested
+	Ipfs *IpfsUri
+	// DnsLink records the DNSLink lookup for an ipns:// URI with a DNS
+	// name, made once the transfer is done. Records holds the value of
+	// each dnslink= record, and Path the one that counts.
+	DnsLink struct {
+		Name    string
+		Records []string
+		Path    string
+		Error   *ErrorMessage
+	}
+	// Range records what happened to a byte range request, if one was made
+	Range struct {
+		Requested    string
+		StatusCode   int
+		Honoured     bool
+		ContentRange string
+	}
+	// Resume records picking up where an earlier download left off with
+	// -resume. Offset is how much of the content was already in -outFile,
+	// and Resumed is set if the server sent the rest, in which case the
+	// byte counts and rates are of just the rest.
+	Resume struct {
+		Offset  int64
+		Resumed bool
+	}
+	// Verify records the check of the content against its CID, with
+	// -verifyCID
+	Verify struct {
+		Cid      string
+		Hash     string
+		Expected string
+		Actual   string
+		Match    bool
+	}
+	// Car records the blocks found in a CAR response, if the response
+	// was one
+	Car *CarStats
+	// Digest holds checksums of the content, and those it was expected
+	// to have if -sha256 or -md5 were given
+	Digest struct {
+		Sha256         string
+		Md5            string
+		ExpectedSha256 string
+		ExpectedMd5    string
+	}
+	// Redirects holds each redirect followed on the way to the final URL,
+	// of which there could be up to MaxRedirects. UnfollowedRedirect is
+	// where the one after that would have gone, if there was one.
+	Redirects          []RedirectHop
+	MaxRedirects       int
+	UnfollowedRedirect string
+
+	progress *progressBar
+	// redact lists headers to redact as well as sensitiveHeaders, from
+	// -redact
+	redact []string
+	// sampleStart is when the per-second sample being collected began, in
+	// Unix milliseconds
+	sampleStart int64
+	// conn is the connection being counted for Wire, and the counts it
+	// had when we got it and once the request had been written
+	conn         *countingConn
+	connRead     uint64
+	connWritten  uint64
+	connResponse uint64
+}
+
+// Phase identifies one of the timed phases of a request
+type Phase int
+
+const (
+	PhaseDns Phase = iota
+	PhaseConnect
+	PhaseTls
+	// PhaseRequest runs from getting a connection to having written the
+	// whole request, and PhaseFirstByte from then until the response
+	// starts to arrive
+	PhaseRequest
+	PhaseFirstByte
+	PhaseTransfer
+	PhaseUpload
+	// PhaseSetup is everything before the body started to arrive, and
+	// PhaseTotal is that plus the transfer
+	PhaseSetup
+	PhaseTotal
+)
+
+// The names of the phases, for saying which one a request got stuck in
+var phaseNames = map[Phase]string{
+	PhaseDns:       "DNS lookup",
+	PhaseConnect:   "connection",
+	PhaseTls:       "TLS handshake",
+	PhaseRequest:   "request",
+	PhaseFirstByte: "wait for the first byte",
+	PhaseTransfer:  "transfer",
+	PhaseUpload:    "upload",
+	PhaseSetup:     "setup",
+	PhaseTotal:     "whole request",
+}
+
+func (p Phase) String() string {
+	if name, ok := phaseNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("phase %d", int(p))
+}
+
+// UnfinishedPhase returns the phase a request that failed part way through
+// was in when it did, going by which of the timestamps it got as far as, and
+// false if it was never started.
+func (c *StatsCollector) UnfinishedPhase() (Phase, bool) {
+	switch {
+	case c.Total.StartTime == 0:
+		return 0, false
+	case c.StartTime != 0 || c.FirstByteTime != 0:
+		// The response had started to arrive
+		return PhaseTransfer, true
+	case c.Request.StartTime != 0:
+		return PhaseFirstByte, true
+	case c.Session.EndTime != 0:
+		// Still writing the request, or its body
+		return PhaseRequest, true
+	case c.Tls.StartTime != 0:
+		return PhaseTls, true
+	case c.Dns.StartTime != 0 && c.Dns.EndTime == 0:
+		return PhaseDns, true
+	}
+	// Connecting, or waiting to, which includes going through a proxy
+	return PhaseConnect, true
+}
+
+// PhaseDuration returns how long a phase took, and whether it happened at all.
+// Phases that were skipped (e.g. TLS for http://, or DNS on a reused
+// connection) leave their timestamps unset, so aren't ok.
+func (c *StatsCollector) PhaseDuration(p Phase) (time.Duration, bool) {
+	start, end, ok := c.PhaseTimes(p)
+	return time.Duration(end - start), ok
+}
+
+// PhaseTimes returns the start and end of a phase in ns since the epoch, and
+// whether it happened at all.
+func (c *StatsCollector) PhaseTimes(p Phase) (int64, int64, bool) {
+	var end, start int64
+	switch p {
+	case PhaseDns:
+		end, start = c.Dns.EndTime, c.Dns.StartTime
+	case PhaseConnect:
+		end, start = c.Connection.EndTime, c.Connection.StartTime
+	case PhaseTls:
+		end, start = c.Tls.EndTime, c.Tls.StartTime
+	case PhaseRequest:
+		end, start = c.Request.StartTime, c.Session.EndTime
+	case PhaseFirstByte:
+		end, start = c.FirstByteTime, c.Request.StartTime
+	case PhaseTransfer:
+		end, start = c.EndTime, c.StartTime
+	case PhaseUpload:
+		end, start = c.Upload.EndTime, c.Upload.StartTime
+	case PhaseSetup:
+		end, start = c.StartTime, c.Total.StartTime
+	case PhaseTotal:
+		end, start = c.Total.EndTime, c.Total.StartTime
+	}
+	if end == 0 || start == 0 {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// UnmeasuredPhases returns the phases there's no timing for because of how
+// the request was made, rather than because they didn't happen. Over HTTP/3
+// the TLS handshake is part of the QUIC one, so is timed as the connection.
+func (c *StatsCollector) UnmeasuredPhases() []Phase {
+	if c.Quic != nil {
+		return []Phase{PhaseTls}
+	}
+	return nil
+}
+
+// StallInfo is a gap in the transfer, after Bytes had been received
+type StallInfo struct {
+	StartTime int64
+	EndTime   int64
+	Bytes     uint64
+}
+
+// Return just the number of a TLS version, e.g. 1.3, or an empty string if
+// it's not one we know
+func TlsVersionNumber(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "1.0"
+	case tls.VersionTLS11:
+		return "1.1"
+	case tls.VersionTLS12:
+		return "1.2"
+	case tls.VersionTLS13:
+		return "1.3"
+	}
+	return ""
+}
+
+// Return a TLS version as it's usually written, e.g. TLS 1.3
+func tlsVersionName(v uint16) string {
+	if n := TlsVersionNumber(v); n != "" {
+		return "TLS " + n
+	}
+	return fmt.Sprintf("TLS version 0x%04x", v)
+}
+
+// SecondSample is the Bytes received over Millis from UnixMilli. All but the
+// first and last cover a whole second; those may be partial, as the transfer
+// rarely starts or ends on the second.
+type SecondSample struct {
+	UnixMilli int64
+	Millis    int64
+	Bytes     uint64
+}
+
+// CertInfo holds the interesting parts of a certificate presented by a server
+type CertInfo struct {
+	Subject     string
+	Issuer      string
+	NotBefore   time.Time
+	NotAfter    time.Time
+	DnsNames    []string
+	IpAddresses []string
+}
+
+func NewCertInfo(c *x509.Certificate) CertInfo {
+	ips := make([]string, 0, len(c.IPAddresses))
+	for _, ip := range c.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	return CertInfo{
+		Subject:     c.Subject.String(),
+		Issuer:      c.Issuer.String(),
+		NotBefore:   c.NotBefore,
+		NotAfter:    c.NotAfter,
+		DnsNames:    c.DNSNames,
+		IpAddresses: ips,
+	}
+}
+
+// RedirectHop represents a single redirect response that was followed. The
+// start and end times cover the request that produced the redirect.
+type RedirectHop struct {
+	From       string
+	To         string
+	StatusCode int
+	Location   string
+	StartTime  int64
+	EndTime    int64
+}
+
+// Downgrade returns true if the redirect took us from https to http
+func (h RedirectHop) Downgrade() bool {
+	return strings.HasPrefix(strings.ToLower(h.From), "https://") &&
+		strings.HasPrefix(strings.ToLower(h.To), "http://")
+}
+
+// Headers that carry credentials, which are redacted in the stats so that
+// they can be shared safely. Any given with -redact are added to these.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// Return a copy of h with the values of any sensitive headers, or of those in
+// extra, redacted. Authorization headers keep their scheme, e.g. Bearer ****.
+func redactHeaders(h http.Header, extra []string) http.Header {
+	h = h.Clone()
+	for _, k := range append(append([]string{}, sensitiveHeaders...), extra...) {
+		k = http.CanonicalHeaderKey(k)
+		for i, v := range h[k] {
+			scheme, _, ok := strings.Cut(v, " ")
+			if ok && strings.HasSuffix(k, "Authorization") {
+				h[k][i] = scheme + " ****"
+			} else {
+				h[k][i] = "****"
+			}
+		}
+	}
+	return h
+}
+
+func (c *StatsCollector) SetRequestHeaders(h http.Header) {
+	logVerbose("Request Headers:")
+	h = redactHeaders(h, c.redact)
+	c.RequestHeaders = h
+	for k := range h {
+		logVerbose("  %s: %s", k, h[k])
+	}
+}
+
+func (c *StatsCollector) SetResponseHeaders(h http.Header) {
+	logVerbose("Response Headers:")
+	h = redactHeaders(h, c.redact)
+	c.ResponseHeaders = h
+	for k := range h {
+		logVerbose("  %s: %s", k, h[k])
+	}
+}
+
+// Record the trailers once the body has been read, which is when they're
+// filled in. Go has an entry for each name the Trailer header announced, so
+// any that never came are left out.
+func (c *StatsCollector) SetResponseTrailers(h http.Header) {
+	got := http.Header{}
+	for k, v := range h {
+		if len(v) > 0 {
+			got[k] = v
+		}
+	}
+	if len(got) == 0 {
+		return
+	}
+	logVerbose("Response Trailers:")
+	got = redactHeaders(got, c.redact)
+	c.ResponseTrailers = got
+	for k := range got {
+		logVerbose("  %s: %s", k, got[k])
+	}
+}
+
+func (c *StatsCollector) Write(p []byte) (int, error) {
+	now := time.Now()
+	c.gap(now.UnixNano())
+	n := len(p)
+	c.TotalBytes += uint64(n)
+
+	// Crude breakdown per second
+	curr := now.Unix()
+	if c.sampleStart == 0 {
+		// Nothing said the first byte had arrived, so the first sample
+		// starts here
+		c.CurrentSecond, c.sampleStart = curr, now.UnixMilli()
+	} else if curr > c.CurrentSecond {
+		if c.progress != nil {
+			// Get the progress bar out of the way of the log
+			c.progress.clear()
+		}
+		logVerbose("%d transferred, %d bytes/s", c.TotalBytes, c.CurrentSecBytes)
+		c.addSample(now, false)
+	}
+	c.CurrentSecBytes += uint64(n)
+
+	if c.progress != nil {
+		c.progress.draw(c, time.Now(), false)
+	}
+
+	return n, nil
+}
+
+// Note the gap since the last write (or the start of the transfer), and record
+// it as a stall if it was too long.
+func (c *StatsCollector) gap(now int64) {
+	last := c.Stall.LastWrite
+	if last == 0 {
+		last = c.StartTime
+	}
+	c.Stall.LastWrite = now
+	if last == 0 {
+		return
+	}
+	d := now - last
+	if d > c.Stall.Longest {
+		c.Stall.Longest = d
+	}
+	if c.Stall.Threshold > 0 && d > int64(c.Stall.Threshold) {
+		if c.progress != nil {
+			c.progress.clear()
+		}
+		LogInfo("Transfer stalled for %s after %d bytes", time.Duration(d), c.TotalBytes)
+		c.Stall.Stalls = append(c.Stall.Stalls, StallInfo{last, now, c.TotalBytes})
+	}
+}
+
+// ShowProgress has a progress bar drawn to out as the body is transferred
+func (c *StatsCollector) ShowProgress(out io.Writer) {
+	c.progress = &progressBar{out: out}
+}
+
+func (c *StatsCollector) StartDns(host string) {
+	now := time.Now()
+	c.Dns.StartTime = now.UnixNano()
+	c.Dns.Host = host
+	logVerbose("DNS Request for '%s' starting (%s resolver)", host, c.Dns.Resolver)
+}
+
+func (c *StatsCollector) EndDns(addrs []net.IPAddr) {
+	now := time.Now()
+	c.Dns.EndTime = now.UnixNano()
+	c.Dns.Addrs = addrs
+	logVerbose("DNS Request for '%s' returned: %s", c.Dns.Host, addrs)
+}
+
+func (c *StatsCollector) WroteHeaders() {
+	now := time.Now()
+	c.Request.WroteHeadersTime = now.UnixNano()
+	logVerbose("HTTP %s request headers written", c.Request.Method)
+}
+
+func (c *StatsCollector) Wait100Continue() {
+	now := time.Now()
+	c.Request.Wait100Time = now.UnixNano()
+	logVerbose("Waiting for 100 Continue before sending the body")
+}
+
+func (c *StatsCollector) Got100Continue() {
+	now := time.Now()
+	c.Request.Got100Time = now.UnixNano()
+	wait, _ := phaseSeconds(c.Request.Got100Time, c.Request.Wait100Time)
+	logVerbose("Got 100 Continue after %f seconds", wait)
+}
+
+func (c *StatsCollector) WroteRequest(e error) {
+	now := time.Now()
+	c.Request.StartTime = now.UnixNano()
+	c.Request.Error = NewErrorMessage(e)
+	if c.conn != nil {
+		c.connResponse, _ = c.conn.counts()
+	}
+	if c.Upload.Bytes > 0 {
+		logVerbose("HTTP %s Request made with %d byte body", c.Request.Method, c.Upload.Bytes)
+	} else {
+		logVerbose("HTTP %s Request made", c.Request.Method)
+	}
+}
+
+// RestartUpload is called when the request body is about to be sent again,
+// after a 307 or 308 redirect or when a connection was lost before it was
+// sent, so that it's not counted twice
+func (c *StatsCollector) RestartUpload() {
+	c.Upload.StartTime, c.Upload.EndTime, c.Upload.Bytes = 0, 0, 0
+}
+
+// Uploaded is called as each chunk of the request body is sent
+func (c *StatsCollector) Uploaded(n int) {
+	now := time.Now()
+	if c.Upload.StartTime == 0 {
+		c.Upload.StartTime = now.UnixNano()
+	}
+	c.Upload.EndTime = now.UnixNano()
+	c.Upload.Bytes += uint64(n)
+}
+
+func (c *StatsCollector) StartConnect(network string, addr string) {
+	now := time.Now()
+	c.Connection.StartTime = now.UnixNano()
+	// Not done until one of the attempts at this connection succeeds
+	c.Connection.EndTime = 0
+	c.Connection.Protocol = network
+	c.Connection.Address = addr
+	logVerbose("Initiating %s connection to %s", strings.ToUpper(network), addr)
+}
+
+func (c *StatsCollector) EndConnect(network string, addr string, err error) {
+	if err != nil && c.Connection.EndTime != 0 && c.Connection.Error == nil {
+		// With both IPv4 and IPv6 addresses, the attempt that lost the
+		// race is given up on once the other has connected
+		logVerbose("Connection to %s abandoned: %s", addr, err)
+		return
+	}
+	now := time.Now()
+	c.Connection.EndTime = now.UnixNano()
+	c.Connection.Protocol = network
+	c.Connection.Address = addr
+	c.Connection.Error = NewErrorMessage(err)
+	if err == nil {
+		logVerbose("Connection to %s succeeded", addr)
+	} else {
+		LogInfo("Connection to %s failed: %s", addr, err)
+	}
+}
+
+// Return ipv4 or ipv6 for an address
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+func (c *StatsCollector) PinnedAddress(ip string) {
+	c.Dns.Pinned = ip
+	LogInfo("Connecting to %s for %s, as given with -resolve", ip, c.Session.HostPort)
+}
+
+func (c *StatsCollector) SetPtr(names []string, err error) {
+	c.Session.Ptr = names
+	c.Session.PtrError = NewErrorMessage(err)
+	if err != nil {
+		LogInfo("Reverse lookup of %s failed: %s", c.Session.Remote, err)
+	} else {
+		logVerbose("Reverse lookup of %s gave %s", c.Session.Remote, strings.Join(names, ", "))
+	}
+}
+
+func (c *StatsCollector) SetCnames(names []string, err error) {
+	c.Dns.Cnames = names
+	c.Dns.CnameError = NewErrorMessage(err)
+	if err != nil {
+		LogInfo("CNAME lookup of %s failed: %s", c.Dns.Host, err)
+	} else {
+		logVerbose("CNAME lookup of %s gave %s", c.Dns.Host, strings.Join(names, " -> "))
+	}
+}
+
+func (c *StatsCollector) SetDnsLink(name string, records []string, err error) {
+	c.DnsLink.Name = name
+	c.DnsLink.Records = records
+	c.DnsLink.Error = NewErrorMessage(err)
+	if err != nil {
+		LogInfo("DNSLink lookup of %s failed: %s", name, err)
+		return
+	}
+	c.DnsLink.Path = records[0]
+	LogInfo("DNSLink for %s points at %s", name, c.DnsLink.Path)
+}
+
+func (c *StatsCollector) SetProxy(u *url.URL) {
+	c.Proxy = u.Redacted()
+	LogInfo("Using proxy %s", c.Proxy)
+}
+
+func (c *StatsCollector) StartSession(hostPort string) {
+	now := time.Now()
+	c.Session.StartTime = now.UnixNano()
+	c.Session.HostPort = hostPort
+	logVerbose("Initiating session to %s", hostPort)
+}
+
+func (c *StatsCollector) GotSession(info httptrace.GotConnInfo) {
+	now := time.Now()
+	c.Session.EndTime = now.UnixNano()
+	c.Session.Local = info.Conn.LocalAddr()
+	c.Session.Remote = info.Conn.RemoteAddr()
+	if a, ok := c.Session.Remote.(*net.TCPAddr); ok {
+		c.Connection.Family = ipFamily(a.IP)
+	}
+	c.Session.Reused = info.Reused
+	c.Session.WasIdle = info.WasIdle
+	c.Session.IdleTime = info.IdleTime.Nanoseconds()
+	c.conn = findCountingConn(info.Conn)
+	c.connRead, c.connWritten = 0, 0
+	if c.conn != nil && info.Reused {
+		// Only count what's been sent since we got it
+		c.connRead, c.connWritten = c.conn.counts()
+	}
+	logVerbose("Initiated session to %s: %s => %s (reused: %t, idle for %s)",
+		c.Session.HostPort,
+		c.Session.Local, c.Session.Remote, info.Reused, info.IdleTime)
+}
+
+func (c *StatsCollector) AddRedirect(from string, to string, code int, location string) {
+	now := time.Now()
+	// The first hop started when we first went looking for a connection,
+	// and each subsequent one started when the previous one finished.
+	start := c.Session.StartTime
+	if len(c.Redirects) > 0 {
+		start = c.Redirects[len(c.Redirects)-1].EndTime
+	}
+	c.Redirects = append(c.Redirects, RedirectHop{
+		From:       from,
+		To:         to,
+		StatusCode: code,
+		Location:   location,
+		StartTime:  start,
+		EndTime:    now.UnixNano(),
+	})
+	LogInfo("Redirected (%d) from %s to %s", code, from, to)
+}
+
+// Return the URL the response finally came from, after any redirects
+func (c *StatsCollector) FinalUri() string {
+	if len(c.Redirects) > 0 {
+		return c.Redirects[len(c.Redirects)-1].To
+	}
+	return c.Uri
+}
+
+func (c *StatsCollector) SetRangeResponse(code int, contentRange string) {
+	c.Range.StatusCode = code
+	c.Range.Honoured = code == http.StatusPartialContent
+	c.Range.ContentRange = contentRange
+	if c.Range.Honoured {
+		LogInfo("Server returned range %s", contentRange)
+	} else {
+		LogInfo("Server ignored range request (status %d)", code)
+	}
+}
+
+// Record the result of checking the content against its CID
+func (c *StatsCollector) Verified(cid *Cid, digest []byte) {
+	c.Verify.Cid = cid.Raw
+	c.Verify.Hash = cid.HashName()
+	c.Verify.Expected = hex.EncodeToString(cid.Digest)
+	c.Verify.Actual = hex.EncodeToString(digest)
+	c.Verify.Match = bytes.Equal(cid.Digest, digest)
+	if c.Verify.Match {
+		LogInfo("Content matches CID %s", cid.Raw)
+	} else {
+		LogError("Content does not match CID %s: expected %s %s but got %s",
+			cid.Raw, c.Verify.Hash, c.Verify.Expected, c.Verify.Actual)
+	}
+}
+
+// Record the checksums of the content, and check them against any that were
+// expected. An error describes the first mismatch.
+func (c *StatsCollector) SetDigests(sha []byte, md []byte) error {
+	c.Digest.Sha256 = hex.EncodeToString(sha)
+	c.Digest.Md5 = hex.EncodeToString(md)
+	LogInfo("Content SHA-256 %s, MD5 %s", c.Digest.Sha256, c.Digest.Md5)
+	if c.Digest.ExpectedSha256 != "" && c.Digest.ExpectedSha256 != c.Digest.Sha256 {
+		return fmt.Errorf("SHA-256 of the content is %s, expected %s",
+			c.Digest.Sha256, c.Digest.ExpectedSha256)
+	}
+	if c.Digest.ExpectedMd5 != "" && c.Digest.ExpectedMd5 != c.Digest.Md5 {
+		return fmt.Errorf("MD5 of the content is %s, expected %s",
+			c.Digest.Md5, c.Digest.ExpectedMd5)
+	}
+	return nil
+}
+
+// Add the per-second sample being collected, which ends with its second or at
+// now if that's sooner, along with an empty sample for each whole second since
+// in which nothing arrived. A new sample is then started for the second now is
+// in, unless the transfer is done, in which case any of that second up to now
+// is added as a final, empty sample.
+func (c *StatsCollector) addSample(now time.Time, done bool) {
+	ms := now.UnixMilli()
+	end := (c.CurrentSecond + 1) * 1000
+	if ms < end {
+		end = ms
+	}
+	c.PerSecond = append(c.PerSecond, SecondSample{c.sampleStart, end - c.sampleStart, c.CurrentSecBytes})
+	for sec := c.CurrentSecond + 1; sec < now.Unix(); sec++ {
+		c.PerSecond = append(c.PerSecond, SecondSample{sec * 1000, 1000, 0})
+	}
+	c.CurrentSecond, c.CurrentSecBytes = now.Unix(), 0
+	c.sampleStart = now.Unix() * 1000
+	if done && ms > end && ms > c.sampleStart {
+		c.PerSecond = append(c.PerSecond, SecondSample{c.sampleStart, ms - c.sampleStart, 0})
+	}
+}
+
+func (c *StatsCollector) FirstByteReceived() {
+	now := time.Now()
+	c.FirstByteTime = now.UnixNano()
+	c.CurrentSecond = now.Unix()
+	c.sampleStart = now.UnixMilli()
+
+	logVerbose("Received first byte")
+}
+
+func (c *StatsCollector) StartTls() {
+	now := time.Now()
+	c.Tls.StartTime = now.UnixNano()
+	logVerbose("Initiating TLS handshake")
+}
+
+func (c *StatsCollector) EndTls(t tls.ConnectionState) {
+	now := time.Now()
+	c.Tls.EndTime = now.UnixNano()
+	logVerbose("Initiated TLS handshake")
+	c.setTlsState(t)
+}
+
+// Record what was agreed in a TLS handshake
+func (c *StatsCollector) setTlsState(t tls.ConnectionState) {
+	c.Tls.Version = t.Version
+	c.Tls.CipherSuite = t.CipherSuite
+	c.Tls.VersionName = tlsVersionName(t.Version)
+	c.Tls.CipherSuiteName = tls.CipherSuiteName(t.CipherSuite)
+	c.Tls.ServerName = t.ServerName
+	c.Tls.NegotiatedProtocol = t.NegotiatedProtocol
+	c.Tls.Certificates = make([]CertInfo, 0, len(t.PeerCertificates))
+	for _, cert := range t.PeerCertificates {
+		c.Tls.Certificates = append(c.Tls.Certificates, NewCertInfo(cert))
+	}
+	if len(t.OCSPResponse) > 0 {
+		ocsp, err := ParseOcspResponse(t.OCSPResponse)
+		c.Tls.Ocsp = ocsp
+		c.Tls.OcspError = NewErrorMessage(err)
+		if err == nil {
+			logVerbose("Stapled OCSP response says certificate is %s", ocsp.Status)
+		} else {
+			LogInfo("Unable to parse stapled OCSP response: %s", err)
+		}
+	}
+}
+
+// ClientCertRequested records that the server asked for a client certificate
+// during the handshake, and whether we sent one
+func (c *StatsCollector) ClientCertRequested(sent bool) {
+	c.Tls.ClientCertRequested = true
+	c.Tls.ClientCertSent = sent
+	logVerbose("Server asked for a client certificate")
+}
+
+// Verify the certificate chain from a handshake where verification was
+// skipped, so that we can say whether it would have failed.
+func (c *StatsCollector) CheckVerification(t tls.ConnectionState) {
+	c.Tls.Insecure = true
+	if len(t.PeerCertificates) == 0 {
+		return
+	}
+	host, _, err := net.SplitHostPort(c.Session.HostPort)
+	if err != nil {
+		host = c.Session.HostPort
+	}
+	opts := x509.VerifyOptions{
+		DNSName:       host,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range t.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err = t.PeerCertificates[0].Verify(opts)
+	c.Tls.VerifyError = NewErrorMessage(err)
+	if err != nil {
+		LogInfo("Certificate verification was skipped, but would have failed: %s", err)
+	}
+}
+
+// Begin is called just before the request is made, and Finish once it's
+// complete, to time the whole thing end to end.
+func (c *StatsCollector) Begin() {
+	now := time.Now()
+	c.Total.StartTime = now.UnixNano()
+}
+
+func (c *StatsCollector) Finish() {
+	now := time.Now()
+	c.Total.EndTime = now.UnixNano()
+	if c.conn != nil {
+		info, err := c.conn.tcpInfo()
+		c.Session.Tcp, c.Session.TcpError = info, NewErrorMessage(err)
+	}
+}
+
+func (c *StatsCollector) Start() {
+	now := time.Now()
+	c.StartTime = now.UnixNano()
+}
+
+func (c *StatsCollector) Stop() {
+	now := time.Now()
+	c.EndTime = now.UnixNano()
+	// The body may have stalled after the last write, too
+	c.gap(c.EndTime)
+	if c.sampleStart != 0 {
+		c.addSample(now, true)
+	}
+	if c.conn != nil {
+		read, written := c.conn.counts()
+		c.Wire.Read, c.Wire.Written = read-c.connRead, written-c.connWritten
+		c.Wire.Response = read - c.connResponse
+	}
+	if c.progress != nil {
+		c.progress.finish(c, now)
+	}
+}
+
+func (c *StatsCollector) DurationNS() int64 {
+	return c.EndTime - c.StartTime
+}
+
+// TotalDurationNS is the time from starting the request to having the whole
+// body, rather than just the transfer as with DurationNS
+func (c *StatsCollector) TotalDurationNS() int64 {
+	return c.Total.EndTime - c.Total.StartTime
+}
+
+func (c *StatsCollector) TotalBytesTransferred() uint64 {
+	return c.TotalBytes
+}
+
+// EncodedBytes is the size of the body as sent, which is less than
+// TotalBytesTransferred if it was compressed
+func (c *StatsCollector) EncodedBytes() uint64 {
+	if c.Compression.Decompressed {
+		return c.Compression.Bytes
+	}
+	return c.TotalBytes
+}
+
+// The ways the end of a response body can be found, as returned by Framing
+const (
+	framingChunked = "chunked"
+	framingLength  = "content-length"
+	framingStream  = "stream"
+	framingClose   = "close"
+)
+
+// Framing says how the end of the body was to be found: chunked transfer
+// encoding, a Content-Length given up front, the end of a HTTP/2 or HTTP/3
+// stream, or with neither, reading until the server closes the connection.
+// Only with a Content-Length is the size known before the transfer is done.
+func (c *StatsCollector) Framing() string {
+	for _, te := range c.TransferEncoding {
+		if te == "chunked" {
+			return framingChunked
+		}
+	}
+	switch {
+	case c.ContentLength >= 0:
+		return framingLength
+	case c.ProtoMajor >= 2:
+		return framingStream
+	}
+	return framingClose
+}
+
+// DecodedBytes is the size of the body once decompressed, as saved
+func (c *StatsCollector) DecodedBytes() uint64 {
+	return c.TotalBytes
+}
+
+// WireBytes is everything read from the connection for the request,
+// including the response headers and any TLS or HTTP/2 overhead
+func (c *StatsCollector) WireBytes() uint64 {
+	return c.Wire.Read
+}
+
+// WireKBPerSecond returns the rate the response was read from the connection
+// in kB/s, from its first byte to its last. Unlike KBPerSecond, this is what
+// the network saw.
+func (c *StatsCollector) WireKBPerSecond() float64 {
+	d := c.EndTime - c.FirstByteTime
+	if c.FirstByteTime == 0 || d <= 0 {
+		return 0
+	}
+	return float64(c.Wire.Response) / float64(d) * float64(1000000000) / float64(1024)
+}
+
+// EndToEndKBPerSecond returns the rate the body came at in kB/s counting
+// everything from starting the request, so including the DNS lookup,
+// connection and TLS handshake and waiting for the first byte
+func (c *StatsCollector) EndToEndKBPerSecond() float64 {
+	d := c.TotalDurationNS()
+	if c.Total.EndTime == 0 || d <= 0 {
+		return 0
+	}
+	return float64(c.TotalBytes) / float64(d) * float64(1000000000) / float64(1024)
+}
+
+// SteadyKBPerSecond returns the rate the body came at in kB/s once the
+// response had started to arrive, from its first byte to its last, which
+// leaves out the latency of setting up the request
+func (c *StatsCollector) SteadyKBPerSecond() float64 {
+	d := c.EndTime - c.FirstByteTime
+	if c.FirstByteTime == 0 || c.EndTime == 0 || d <= 0 {
+		return 0
+	}
+	return float64(c.TotalBytes) / float64(d) * float64(1000000000) / float64(1024)
+}
+
+// UploadKBPerSecond returns the average transfer rate of the request body in
+// kB/s
+func (c *StatsCollector) UploadKBPerSecond() float64 {
+	d := c.Upload.EndTime - c.Upload.StartTime
+	if d <= 0 {
+		return 0
+	}
+	return float64(c.Upload.Bytes) / float64(d) * float64(1000000000) / float64(1024)
+}
+
+// KBPerSecond returns the average transfer rate of the body in kB/s
+func (c *StatsCollector) KBPerSecond() float64 {
+	if c.DurationNS() <= 0 {
+		return 0
+	}
+	return float64(c.TotalBytes) / float64(c.DurationNS()) * float64(1000000000) / float64(1024)
+}
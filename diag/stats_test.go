@@ -0,0 +1,145 @@
+package diag
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// A request with every phase recorded, each a different length so that a
+// mix-up between them shows
+func phaseStats() *StatsCollector {
+	const base = int64(1700000000) * int64(time.Second)
+	ms := func(n int64) int64 {
+		return base + n*int64(time.Millisecond)
+	}
+	s := &StatsCollector{}
+	s.Total.StartTime = ms(0)
+	s.Dns.StartTime, s.Dns.EndTime = ms(1), ms(11)
+	s.Connection.StartTime, s.Connection.EndTime = ms(12), ms(32)
+	s.Tls.StartTime, s.Tls.EndTime = ms(33), ms(63)
+	s.Session.EndTime = ms(64)
+	s.Request.StartTime = ms(69)
+	s.FirstByteTime = ms(119)
+	s.StartTime, s.EndTime = ms(120), ms(220)
+	s.Total.EndTime = ms(221)
+	return s
+}
+
+func TestPhaseDuration(t *testing.T) {
+	tests := []struct {
+		phase Phase
+		want  time.Duration
+	}{
+		{PhaseDns, 10 * time.Millisecond},
+		{PhaseConnect, 20 * time.Millisecond},
+		{PhaseTls, 30 * time.Millisecond},
+		{PhaseRequest, 5 * time.Millisecond},
+		{PhaseFirstByte, 50 * time.Millisecond},
+		{PhaseTransfer, 100 * time.Millisecond},
+		{PhaseSetup, 120 * time.Millisecond},
+		{PhaseTotal, 221 * time.Millisecond},
+	}
+	s := phaseStats()
+	for _, tt := range tests {
+		got, ok := s.PhaseDuration(tt.phase)
+		if !ok {
+			t.Errorf("%s: not ok", tt.phase)
+		} else if got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.phase, got, tt.want)
+		}
+	}
+}
+
+func TestPhaseDurationUnset(t *testing.T) {
+	tests := []struct {
+		name  string
+		phase Phase
+		unset func(s *StatsCollector)
+	}{
+		{"reused connection", PhaseDns, func(s *StatsCollector) { s.Dns.StartTime, s.Dns.EndTime = 0, 0 }},
+		{"lookup never finished", PhaseDns, func(s *StatsCollector) { s.Dns.EndTime = 0 }},
+		{"connection never started", PhaseConnect, func(s *StatsCollector) { s.Connection.StartTime = 0 }},
+		{"http://", PhaseTls, func(s *StatsCollector) { s.Tls.StartTime, s.Tls.EndTime = 0, 0 }},
+		{"no connection", PhaseRequest, func(s *StatsCollector) { s.Session.EndTime = 0 }},
+		{"request never written", PhaseFirstByte, func(s *StatsCollector) { s.Request.StartTime = 0 }},
+		{"no response", PhaseFirstByte, func(s *StatsCollector) { s.FirstByteTime = 0 }},
+		{"no upload", PhaseUpload, func(s *StatsCollector) {}},
+	}
+	for _, tt := range tests {
+		s := phaseStats()
+		tt.unset(s)
+		if got, ok := s.PhaseDuration(tt.phase); ok || got != 0 {
+			t.Errorf("%s: %s was %s, ok %t, want 0 and not ok", tt.name, tt.phase, got, ok)
+		}
+	}
+}
+
+// A reader that pauses before each chunk after the first, so that a copy
+// spans more than one second
+type pausingReader struct {
+	chunks [][]byte
+	pause  time.Duration
+	next   int
+	rest   []byte
+}
+
+func (r *pausingReader) Read(p []byte) (int, error) {
+	if len(r.rest) == 0 {
+		if r.next == len(r.chunks) {
+			return 0, io.EOF
+		}
+		if r.next > 0 {
+			time.Sleep(r.pause)
+		}
+		r.rest = r.chunks[r.next]
+		r.next++
+	}
+	n := copy(p, r.rest)
+	r.rest = r.rest[n:]
+	return n, nil
+}
+
+func TestPerSecondBytes(t *testing.T) {
+	chunks := [][]byte{}
+	total := 0
+	for _, n := range []int{1, 4096, 100000, 7, 65536} {
+		chunks = append(chunks, bytes.Repeat([]byte{'x'}, n))
+		total += n
+	}
+	tests := []struct {
+		name      string
+		pause     time.Duration
+		firstByte bool
+	}{
+		{"at once", 0, true},
+		{"without the first byte noted", 0, false},
+		{"across seconds", 400 * time.Millisecond, true},
+	}
+	for _, tt := range tests {
+		s := &StatsCollector{}
+		s.Start()
+		if tt.firstByte {
+			s.FirstByteReceived()
+		}
+		if _, err := io.Copy(s, &pausingReader{chunks: chunks, pause: tt.pause}); err != nil {
+			t.Fatal(err)
+		}
+		s.Stop()
+
+		sum := uint64(0)
+		for _, p := range s.PerSecond {
+			sum += p.Bytes
+			if p.Millis < 0 {
+				t.Errorf("%s: a sample of %d ms", tt.name, p.Millis)
+			}
+		}
+		if s.TotalBytes != uint64(total) {
+			t.Errorf("%s: TotalBytes is %d, want %d", tt.name, s.TotalBytes, total)
+		}
+		if sum != s.TotalBytes {
+			t.Errorf("%s: the per-second samples add up to %d, but TotalBytes is %d", tt.name, sum, s.TotalBytes)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package diag
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// The slow start threshold before there's been any loss, which means there
+// isn't one yet
+const tcpInfiniteSsThresh = 0x7fffffff
+
+// TcpInfo is what the kernel knows about a TCP connection, from TCP_INFO on
+// Linux. Times are in ns. Most of it is about what we sent, which for a
+// download is little more than the request: Rtt is measured from the
+// acknowledgements we get, and Retransmits and Lost count the segments we had
+// to send again. RcvRtt is the kernel's estimate while receiving, which is
+// the better guide for a download.
+type TcpInfo struct {
+	Rtt         int64
+	RttVar      int64
+	RcvRtt      int64
+	Rto         int64
+	Mss         uint32
+	Pmtu        uint32
+	Cwnd        uint32
+	SsThresh    uint32
+	Retransmits uint32
+	Lost        uint32
+	Reordering  uint32
+	// CaState is the congestion avoidance state, which is "open" unless
+	// there's been loss or reordering
+	CaState string
+}
+
+// TcpReporter shows the kernel's view of the TCP connection, which helps tell
+// a slow transfer down to a lossy network apart from a server sending slowly
+type TcpReporter struct{}
+
+func (r TcpReporter) Name() string {
+	return "Tcp"
+}
+
+func (r TcpReporter) Title() string {
+	return "TCP Connection Info"
+}
+
+func (r TcpReporter) Description() string {
+	return "Shows the round trip time, retransmits and congestion window of the TCP connection, on Linux"
+}
+
+func (r TcpReporter) check(s *StatsCollector) error {
+	if s.Session.TcpError != nil {
+		return s.Session.TcpError
+	}
+	if s.Session.Tcp == nil {
+		return errors.New("No TCP connection info was captured")
+	}
+	return nil
+}
+
+// Format a time in ns, or n/a if there's no estimate yet
+func msOrNa(ns int64) string {
+	if ns == 0 {
+		return "n/a"
+	}
+	if Units == UnitsRaw {
+		return fmt.Sprintf("%.3f", float64(ns)/float64(time.Millisecond))
+	}
+	return fmtDuration(time.Duration(ns))
+}
+
+func (r TcpReporter) Report(s *StatsCollector) (ret string, e error) {
+	if err := r.check(s); err != nil {
+		return "", err
+	}
+	i := s.Session.Tcp
+
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{withUnit("RTT", "ms"), withUnit("RTT Var", "ms"), withUnit("Receive RTT", "ms"), "MSS", "PMTU", "Cwnd", "Ssthresh", "Retransmits", "Lost", "State"})
+	ssthresh := "n/a"
+	if i.SsThresh < tcpInfiniteSsThresh {
+		ssthresh = fmt.Sprintf("%d", i.SsThresh)
+	}
+	t.Append([]string{
+		msOrNa(i.Rtt),
+		msOrNa(i.RttVar),
+		msOrNa(i.RcvRtt),
+		fmt.Sprintf("%d", i.Mss),
+		fmt.Sprintf("%d", i.Pmtu),
+		fmt.Sprintf("%d", i.Cwnd),
+		ssthresh,
+		fmt.Sprintf("%d", i.Retransmits),
+		fmt.Sprintf("%d", i.Lost),
+		i.CaState,
+	})
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+
+	if i.Retransmits > 0 {
+		tw.Write([]byte(fmt.Sprintf("WARNING: %d segment(s) had to be sent again, so packets were lost between here and the server\n", i.Retransmits)))
+	}
+	if i.CaState != "open" {
+		tw.Write([]byte(fmt.Sprintf("The connection was in the %s congestion state, after loss or reordering\n", i.CaState)))
+	}
+	if s.Session.Reused {
+		tw.Write([]byte("The connection was reused, so the counts cover everything since it was made\n"))
+	}
+	ret = tw.String()
+	return
+}
+
+func (r TcpReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	if err := r.check(s); err != nil {
+		return nil, err
+	}
+	i := s.Session.Tcp
+	ret := map[string]interface{}{
+		"Rtt":         nsSeconds(i.Rtt),
+		"RttVar":      nsSeconds(i.RttVar),
+		"RcvRtt":      nsSeconds(i.RcvRtt),
+		"Rto":         nsSeconds(i.Rto),
+		"Mss":         i.Mss,
+		"Pmtu":        i.Pmtu,
+		"Cwnd":        i.Cwnd,
+		"SsThresh":    nil,
+		"Retransmits": i.Retransmits,
+		"Lost":        i.Lost,
+		"Reordering":  i.Reordering,
+		"CaState":     i.CaState,
+		"Reused":      s.Session.Reused,
+	}
+	if i.SsThresh < tcpInfiniteSsThresh {
+		ret["SsThresh"] = i.SsThresh
+	}
+	return ret, nil
+}
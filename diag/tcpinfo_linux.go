@@ -0,0 +1,61 @@
+//go:build linux && !386
+
+package diag
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Names of the congestion avoidance states, as in the kernel's tcp_ca_state
+var tcpCaStates = []string{"open", "disorder", "cwr", "recovery", "loss"}
+
+// Ask the kernel for what it knows about the TCP connection underneath conn
+func readTcpInfo(conn net.Conn) (*TcpInfo, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, errors.New("Not a TCP connection")
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var info syscall.TCPInfo
+	size := uint32(syscall.SizeofTCPInfo)
+	var errno syscall.Errno
+	err = raw.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_INFO,
+			uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&size)), 0)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if errno != 0 {
+		return nil, errno
+	}
+	state := "unknown"
+	if int(info.Ca_state) < len(tcpCaStates) {
+		state = tcpCaStates[info.Ca_state]
+	}
+	// The kernel gives times in microseconds
+	us := func(v uint32) int64 {
+		return int64(time.Duration(v) * time.Microsecond)
+	}
+	return &TcpInfo{
+		Rtt:         us(info.Rtt),
+		RttVar:      us(info.Rttvar),
+		RcvRtt:      us(info.Rcv_rtt),
+		Rto:         us(info.Rto),
+		Mss:         info.Snd_mss,
+		Pmtu:        info.Pmtu,
+		Cwnd:        info.Snd_cwnd,
+		SsThresh:    info.Snd_ssthresh,
+		Retransmits: info.Total_retrans,
+		Lost:        info.Lost,
+		Reordering:  info.Reordering,
+		CaState:     state,
+	}, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux || 386
+
+package diag
+
+import (
+	"errors"
+	"net"
+)
+
+// TCP_INFO is only asked for on Linux
+func readTcpInfo(conn net.Conn) (*TcpInfo, error) {
+	return nil, errors.New("TCP connection info is only available on Linux")
+}
@@ -0,0 +1,18 @@
+//go:build !linux && !darwin
+
+package diag
+
+import "os"
+
+// Returns true if f is a terminal, rather than a file or pipe. Without the
+// ioctl to ask, any character device counts.
+func IsTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Return the width of the terminal f is attached to, or 0 if it isn't one or
+// we don't know how to find out
+func terminalWidth(f *os.File) int {
+	return 0
+}
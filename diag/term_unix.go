@@ -0,0 +1,38 @@
+//go:build linux || darwin
+
+package diag
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// The window size of a terminal, as returned by TIOCGWINSZ
+type winsize struct {
+	Row, Col, X, Y uint16
+}
+
+// Return the window size of the terminal f is attached to, and whether it is
+// one. Only a terminal answers the ioctl, unlike the character device check,
+// which /dev/null passes too.
+func terminalSize(f *os.File) (winsize, bool) {
+	var ws winsize
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&ws)))
+	return ws, errno == 0
+}
+
+// Returns true if f is a terminal, rather than a file, pipe or other device
+func IsTerminal(f *os.File) bool {
+	_, ok := terminalSize(f)
+	return ok
+}
+
+// Return the width of the terminal f is attached to, or 0 if it isn't one
+func terminalWidth(f *os.File) int {
+	ws, ok := terminalSize(f)
+	if !ok {
+		return 0
+	}
+	return int(ws.Col)
+}
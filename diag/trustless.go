@@ -0,0 +1,201 @@
+package diag
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// The response formats a trustless gateway can be asked for, by the names
+// used for them in ?format= and -accept
+var GatewayFormats = map[string]string{
+	"raw":         "application/vnd.ipld.raw",
+	"car":         carMediaType,
+	"dag-json":    "application/vnd.ipld.dag-json",
+	"dag-cbor":    "application/vnd.ipld.dag-cbor",
+	"json":        "application/json",
+	"cbor":        "application/cbor",
+	"tar":         "application/x-tar",
+	"ipns-record": "application/vnd.ipfs.ipns-record",
+}
+
+// Return the names of the gateway formats, for usage messages
+func gatewayFormatNames() string {
+	names := []string{}
+	for k := range GatewayFormats {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Return the name of the gateway format of a media type, or an empty string
+// if it isn't one
+func gatewayFormatName(mediaType string) string {
+	for k, v := range GatewayFormats {
+		if v == mediaType {
+			return k
+		}
+	}
+	return ""
+}
+
+// Turn -accept into an Accept header, which may be the name of a format or a
+// media type as it is
+func AcceptHeader(format string) (string, error) {
+	if t, ok := GatewayFormats[strings.ToLower(format)]; ok {
+		return t, nil
+	}
+	if strings.Contains(format, "/") {
+		return format, nil
+	}
+	return "", fmt.Errorf("Unknown -accept format '%s': must be one of %s, or a media type", format, gatewayFormatNames())
+}
+
+// FormatReporter shows which response format was asked of a trustless
+// gateway, whether with the Accept header or ?format=, and whether that's
+// what came back.
+type FormatReporter struct{}
+
+func (r FormatReporter) Name() string {
+	return "Format"
+}
+
+func (r FormatReporter) Title() string {
+	return "Trustless Gateway Format"
+}
+
+func (r FormatReporter) Description() string {
+	return "Shows the response format asked for with Accept or ?format=, and whether the gateway returned it"
+}
+
+// formatInfo is what was asked for and what came back. Requested holds the
+// media types asked for, without their parameters, and from says how.
+type formatInfo struct {
+	requested []string
+	from      string
+	returned  string
+	honoured  bool
+	// varyAccept is set if the response says it varies on Accept, which
+	// caches need to know if the format is negotiated
+	varyAccept bool
+}
+
+func (r FormatReporter) info(s *StatsCollector) (formatInfo, error) {
+	ret := formatInfo{}
+	// ?format= takes precedence over Accept with gateways
+	if u, err := url.Parse(s.Uri); err == nil && u.Query().Get("format") != "" {
+		f := u.Query().Get("format")
+		t, ok := GatewayFormats[f]
+		if !ok {
+			t = f
+		}
+		ret.requested, ret.from = []string{t}, "format="+f
+	} else {
+		for _, a := range http.Header(s.RequestHeaders).Values("Accept") {
+			for _, t := range strings.Split(a, ",") {
+				if t, _, err := mime.ParseMediaType(t); err == nil {
+					ret.requested = append(ret.requested, t)
+				}
+			}
+		}
+		ret.from = "Accept"
+	}
+	if len(ret.requested) == 0 {
+		return ret, errors.New("No response format was asked for (see -accept)")
+	}
+
+	ret.returned, _, _ = mime.ParseMediaType(http.Header(s.ResponseHeaders).Get("Content-Type"))
+	if s.StatusCode < 300 {
+		for _, t := range ret.requested {
+			major, _, _ := strings.Cut(t, "/")
+			if t == ret.returned || t == "*/*" || (t == major+"/*" && strings.HasPrefix(ret.returned, major+"/")) {
+				ret.honoured = true
+			}
+		}
+	}
+	for _, v := range http.Header(s.ResponseHeaders).Values("Vary") {
+		for _, h := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(h), "Accept") {
+				ret.varyAccept = true
+			}
+		}
+	}
+	return ret, nil
+}
+
+// Describe a media type along with its format name, if it has one
+func describeFormat(t string) string {
+	if t == "" {
+		return "n/a"
+	}
+	if name := gatewayFormatName(t); name != "" {
+		return fmt.Sprintf("%s (%s)", t, name)
+	}
+	return t
+}
+
+func (r FormatReporter) Report(s *StatsCollector) (ret string, e error) {
+	i, err := r.info(s)
+	if err != nil {
+		return "", err
+	}
+
+	requested := []string{}
+	for _, t := range i.requested {
+		requested = append(requested, describeFormat(t))
+	}
+	tw := &strings.Builder{}
+	t := tablewriter.NewWriter(tw)
+	t.SetHeader([]string{"Requested", "With", "Returned", "Status"})
+	t.Append([]string{
+		strings.Join(requested, ", "),
+		i.from,
+		describeFormat(i.returned),
+		fmt.Sprintf("%d", s.StatusCode),
+	})
+	t.SetAlignment(tablewriter.ALIGN_LEFT)
+	t.SetRowLine(true)
+	t.Render()
+
+	switch {
+	case i.honoured:
+		tw.Write([]byte("The gateway returned the format that was asked for\n"))
+	case s.StatusCode == http.StatusNotAcceptable:
+		tw.Write([]byte("The gateway refused the format with 406 Not Acceptable\n"))
+	case s.StatusCode >= 300:
+		tw.Write([]byte(fmt.Sprintf("The gateway returned a status of %d rather than the format\n", s.StatusCode)))
+	default:
+		tw.Write([]byte("WARNING: the gateway returned a different format from the one asked for\n"))
+	}
+	if i.from == "Accept" && !i.varyAccept && s.StatusCode < 300 {
+		tw.Write([]byte("WARNING: the response doesn't say 'Vary: Accept', so a cache may serve it to a request for another format\n"))
+	}
+	ret = tw.String()
+	return
+}
+
+func (r FormatReporter) Data(s *StatsCollector) (map[string]interface{}, error) {
+	i, err := r.info(s)
+	if err != nil {
+		return nil, err
+	}
+	var returned interface{}
+	if i.returned != "" {
+		returned = i.returned
+	}
+	return map[string]interface{}{
+		"Requested":      i.requested,
+		"RequestedWith":  i.from,
+		"Returned":       returned,
+		"ReturnedFormat": gatewayFormatName(i.returned),
+		"Honoured":       i.honoured,
+		"VaryAccept":     i.varyAccept,
+	}, nil
+}
@@ -0,0 +1,115 @@
+package diag
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// The ways durations, rates and sizes can be shown in reports, with -units.
+// SI and IEC both give human friendly units like 2.3ms, and differ in whether
+// rates and sizes go up in 1000s (kB, MB) or 1024s (KiB, MiB). Raw is plain
+// seconds and kB/s (of 1024 bytes), as they always were, for scripts.
+const (
+	UnitsSI  = "si"
+	UnitsIEC = "iec"
+	UnitsRaw = "raw"
+)
+
+// How reports show durations, rates and sizes. JSON, CSV and the log are
+// always raw.
+var Units = UnitsSI
+
+// Return a column heading, with the unit its values are in if they're raw.
+// Human friendly values carry their own units.
+func withUnit(name string, unit string) string {
+	if Units == UnitsRaw {
+		return fmt.Sprintf("%s (%s)", name, unit)
+	}
+	return name
+}
+
+// Format a time in seconds for a table, where the heading gives the unit
+func fmtSeconds(v float64) string {
+	if Units == UnitsRaw {
+		return fmt.Sprintf("%f", v)
+	}
+	a := math.Abs(v)
+	switch {
+	case a == 0:
+		return "0s"
+	case a < 0.001:
+		return fmt.Sprintf("%.1fµs", v*1e6)
+	case a < 1:
+		return fmt.Sprintf("%.1fms", v*1e3)
+	case a < 60:
+		return fmt.Sprintf("%.2fs", v)
+	}
+	return time.Duration(v * float64(time.Second)).Round(time.Second).String()
+}
+
+// Format a duration for a table, where the heading gives the unit
+func fmtDuration(d time.Duration) string {
+	return fmtSeconds(d.Seconds())
+}
+
+// Format a time in seconds for a sentence
+func SecondsText(v float64) string {
+	if Units == UnitsRaw {
+		return fmt.Sprintf("%f seconds", v)
+	}
+	return fmtSeconds(v)
+}
+
+// Scale n bytes to the largest unit it has at least one of
+func scaleBytes(n float64, si []string, iec []string) (float64, string) {
+	base, names := 1000.0, si
+	if Units == UnitsIEC {
+		base, names = 1024, iec
+	}
+	i := 0
+	for math.Abs(n) >= base && i < len(names)-1 {
+		n /= base
+		i++
+	}
+	return n, names[i]
+}
+
+// Format a rate given in kB/s, as the stats have them, for a table, where the
+// heading gives the unit
+func fmtRate(kb float64) string {
+	if Units == UnitsRaw {
+		return fmt.Sprintf("%f", kb)
+	}
+	v, name := scaleBytes(kb*1024,
+		[]string{"B/s", "kB/s", "MB/s", "GB/s"}, []string{"B/s", "KiB/s", "MiB/s", "GiB/s"})
+	return fmt.Sprintf("%.1f %s", v, name)
+}
+
+// Format a rate given in kB/s for a sentence
+func RateText(kb float64) string {
+	if Units == UnitsRaw {
+		return fmt.Sprintf("%f kB/s", kb)
+	}
+	return fmtRate(kb)
+}
+
+// Format a number of bytes for a sentence, or a table where the heading
+// gives the unit
+func fmtBytes(n uint64) string {
+	if Units == UnitsRaw {
+		return fmt.Sprintf("%d", n)
+	}
+	if v, name := scaleBytes(float64(n), []string{"B", "kB", "MB", "GB"}, []string{"B", "KiB", "MiB", "GiB"}); name != "B" {
+		return fmt.Sprintf("%.1f %s", v, name)
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// Format a number of bytes for a sentence
+func bytesText(n uint64) string {
+	if Units == UnitsRaw {
+		return fmt.Sprintf("%d bytes", n)
+	}
+	return fmtBytes(n)
+}
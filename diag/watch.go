@@ -0,0 +1,50 @@
+package diag
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// WatchLine summarises a single probe made with -watch on one line, with when
+// it was made, the status, the time to first byte and the throughput.
+func WatchLine(s *StatsCollector, err error) string {
+	when := time.Now()
+	if s.Total.StartTime != 0 {
+		when = time.Unix(0, s.Total.StartTime)
+	}
+	line := when.Format("2006-01-02 15:04:05")
+	if s.StatusCode != 0 {
+		line += fmt.Sprintf("  %s %d", s.Proto, s.StatusCode)
+	}
+	if d, ok := s.PhaseDuration(PhaseFirstByte); ok {
+		line += "  ttfb " + SecondsText(d.Seconds())
+	}
+	if s.DurationNS() > 0 {
+		line += "  " + RateText(s.KBPerSecond())
+	}
+	if err != nil {
+		line += "  failed: " + err.Error()
+	}
+	return line
+}
+
+// WatchReport summarises all of the probes made with -watch, with how many
+// succeeded followed by the spread of the timings across them.
+func WatchReport(runs []*StatsCollector, errs []error, elapsed time.Duration) string {
+	tw := &strings.Builder{}
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	fmt.Fprintf(tw, "Summary of %d probes over %s\n", len(runs), elapsed.Round(time.Second))
+	if len(runs) == 0 {
+		return tw.String()
+	}
+	tw.WriteString(AggregateReport(runs))
+	fmt.Fprintf(tw, "%d of %d probes succeeded (%.1f%%), %d failed\n",
+		len(runs)-failed, len(runs), float64(len(runs)-failed)/float64(len(runs))*100, failed)
+	return tw.String()
+}
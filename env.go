@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// The prefix of the environment variables that set flags
+const envPrefix = "WEB3DIAG_"
+
+// repeatableFlag is a flag that may be given more than once, such as -header.
+// Its environment variable has one value on each line.
+type repeatableFlag interface {
+	Repeatable() bool
+}
+
+// Return the environment variable for a flag, which is its name in upper case
+// with an underscore between words, so -reportHeaders is
+// WEB3DIAG_REPORT_HEADERS and -verifyCID is WEB3DIAG_VERIFY_CID.
+func envName(name string) string {
+	r := []rune(name)
+	b := &strings.Builder{}
+	b.WriteString(envPrefix)
+	for i, c := range r {
+		if i > 0 && unicode.IsUpper(c) {
+			// The start of a word, but not the rest of an acronym
+			prev := r[i-1]
+			next := i+1 < len(r) && unicode.IsLower(r[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && next) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(c))
+	}
+	return b.String()
+}
+
+// Set any flags that weren't given on the command line from their environment
+// variables, so that flags always take precedence. Returns an error for a
+// value the flag won't take, or a variable with the prefix that isn't for any
+// flag, which is most likely a typo.
+func applyEnv(fs *flag.FlagSet) error {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	known := map[string]bool{}
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envName(f.Name)
+		known[name] = true
+		v, ok := os.LookupEnv(name)
+		if !ok || given[f.Name] || err != nil {
+			return
+		}
+		values := []string{v}
+		if r, ok := f.Value.(repeatableFlag); ok && r.Repeatable() {
+			values = []string{}
+			for _, line := range strings.Split(v, "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					values = append(values, line)
+				}
+			}
+		}
+		for _, v := range values {
+			if e := fs.Set(f.Name, v); e != nil {
+				err = fmt.Errorf("Invalid value '%s' for %s: %s", v, name, e)
+				return
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(name, envPrefix) && !known[name] {
+			return fmt.Errorf("%s doesn't match any flag", name)
+		}
+	}
+	return nil
+}
@@ -1,185 +1,1329 @@
 package main
 
 import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
-	"net/http/httptrace"
+	"net/url"
 	"os"
+	"os/signal"
+	"regexp"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
+
+	"mattgeddes/web3diag/diag"
 )
 
+// The version of web3diag, set when building with
+// -ldflags "-X main.version=..."
+var version = "dev"
+
+// Valid values for -range
+var rangePattern = regexp.MustCompile(`^(\d+-\d*|-\d+)$`)
+
+// headerFlags collects each -header flag given as a set of HTTP headers
+type headerFlags http.Header
+
+func (h headerFlags) String() string {
+	ret := []string{}
+	for k, vs := range h {
+		for _, v := range vs {
+			ret = append(ret, k+": "+v)
+		}
+	}
+	return strings.Join(ret, ", ")
+}
+
+func (h headerFlags) Set(v string) error {
+	k, val, ok := strings.Cut(v, ":")
+	if !ok || strings.TrimSpace(k) == "" {
+		return errors.New("headers must be given as 'Key: Value'")
+	}
+	http.Header(h).Add(strings.TrimSpace(k), strings.TrimSpace(val))
+	return nil
+}
+
+func (h headerFlags) Repeatable() bool {
+	return true
+}
+
+// resolveFlags collects each -resolve flag as a map of host:port to the IP
+// address to connect to instead
+type resolveFlags map[string]string
+
+func (r resolveFlags) String() string {
+	ret := []string{}
+	for k, v := range r {
+		ret = append(ret, k+":"+v)
+	}
+	return strings.Join(ret, ", ")
+}
+
+func (r resolveFlags) Set(v string) error {
+	// The address may be IPv6, so only split off the host and port
+	parts := strings.SplitN(v, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || net.ParseIP(parts[2]) == nil {
+		return errors.New("must be given as host:port:ip")
+	}
+	if _, err := strconv.ParseUint(parts[1], 10, 16); err != nil {
+		return fmt.Errorf("invalid port '%s'", parts[1])
+	}
+	r[net.JoinHostPort(strings.ToLower(parts[0]), parts[1])] = parts[2]
+	return nil
+}
+
+func (r resolveFlags) Repeatable() bool {
+	return true
+}
+
+// Return the cipher suites that can be chosen with -ciphers, which leaves out
+// those only for TLS 1.3
+func choosableCipherSuites() []*tls.CipherSuite {
+	ret := []*tls.CipherSuite{}
+	for _, c := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		for _, v := range c.SupportedVersions {
+			if v < tls.VersionTLS13 {
+				ret = append(ret, c)
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// Return the IDs of a comma-separated list of cipher suite names, as Go names
+// them, or nil if there are none so that Go's defaults are used
+func parseCipherSuites(list string) ([]uint16, error) {
+	suites := choosableCipherSuites()
+	var ret []uint16
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		found := false
+		for _, c := range suites {
+			if strings.EqualFold(c.Name, name) {
+				ret = append(ret, c.ID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			for _, c := range tls.CipherSuites() {
+				if strings.EqualFold(c.Name, name) {
+					return nil, fmt.Errorf("'%s' is a TLS 1.3 cipher suite, which can't be chosen", name)
+				}
+			}
+			return nil, fmt.Errorf("unknown cipher suite '%s'", name)
+		}
+	}
+	return ret, nil
+}
+
+// tlsVersionFlag is a TLS version given as e.g. 1.2, for -tlsMin and -tlsMax,
+// which is 0 if not given
+type tlsVersionFlag uint16
+
+func (v *tlsVersionFlag) String() string {
+	if v == nil || *v == 0 {
+		return ""
+	}
+	return diag.TlsVersionNumber(uint16(*v))
+}
+
+func (v *tlsVersionFlag) Set(s string) error {
+	for _, ver := range []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13} {
+		if diag.TlsVersionNumber(ver) == s {
+			*v = tlsVersionFlag(ver)
+			return nil
+		}
+	}
+	return errors.New("must be 1.0, 1.1, 1.2 or 1.3")
+}
+
 func main() {
 	var (
 		// Command line flags
-		noCache   = false
-		uri       = ""
-		outFile   = ""
-		reporters = ""
+		noCache     = false
+		pragmaNC    = false
+		ccNoCache   = false
+		ccNoStore   = false
+		ccMustReval = false
+		noCacheHdrs = ""
+		uri         = ""
+		outFile     = ""
+		tee         = false
+		headerOut   = ""
+		reqDump     = false
+		reporters   = ""
+		format      = ""
+		unitsFlag   = ""
+		gateway     = ""
+		jsonOut     = ""
+		influxUrl   = ""
+		otlp        = ""
+		reportHdrs  = ""
+		uriFile     = ""
+		count       = 0
+		reuse       = true
+		noKeepAlive = false
+		http3       = false
+		concurrency = 0
+		bench       = false
+		rank        = false
+		timeout     = time.Duration(0)
+		dialTime    = time.Duration(0)
+		tlsTime     = time.Duration(0)
+		tlsMin      = tlsVersionFlag(0)
+		tlsMax      = tlsVersionFlag(0)
+		ciphers     = ""
+		clientCert  = ""
+		clientKey   = ""
+		doh         = ""
+		dns         = ""
+		byteRange   = ""
+		resume      = false
+		method      = ""
+		data        = ""
+		dataFile    = ""
+		headers     = headerFlags{}
+		accept      = ""
+		resolves    = resolveFlags{}
+		compare     = ""
+		ptr         = false
+		showVersion = false
+		userAgent   = ""
+		basicAuth   = ""
+		bearer      = ""
+		redact      = ""
+		cname       = false
+		ipv4        = false
+		ipv6        = false
+		geodb       = ""
+		insecure    = false
+		proxyUri    = ""
+		retries     = 0
+		retryDelay  = time.Duration(0)
+		forceRetry  = false
+		failOn      = 0
+		noCompress  = false
+		ttfbOnly    = false
+		maxBytes    = int64(0)
+		warmup      = 0
+		watch       = time.Duration(0)
+		stallTime   = time.Duration(0)
+		verifyCID   = false
+		sha256Sum   = ""
+		md5Sum      = ""
+		quiet       = false
+		verbose     = false
+		config      = ""
+		maxRedirect = 0
 	)
 
+	flag.StringVar(&config, "config", "", "YAML or JSON file of settings keyed by flag name. Flags on the command line or in the environment override them.")
+	flag.BoolVar(&showVersion, "version", false, "Show the version of web3diag and exit.")
 	flag.BoolVar(&noCache, "noCache", false, "Request that the content not come from a cache in the middle.")
+	flag.BoolVar(&pragmaNC, "pragmaNoCache", false, "Send 'Pragma: no-cache', as -noCache does.")
+	flag.BoolVar(&ccNoCache, "ccNoCache", false, "Send 'Cache-Control: no-cache', as -noCache does.")
+	flag.BoolVar(&ccNoStore, "ccNoStore", false, "Send 'Cache-Control: no-store', as -noCache does.")
+	flag.BoolVar(&ccMustReval, "ccMustRevalidate", false, "Send 'Cache-Control: must-revalidate', as -noCache does.")
+	flag.StringVar(&noCacheHdrs, "noCacheHeaders", "", "Comma-separated list of the -noCache headers to send: pragma, no-cache, no-store, must-revalidate or expires.")
 	flag.StringVar(&uri, "uri", "", "URI to request (required).")
-	flag.StringVar(&outFile, "outFile", "/dev/null", "File to save downloaded data to.")
+	flag.StringVar(&uriFile, "uriFile", "", "File of URIs to request one after the other, one per line, instead of -uri.")
+	flag.StringVar(&compare, "compare", "", "Second URI to request after -uri, and compare the two side by side.")
+	flag.BoolVar(&noCompress, "noCompress", false, "Don't ask for the content to be compressed.")
+	flag.StringVar(&outFile, "outFile", "/dev/null", "File to save downloaded data to, or '-' for stdout.")
+	flag.BoolVar(&tee, "tee", false, "Write the downloaded data to stdout as well as to -outFile.")
+	flag.StringVar(&headerOut, "headerOut", "", "File to save the response status line and headers to, as they came over the wire.")
+	flag.BoolVar(&reqDump, "reqDump", false, "Also save the request to the -headerOut file, ahead of the response.")
 	flag.StringVar(&reporters, "reporters", "", "Comma-separated list of reporters to call. Use '-reporters list' for a list.")
+	flag.StringVar(&reportHdrs, "reportHeaders", "", "Comma-separated list of response headers to show in a table of their own, with the Custom reporter.")
+	flag.StringVar(&format, "format", "table", "Output format for reporters: table, plain, json or csv.")
+	flag.StringVar(&unitsFlag, "units", diag.UnitsSI, "Units for times, rates and sizes in reports: si, iec, or raw for plain seconds and kB/s.")
+	flag.BoolVar(&quiet, "quiet", false, "Only log errors, and don't show a progress bar during the transfer.")
+	flag.BoolVar(&verbose, "verbose", false, "Log every trace event and header, as well as the main milestones.")
+	flag.Var(headers, "header", "Extra request header, as 'Key: Value'. May be given more than once.")
+	flag.StringVar(&accept, "accept", "", "Response format to ask a trustless gateway for: raw, car, dag-json, dag-cbor, json, cbor, tar or ipns-record, or a media type to send as the Accept header.")
+	flag.StringVar(&userAgent, "userAgent", "web3diag/"+version, "User-Agent to send, or '' to send none.")
+	flag.StringVar(&basicAuth, "basicAuth", "", "Credentials to send with HTTP basic authentication, as 'user:password'.")
+	flag.StringVar(&bearer, "bearer", "", "Token to send as 'Authorization: Bearer <token>'.")
+	flag.StringVar(&redact, "redact", "", "Comma-separated list of headers to hide the values of, as well as Authorization, Proxy-Authorization, Cookie and Set-Cookie.")
+	flag.StringVar(&method, "method", "GET", "HTTP method to use.")
+	flag.StringVar(&data, "data", "", "Request body to send, e.g. with -method POST.")
+	flag.StringVar(&dataFile, "dataFile", "", "File containing the request body to send.")
+	flag.IntVar(&maxRedirect, "maxRedirects", 10, "Most redirects to follow, 0 to stop at the first and report on the redirect itself, or negative for no limit (up to 100).")
+	flag.BoolVar(&ttfbOnly, "ttfbOnly", false, "Stop once the response headers arrive, without downloading the body.")
+	flag.Int64Var(&maxBytes, "maxBytes", 0, "Stop downloading after this many bytes of content (0 for no limit).")
+	flag.StringVar(&byteRange, "range", "", "Byte range to request, e.g. 0-1023, 1024- or -512 (the last 512 bytes).")
+	flag.BoolVar(&resume, "resume", false, "If -outFile already has the start of the content, only download the rest and add it to the end.")
+	flag.BoolVar(&verifyCID, "verifyCID", false, "Check the downloaded content against the CID of an ipfs:// URI.")
+	flag.StringVar(&sha256Sum, "sha256", "", "Expected SHA-256 of the downloaded content, in hex.")
+	flag.StringVar(&md5Sum, "md5", "", "Expected MD5 of the downloaded content, in hex.")
+	flag.StringVar(&gateway, "gateway", diag.DefaultGateway, "Gateway to use for ipfs:// and ipns:// URIs.")
+	flag.StringVar(&jsonOut, "jsonOut", "", "File to write the stats to as JSON. Use '-' for stdout.")
+	flag.StringVar(&influxUrl, "influxUrl", "", "InfluxDB write URL to post the results to as line protocol, e.g. http://localhost:8086/write?db=web3diag.")
+	flag.StringVar(&otlp, "otlp", "", "OpenTelemetry collector (e.g. http://localhost:4318) to export each run to as a trace, using OTLP/HTTP.")
+	flag.IntVar(&count, "count", 1, "Number of times to make the request.")
+	flag.DurationVar(&watch, "watch", 0, "Repeat the request at this interval until interrupted, with a line for each, then summarise them.")
+	flag.IntVar(&warmup, "warmup", 0, "Number of requests to make and discard before those that are measured, to warm up caches.")
+	flag.BoolVar(&reuse, "reuse", true, "Reuse connections between requests when using -count.")
+	flag.BoolVar(&noKeepAlive, "noKeepAlive", false, "Disable keepalives, so that every request (and redirect) makes a new connection with its own DNS lookup and TLS handshake.")
+	flag.BoolVar(&http3, "http3", false, "Make requests over HTTP/3 (QUIC) rather than HTTP/1.1 or HTTP/2 over TCP. Only for https:// URIs.")
+	flag.IntVar(&concurrency, "concurrency", 1, "Number of requests to make at the same time.")
+	flag.BoolVar(&bench, "bench", false, "Benchmark the gateway with -count requests over -concurrency workers, showing the rate, errors and a histogram of the latency to the first byte instead of the usual summary.")
+	flag.BoolVar(&rank, "rank", false, "Rank the URIs in a -uriFile, or the runs with -count, by time to first byte and throughput, flagging outliers.")
+	flag.IntVar(&retries, "retries", 0, "Number of times to retry a request after a connection failure or a 502, 503 or 504 response.")
+	flag.IntVar(&failOn, "failOn", 400, "Lowest response status code to treat as a failure, for the exit code (0 to never fail on the status).")
+	flag.DurationVar(&retryDelay, "retryDelay", time.Second, "Time to wait before the first retry, doubling for each one after.")
+	flag.BoolVar(&forceRetry, "forceRetry", false, "Retry methods that aren't idempotent, such as POST, too. They aren't retried otherwise, as the server may have acted on the first attempt.")
+	flag.DurationVar(&timeout, "timeout", time.Second*30, "Overall time limit for the request, including reading the body (0 for no limit).")
+	flag.DurationVar(&dialTime, "dialTimeout", 0, "Time limit for establishing the TCP connection (0 for no limit).")
+	flag.DurationVar(&tlsTime, "tlsTimeout", 0, "Time limit for the TLS handshake (0 for no limit).")
+	flag.Var(&tlsMin, "tlsMin", "Lowest TLS version to offer: 1.0, 1.1, 1.2 or 1.3 (default 1.2).")
+	flag.Var(&tlsMax, "tlsMax", "Highest TLS version to offer: 1.0, 1.1, 1.2 or 1.3 (default 1.3).")
+	flag.StringVar(&ciphers, "ciphers", "", "Comma-separated list of cipher suites to offer for TLS 1.2 and earlier. Use '-ciphers list' for a list.")
+	flag.DurationVar(&stallTime, "stallThreshold", 2*time.Second, "Gap in the transfer of the body long enough to count as a stall.")
+	flag.BoolVar(&insecure, "insecure", false, "Skip TLS certificate verification. The Certificate reporter still says whether it would have failed.")
+	flag.StringVar(&clientCert, "clientCert", "", "PEM file of a client certificate to offer if the server asks for one, for mutual TLS.")
+	flag.StringVar(&clientKey, "clientKey", "", "PEM file of the private key for -clientCert, if it's not in the same file.")
+	flag.StringVar(&geodb, "geodb", "", "Comma-separated list of MaxMind DB (.mmdb) files for the Geo reporter, e.g. GeoLite2 City and ASN.")
+	flag.StringVar(&proxyUri, "proxy", "", "Proxy to make requests through, as a http://, https:// or socks5:// URL. Overrides the environment.")
+	flag.Var(resolves, "resolve", "Connect to the given IP address for a host and port, as 'host:port:ip'. May be given more than once.")
+	flag.BoolVar(&ipv4, "ipv4", false, "Only connect to the server over IPv4.")
+	flag.BoolVar(&ipv6, "ipv6", false, "Only connect to the server over IPv6.")
+	flag.BoolVar(&cname, "cname", false, "Look up the chain of CNAMEs for the host once the transfer is done.")
+	flag.BoolVar(&ptr, "ptr", false, "Look up the name(s) of the server's IP address once the transfer is done.")
+	flag.StringVar(&dns, "dns", "", "DNS server (host:port) to resolve names with, instead of the system resolver.")
+	flag.StringVar(&doh, "doh", "", "URL of a DNS-over-HTTPS server to resolve names with, instead of the system resolver.")
 
 	flag.Parse()
+	if err := applyEnv(flag.CommandLine); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(diag.ExitUsage)
+	}
+	if config != "" {
+		if err := applyConfig(flag.CommandLine, config); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(diag.ExitUsage)
+		}
+	}
+
+	if showVersion {
+		fmt.Printf("web3diag %s (%s %s/%s)\n", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+		os.Exit(diag.ExitOK)
+	}
 
 	if reporters == "list" {
 		// Sort the list of keys to make it prettier to read
-		reps := make([]string, 0, len(reportersList))
-		for k := range reportersList {
+		reps := make([]string, 0, len(diag.ReportersList))
+		for k := range diag.ReportersList {
 			reps = append(reps, k)
 		}
 
 		sort.Strings(reps)
 
 		fmt.Println("List of reporters:")
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
 		for _, k := range reps {
-			// TODO: also worth spitting out Name() or Description()?
-			fmt.Printf("    %s\n", k)
+			r := diag.ReportersList[k]
+			fmt.Fprintf(w, "    %s\t- %s:\t%s\n", k, r.Name(), r.Description())
+		}
+		w.Flush()
+
+		os.Exit(diag.ExitOK)
+	}
+
+	if ciphers == "list" {
+		fmt.Println("List of cipher suites:")
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+		for _, c := range choosableCipherSuites() {
+			note := ""
+			if c.Insecure {
+				note = "(insecure)"
+			}
+			fmt.Fprintf(w, "    %s\t%s\n", c.Name, note)
 		}
+		w.Flush()
 
-		os.Exit(0)
+		os.Exit(diag.ExitOK)
 	}
 
-	if uri == "" {
-		fmt.Println("No URI specified!")
+	if uri == "" && uriFile == "" {
+		fmt.Fprintln(os.Stderr, "No URI specified!")
 		flag.Usage()
-		os.Exit(1)
+		os.Exit(diag.ExitUsage)
 	}
 
-	// http/https for now. Things like ipfs:// will come as needed.
-	if !strings.HasPrefix(strings.ToLower(uri), "http://") &&
-		!strings.HasPrefix(strings.ToLower(uri), "https://") {
-		fmt.Println("Currently, only http:// and https:// URIs are supported")
-		os.Exit(1)
+	if uri != "" && uriFile != "" {
+		fmt.Fprintln(os.Stderr, "Only one of -uri and -uriFile may be used")
+		os.Exit(diag.ExitUsage)
 	}
 
-	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
-	log.Printf("Downloading '%s'\n", uri)
+	if uriFile != "" && (compare != "" || outFile != "/dev/null") {
+		fmt.Fprintln(os.Stderr, "The -uriFile flag can't be used with -compare or -outFile")
+		os.Exit(diag.ExitUsage)
+	}
 
-	req, err := http.NewRequest("GET", uri, nil)
-	if err != nil {
-		// TODO: should we do more here?
-		log.Fatal(fmt.Sprintf("Request for %s failed: %s", uri, err))
-	}
-
-	// Our object for tracing/counting
-	httpStats := &StatsCollector{}
-
-	// Hook into certain HTTP tracing points
-	trace := &httptrace.ClientTrace{
-		DNSStart: func(dnsInfo httptrace.DNSStartInfo) {
-			httpStats.StartDns(dnsInfo.Host)
-		},
-		DNSDone: func(dnsInfo httptrace.DNSDoneInfo) {
-			httpStats.EndDns(dnsInfo.Addrs)
-		},
-		TLSHandshakeStart: func() {
-			httpStats.StartTls()
-		},
-		TLSHandshakeDone: func(t tls.ConnectionState, err error) {
-			httpStats.EndTls(t.Version, t.CipherSuite, t.ServerName)
-		},
-		ConnectStart: func(net string, addr string) {
-			httpStats.StartConnect(net, addr)
-		},
-		ConnectDone: func(net string, addr string, err error) {
-			httpStats.EndConnect(net, addr, err)
-		},
-		GetConn: func(hostPort string) {
-			httpStats.StartSession(hostPort)
-		},
-		GotConn: func(connInfo httptrace.GotConnInfo) {
-			httpStats.GotSession(connInfo.Conn.LocalAddr(), connInfo.Conn.RemoteAddr())
-		},
-		WroteRequest: func(w httptrace.WroteRequestInfo) {
-			httpStats.WroteRequest(w.Err)
-		},
-		GotFirstResponseByte: func() {
-			httpStats.FirstByteReceived()
-		},
-	}
-	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
-	if noCache {
-		// This currently sets a few headers to prevent caching, but it
-		// may be worth splitting this out into separate arguments at
-		// some point for more fine-grained control in testing.
-		log.Println("Requesting that content not come from cache")
-		req.Header.Add("Pragma", "no-cache")
-		req.Header.Add("Cache-Control", "no-cache")
-		req.Header.Add("Cache-Control", "no-store")
-		req.Header.Add("Cache-Control", "must-revalidate")
-		req.Header.Add("Expires", "0")
-	}
-	httpStats.SetRequestHeaders(req.Header)
-	cli := &http.Client{
-		Timeout: time.Second * 30,
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-		},
-	}
-	resp, err := cli.Do(req)
-	if err != nil {
-		panic(err)
+	if format != "table" && format != "plain" && format != "json" && format != "csv" {
+		fmt.Fprintln(os.Stderr, "The -format flag must be one of table, plain, json or csv")
+		os.Exit(diag.ExitUsage)
+	}
+
+	if unitsFlag != diag.UnitsSI && unitsFlag != diag.UnitsIEC && unitsFlag != diag.UnitsRaw {
+		fmt.Fprintln(os.Stderr, "The -units flag must be one of si, iec or raw")
+		os.Exit(diag.ExitUsage)
+	}
+	diag.Units = unitsFlag
+
+	if quiet && verbose {
+		fmt.Fprintln(os.Stderr, "Only one of -quiet and -verbose may be used")
+		os.Exit(diag.ExitUsage)
+	}
+	if quiet {
+		diag.LogLevel = diag.LogQuiet
+	}
+	if verbose {
+		diag.LogLevel = diag.LogVerbose
+	}
+
+	if count < 1 || concurrency < 1 {
+		fmt.Fprintln(os.Stderr, "The -count and -concurrency flags must be at least 1")
+		os.Exit(diag.ExitUsage)
+	}
+
+	if warmup < 0 {
+		fmt.Fprintln(os.Stderr, "The -warmup flag can't be negative")
+		os.Exit(diag.ExitUsage)
+	}
+
+	if retries < 0 || retryDelay < 0 {
+		fmt.Fprintln(os.Stderr, "The -retries and -retryDelay flags can't be negative")
+		os.Exit(diag.ExitUsage)
+	}
+
+	if ipv4 && ipv6 {
+		fmt.Fprintln(os.Stderr, "Only one of -ipv4 and -ipv6 may be used")
+		os.Exit(diag.ExitUsage)
+	}
+
+	if watch < 0 {
+		fmt.Fprintln(os.Stderr, "The -watch flag can't be negative")
+		os.Exit(diag.ExitUsage)
+	}
+
+	if watch > 0 && (count != 1 || concurrency != 1 || compare != "" || uriFile != "" || reporters != "" || reportHdrs != "" || outFile != "/dev/null") {
+		fmt.Fprintln(os.Stderr, "The -watch flag can't be used with -count, -concurrency, -compare, -uriFile, -reporters, -reportHeaders or -outFile")
+		os.Exit(diag.ExitUsage)
+	}
+
+	if bench && (watch > 0 || compare != "" || reporters != "" || reportHdrs != "" || outFile != "/dev/null" || tee) {
+		fmt.Fprintln(os.Stderr, "The -bench flag can't be used with -watch, -compare, -reporters, -reportHeaders, -outFile or -tee")
+		os.Exit(diag.ExitUsage)
+	}
+
+	if rank && uriFile == "" && count < 2 && concurrency < 2 {
+		fmt.Fprintln(os.Stderr, "The -rank flag needs -uriFile, or -count or -concurrency of more than 1")
+		os.Exit(diag.ExitUsage)
+	}
+
+	if rank && (watch > 0 || compare != "" || bench) {
+		fmt.Fprintln(os.Stderr, "The -rank flag can't be used with -watch, -compare or -bench")
+		os.Exit(diag.ExitUsage)
+	}
+
+	if failOn != 0 && (failOn < 100 || failOn > 599) {
+		fmt.Fprintln(os.Stderr, "The -failOn flag must be a status code from 100 to 599, or 0")
+		os.Exit(diag.ExitUsage)
 	}
-	defer resp.Body.Close()
 
-	httpStats.SetResponseHeaders(resp.Header)
+	if stallTime <= 0 {
+		fmt.Fprintln(os.Stderr, "The -stallThreshold flag must be more than 0")
+		os.Exit(diag.ExitUsage)
+	}
 
-	log.Printf("Writing retrieved data to '%s'", outFile)
-	out, err := os.Create(outFile)
+	if tlsMin != 0 && tlsMax != 0 && tlsMin > tlsMax {
+		fmt.Fprintln(os.Stderr, "The -tlsMin flag can't be a later version than -tlsMax")
+		os.Exit(diag.ExitUsage)
+	}
+	cipherSuites, err := parseCipherSuites(ciphers)
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "Invalid -ciphers: %s. Use '-ciphers list' for a list.\n", err)
+		os.Exit(diag.ExitUsage)
+	}
+	if len(cipherSuites) > 0 && tlsMin == tls.VersionTLS13 {
+		fmt.Fprintln(os.Stderr, "The -ciphers flag has no effect with -tlsMin 1.3, as the TLS 1.3 cipher suites can't be chosen")
+		os.Exit(diag.ExitUsage)
+	}
+	if http3 && (len(cipherSuites) > 0 || (tlsMax != 0 && tlsMax < tls.VersionTLS13)) {
+		fmt.Fprintln(os.Stderr, "The -http3 flag can't be used with -ciphers or a -tlsMax below 1.3, as QUIC always uses TLS 1.3")
+		os.Exit(diag.ExitUsage)
+	}
+	if http3 && (proxyUri != "" || noKeepAlive) {
+		fmt.Fprintln(os.Stderr, "The -http3 flag can't be used with -proxy, as QUIC can't go through one, or -noKeepAlive (use -reuse=false instead)")
+		os.Exit(diag.ExitUsage)
+	}
+	if clientKey != "" && clientCert == "" {
+		fmt.Fprintln(os.Stderr, "The -clientKey flag can only be used with -clientCert")
+		os.Exit(diag.ExitUsage)
+	}
+	var cert *tls.Certificate
+	if clientCert != "" {
+		if clientKey == "" {
+			clientKey = clientCert
+		}
+		c, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to load the client certificate from '%s': %s\n", clientCert, err)
+			os.Exit(diag.ExitUsage)
+		}
+		cert = &c
+	}
+
+	if tlsMin == 0 && tlsMax != 0 && tlsMax < tls.VersionTLS12 {
+		// Go won't offer anything older than TLS 1.2 unless asked to
+		tlsMin = tls.VersionTLS10
+	}
+
+	if byteRange != "" && !rangePattern.MatchString(byteRange) {
+		fmt.Fprintln(os.Stderr, "The -range flag must be of the form start-end, start- or -length")
+		os.Exit(diag.ExitUsage)
+	}
+
+	sha256Sum, md5Sum = strings.ToLower(sha256Sum), strings.ToLower(md5Sum)
+	if sha256Sum != "" && !hexDigest(sha256Sum, sha256.Size) {
+		fmt.Fprintln(os.Stderr, "The -sha256 flag must be 64 hex digits")
+		os.Exit(diag.ExitUsage)
+	}
+	if md5Sum != "" && !hexDigest(md5Sum, md5.Size) {
+		fmt.Fprintln(os.Stderr, "The -md5 flag must be 32 hex digits")
+		os.Exit(diag.ExitUsage)
+	}
+
+	if maxBytes < 0 {
+		fmt.Fprintln(os.Stderr, "The -maxBytes flag can't be negative")
+		os.Exit(diag.ExitUsage)
+	}
+
+	if maxBytes > 0 && (verifyCID || sha256Sum != "" || md5Sum != "") {
+		fmt.Fprintln(os.Stderr, "The -maxBytes flag can't be used with -verifyCID, -sha256 or -md5")
+		os.Exit(diag.ExitUsage)
+	}
+
+	if ttfbOnly && (maxBytes > 0 || verifyCID || sha256Sum != "" || md5Sum != "" || outFile != "/dev/null") {
+		fmt.Fprintln(os.Stderr, "The -ttfbOnly flag can't be used with -maxBytes, -verifyCID, -sha256, -md5 or -outFile")
+		os.Exit(diag.ExitUsage)
+	}
+
+	if resume && (outFile == "/dev/null" || byteRange != "" || count > 1) {
+		fmt.Fprintln(os.Stderr, "The -resume flag needs -outFile, and can't be used with -range or -count")
+		os.Exit(diag.ExitUsage)
+	}
+
+	if resume && outFile == "-" {
+		fmt.Fprintln(os.Stderr, "The -resume flag needs -outFile to be a file rather than stdout")
+		os.Exit(diag.ExitUsage)
+	}
+
+	if tee && (outFile == "-" || concurrency > 1 || compare != "" || uriFile != "" || watch > 0 || ttfbOnly) {
+		fmt.Fprintln(os.Stderr, "The -tee flag can't be used with -outFile -, -concurrency, -compare, -uriFile, -watch or -ttfbOnly")
+		os.Exit(diag.ExitUsage)
+	}
+
+	if (outFile == "-" || tee) && jsonOut == "-" {
+		fmt.Fprintln(os.Stderr, "The body is written to stdout with -outFile - or -tee, so -jsonOut - can't be used as well")
+		os.Exit(diag.ExitUsage)
+	}
+
+	if verifyCID && byteRange != "" {
+		fmt.Fprintln(os.Stderr, "The -verifyCID flag can't be used with -range")
+		os.Exit(diag.ExitUsage)
+	}
+
+	if data != "" && dataFile != "" {
+		fmt.Fprintln(os.Stderr, "Only one of -data and -dataFile may be used")
+		os.Exit(diag.ExitUsage)
+	}
+
+	var body []byte
+	if data != "" {
+		body = []byte(data)
+	}
+	if dataFile != "" {
+		var err error
+		if body, err = os.ReadFile(dataFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to read '%s': %s\n", dataFile, err)
+			os.Exit(diag.ExitUsage)
+		}
+	}
+
+	// -noCache sends all of its headers, and the finer grained flags add to
+	// whatever else is asked for
+	noCacheWanted := map[string]bool{
+		"pragma":          pragmaNC,
+		"no-cache":        ccNoCache,
+		"no-store":        ccNoStore,
+		"must-revalidate": ccMustReval,
+	}
+	for _, name := range strings.Split(noCacheHdrs, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name == "" {
+			continue
+		}
+		if _, ok := noCacheWanted[name]; !ok && name != "expires" {
+			fmt.Fprintf(os.Stderr, "Unknown -noCacheHeaders header '%s': must be one of pragma, no-cache, no-store, must-revalidate or expires\n", name)
+			os.Exit(diag.ExitUsage)
+		}
+		noCacheWanted[name] = true
+	}
+	cacheHdrs := []string{}
+	for _, h := range diag.NoCacheHeaders {
+		if noCache || noCacheWanted[h.Name] {
+			cacheHdrs = append(cacheHdrs, h.Name)
+		}
+	}
+
+	if dns != "" && doh != "" {
+		fmt.Fprintln(os.Stderr, "Only one of -dns and -doh may be used")
+		os.Exit(diag.ExitUsage)
+	}
+
+	if compare != "" && (count > 1 || concurrency > 1 || outFile != "/dev/null") {
+		fmt.Fprintln(os.Stderr, "The -compare flag can't be used with -count, -concurrency or -outFile")
+		os.Exit(diag.ExitUsage)
+	}
+
+	if concurrency > 1 && outFile != "/dev/null" {
+		fmt.Fprintln(os.Stderr, "The -outFile flag can't be used with -concurrency")
+		os.Exit(diag.ExitUsage)
+	}
+
+	if headerOut != "" && (concurrency > 1 || compare != "" || uriFile != "" || watch > 0) {
+		fmt.Fprintln(os.Stderr, "The -headerOut flag can't be used with -concurrency, -compare, -uriFile or -watch")
+		os.Exit(diag.ExitUsage)
+	}
+
+	if reqDump && headerOut == "" {
+		fmt.Fprintln(os.Stderr, "The -reqDump flag can only be used with -headerOut")
+		os.Exit(diag.ExitUsage)
+	}
+
+	if basicAuth != "" || bearer != "" {
+		if (basicAuth != "" && bearer != "") || http.Header(headers).Get("Authorization") != "" {
+			fmt.Fprintln(os.Stderr, "Only one of -basicAuth, -bearer and an Authorization -header may be used")
+			os.Exit(diag.ExitUsage)
+		}
+		if basicAuth != "" && !strings.Contains(basicAuth, ":") {
+			fmt.Fprintln(os.Stderr, "The -basicAuth flag must be given as user:password")
+			os.Exit(diag.ExitUsage)
+		}
+		auth := "Bearer " + bearer
+		if basicAuth != "" {
+			auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(basicAuth))
+		}
+		http.Header(headers).Set("Authorization", auth)
+	}
+
+	if accept != "" {
+		if http.Header(headers).Get("Accept") != "" {
+			fmt.Fprintln(os.Stderr, "Only one of -accept and an Accept -header may be used")
+			os.Exit(diag.ExitUsage)
+		}
+		t, err := diag.AcceptHeader(accept)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(diag.ExitUsage)
+		}
+		if verifyCID && t != diag.GatewayFormats["raw"] {
+			fmt.Fprintln(os.Stderr, "The -verifyCID flag needs the raw block, so can only be used with -accept raw")
+			os.Exit(diag.ExitUsage)
+		}
+		http.Header(headers).Set("Accept", t)
+	}
+
+	redactHdrs := []string{}
+	for _, h := range strings.Split(redact, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			redactHdrs = append(redactHdrs, h)
+		}
+	}
+
+	if reportHdrs != "" {
+		// Set the Custom reporter up with the headers, and make sure
+		// it's run
+		hdrs := []string{}
+		for _, h := range strings.Split(reportHdrs, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				hdrs = append(hdrs, h)
+			}
+		}
+		diag.ReportersList["Custom"] = diag.CustomHeaderReporter{Headers: hdrs}
+		if reporters == "" {
+			reporters = "Custom"
+		} else if !strings.Contains(","+reporters+",", ",Custom,") {
+			reporters += ",Custom"
+		}
+	}
+
+	if geodb != "" {
+		for _, path := range strings.Split(geodb, ",") {
+			db, err := diag.OpenMmdb(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to open GeoIP database: %s\n", err)
+				os.Exit(diag.ExitUsage)
+			}
+			diag.GeoDbs = append(diag.GeoDbs, db)
+		}
+	}
+
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+
+	// Keep stdout for the body alone with -outFile - and -tee, and send the
+	// reporters and anything else that would have gone there to stderr
+	out := io.Writer(os.Stdout)
+	if outFile == "-" || tee {
+		out = os.Stderr
+	}
+
+	uris := []string{uri}
+	if uriFile != "" {
+		var err error
+		if uris, err = readUriFile(uriFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(diag.ExitUsage)
+		}
+	}
+	targets := []*target{}
+	for _, u := range uris {
+		t, err := newTarget(u, gateway, verifyCID)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(diag.ExitUsage)
+		}
+		targets = append(targets, t)
+	}
+	var compareTarget *target
+	if compare != "" {
+		var err error
+		if compareTarget, err = newTarget(compare, gateway, false); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(diag.ExitUsage)
+		}
+	}
+	if http3 {
+		reqUris := []string{}
+		for _, t := range targets {
+			reqUris = append(reqUris, t.uri)
+		}
+		if compareTarget != nil {
+			reqUris = append(reqUris, compareTarget.uri)
+		}
+		for _, u := range reqUris {
+			if !strings.HasPrefix(strings.ToLower(u), "https://") {
+				fmt.Fprintf(os.Stderr, "The -http3 flag only works with https:// URIs, but %s isn't one (for ipfs:// and ipns://, the -gateway needs to be https://)\n", u)
+				os.Exit(diag.ExitUsage)
+			}
+		}
+	}
+
+	// An explicit proxy overrides any from the environment. The transport
+	// itself knows how to talk to SOCKS5 proxies as well as HTTP(S) ones.
+	proxy := http.ProxyFromEnvironment
+	if proxyUri != "" {
+		u, err := url.Parse(proxyUri)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") || u.Host == "" {
+			fmt.Fprintln(os.Stderr, "The -proxy flag must be a http://, https:// or socks5:// URL")
+			os.Exit(diag.ExitUsage)
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	var nameResolver *net.Resolver
+	resolver := "system"
+	if dns != "" {
+		nameResolver = diag.NewDnsResolver(dns)
+		resolver = "DNS " + dns
+	}
+	if doh != "" {
+		nameResolver = diag.NewDohResolver(doh)
+		resolver = "DoH " + doh
+	}
+	network := ""
+	if ipv4 {
+		network = "tcp4"
+	} else if ipv6 {
+		network = "tcp6"
+	}
+	if insecure {
+		// Make sure this can't go unnoticed, even with -quiet
+		diag.LogError("WARNING: TLS certificate verification is disabled with -insecure")
+	}
+	opts := diag.Options{
+		Version:   version,
+		NoCache:   cacheHdrs,
+		OutFile:   outFile,
+		Tee:       tee,
+		HeaderOut: headerOut,
+		ReqDump:   reqDump,
+		Timeout:   timeout,
+		Reuse:     reuse,
+		Resolver:  resolver,
+		Range:     byteRange,
+		Resume:    resume,
+		Method:    strings.ToUpper(method),
+		Body:      body,
+		Headers:   http.Header(headers),
+		// Progress bars from several requests at once would just be
+		// a mess, and are only any use to someone watching.
+		Progress:       !quiet && concurrency == 1 && !bench && diag.IsTerminal(os.Stderr),
+		Sha256:         sha256Sum,
+		Md5:            md5Sum,
+		Retries:        retries,
+		RetryDelay:     retryDelay,
+		ForceRetry:     forceRetry,
+		Proxy:          proxy,
+		Insecure:       insecure,
+		ClientCert:     cert != nil,
+		Certificate:    cert,
+		DialTimeout:    dialTime,
+		TlsTimeout:     tlsTime,
+		Network:        network,
+		Http3:          http3,
+		NameResolver:   nameResolver,
+		TlsMin:         uint16(tlsMin),
+		TlsMax:         uint16(tlsMax),
+		CipherSuites:   cipherSuites,
+		Resolve:        resolves,
+		StallThreshold: stallTime,
+		NoCompress:     noCompress,
+		NoKeepAlive:    noKeepAlive,
+		MaxBytes:       maxBytes,
+		FailOn:         failOn,
+		TtfbOnly:       ttfbOnly,
+		UserAgent:      userAgent,
+		MaxRedirects:   &maxRedirect,
+		Redact:         redactHdrs,
+	}
+	transport := diag.NewTransport(opts)
+	// Interrupting stops whatever requests are being made, which is also
+	// the way to stop -watch
+	ctx := interruptContext()
+	if ptr {
+		// Use the same resolver as for everything else
+		opts.PtrResolver = net.DefaultResolver
+		if nameResolver != nil {
+			opts.PtrResolver = nameResolver
+		}
+	}
+	if cname {
+		opts.CnameResolver = net.DefaultResolver
+		if nameResolver != nil {
+			opts.CnameResolver = nameResolver
+		}
+	}
+	// Only used for ipns:// URIs with a DNS name, where it's worth the
+	// extra lookup to see what the gateway should have found
+	opts.DnsLinkResolver = net.DefaultResolver
+	if nameResolver != nil {
+		opts.DnsLinkResolver = nameResolver
+	}
+
+	// Each worker makes at least one request
+	total := count
+	if total < concurrency {
+		total = concurrency
+	}
+
+	// Exit with the code of the last failure, if there was one
+	code := diag.ExitOK
+	var all []*diag.StatsCollector
+	var allErrs []error
+	for i, t := range targets {
+		if len(targets) > 1 {
+			diag.LogInfo("Requesting URI %d of %d: %s", i+1, len(targets), t.uri)
+		}
+		o := opts
+		o.VerifyCid = t.verify
+		if warmup > 0 {
+			diag.WarmUp(ctx, transport, t.uri, o, t.ipfs, warmup)
+			if compareTarget != nil {
+				diag.WarmUp(ctx, transport, compareTarget.uri, opts, compareTarget.ipfs, warmup)
+			}
+		}
+
+		start := time.Now()
+		if watch > 0 {
+			// Export each probe as it's made, as there's no end
+			// to wait for
+			t.runs, t.errs = diag.WatchRequests(ctx, transport, t.uri, o, t.ipfs, watch, func(s *diag.StatsCollector, err error) {
+				if format == "table" || format == "plain" {
+					fmt.Fprintln(out, diag.WatchLine(s, err))
+				}
+				exportRuns([]*diag.StatsCollector{s}, []error{err}, influxUrl, otlp)
+			})
+		} else if compareTarget == nil {
+			t.runs, t.errs = diag.RunRequests(ctx, transport, t.uri, o, t.ipfs, total, concurrency)
+		} else {
+			// One after the other, so they don't compete for bandwidth
+			t.runs, t.errs = diag.RunRequests(ctx, transport, t.uri, o, t.ipfs, 1, 1)
+			r, e := diag.RunRequests(ctx, transport, compareTarget.uri, opts, compareTarget.ipfs, 1, 1)
+			t.runs, t.errs = append(t.runs, r...), append(t.errs, e...)
+		}
+		t.elapsed = time.Since(start)
+
+		for _, err := range t.errs {
+			if err != nil {
+				code = diag.ExitCode(err)
+			}
+		}
+		all, allErrs = append(all, t.runs...), append(allErrs, t.errs...)
+	}
+
+	reqReporters := []string{}
+	if reporters != "" {
+		reqReporters = strings.Split(reporters, ",")
+	}
+
+	var doc interface{}
+	if jsonOut != "" || format == "json" {
+		doc = jsonDocument(reqReporters, all, allErrs)
+	}
+	writeRuns(out, doc, jsonOut)
+	if watch == 0 {
+		exportRuns(all, allErrs, influxUrl, otlp)
+	}
+
+	if format == "json" {
+		if err := writeStats(out, doc, "-"); err != nil {
+			diag.LogError("Unable to write reports: %s", err)
+		}
+		os.Exit(code)
+	}
+	if format == "csv" {
+		writeCsv(out, all, allErrs)
+		os.Exit(code)
+	}
+
+	if watch > 0 {
+		t := targets[0]
+		fmt.Fprintln(out, "")
+		fmt.Fprintln(out, diag.WatchReport(t.runs, t.errs, t.elapsed))
+		os.Exit(code)
+	}
+
+	// A metric may only appear once in the Prometheus format, so with more
+	// than one run, they're all written out together at the end. If that's
+	// all that was asked for, nothing else is, as it's likely going to a file.
+	runNames, prom := reqReporters, false
+	if len(all) > 1 {
+		runNames = []string{}
+		for _, r := range reqReporters {
+			if r == "Prom" {
+				prom = true
+			} else {
+				runNames = append(runNames, r)
+			}
+		}
+	}
+	promOnly := prom && len(runNames) == 0 && retries == 0 && compare == ""
+
+	if bench {
+		for i, t := range targets {
+			fmt.Fprintln(out, "")
+			if len(targets) > 1 {
+				fmt.Fprintf(out, "URI %d of %d: %s\n\n", i+1, len(targets), t.uri)
+			}
+			fmt.Fprint(out, diag.BenchReport(t.runs, t.errs, t.elapsed, concurrency))
+		}
+		fmt.Fprintln(out, "")
+	} else if promOnly {
+		// Just the metrics, so they can go straight to a file
+		fmt.Fprint(out, diag.PrometheusReport(reportableRuns(all)))
+	} else if reporters != "" || total > 1 || retries > 0 || compare != "" {
+		// Now process reporters
+		fmt.Fprintln(out, "")
+		for i, t := range targets {
+			if len(targets) > 1 {
+				fmt.Fprintf(out, "URI %d of %d: %s\n\n", i+1, len(targets), t.uri)
+			}
+			printRuns(out, runNames, format, t, total, concurrency, reuse, retries, compare != "")
+		}
+		if prom {
+			fmt.Fprint(out, diag.PrometheusReport(reportableRuns(all)))
+		}
+	}
+	if len(targets) > 1 && !promOnly {
+		fmt.Fprintln(out, BatchReport(targets))
+	}
+	if rank {
+		entries := []*diag.RankEntry{}
+		label := "URI"
+		if len(targets) > 1 {
+			for _, t := range targets {
+				entries = append(entries, diag.NewRankEntry(t.uri, t.runs, t.errs))
+			}
+		} else {
+			label = "Run"
+			t := targets[0]
+			for i := range t.runs {
+				entries = append(entries, diag.NewRankEntry(fmt.Sprintf("%d", i+1), t.runs[i:i+1], t.errs[i:i+1]))
+			}
+		}
+		fmt.Fprintln(out, "Ranking")
+		fmt.Fprintln(out, diag.RankReport(entries, label))
+	}
+
+	os.Exit(code)
+}
+
+// Return a context that's cancelled on the first Ctrl-C (or SIGTERM), so that
+// a request part way through stops and what it got so far can still be
+// reported. A second one exits straight away as usual.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		signal.Stop(sig)
+		diag.LogError("Interrupted, stopping (interrupt again to exit straight away)")
+		cancel()
+	}()
+	return ctx
+}
+
+// Post the runs to InfluxDB and export them as traces, if asked to with
+// -influxUrl and -otlp
+func exportRuns(runs []*diag.StatsCollector, errs []error, influxUrl string, otlp string) {
+	if influxUrl != "" {
+		if err := diag.PostInflux(runs, errs, influxUrl); err != nil {
+			diag.LogError("Unable to post results to InfluxDB: %s", err)
+		}
+	}
+	if otlp != "" {
+		if err := diag.ExportOtlp(runs, otlp); err != nil {
+			diag.LogError("Unable to export traces to %s: %s", otlp, err)
+		}
 	}
+}
+
+// target is a URI to be requested, along with the runs made for it
+type target struct {
+	uri string
+	// ipfs is the original ipfs:// or ipns:// URI, if one was given, and
+	// verify the CID to check the content against with -verifyCID
+	ipfs   *diag.IpfsUri
+	verify *diag.Cid
 
-	httpStats.Start()
-	if _, err = io.Copy(out, io.TeeReader(resp.Body, httpStats)); err != nil {
-		out.Close()
-		panic(err)
+	runs    []*diag.StatsCollector
+	errs    []error
+	elapsed time.Duration
+}
+
+// Set up a target for a URI given on the command line or in a -uriFile,
+// checking that it can be verified if verifyCid is set.
+func newTarget(uri string, gateway string, verifyCid bool) (*target, error) {
+	u, ipfs, err := diag.RequestUri(uri, gateway)
+	if err != nil {
+		return nil, err
 	}
-	httpStats.Stop()
-	log.Printf("Total transferred: %d in %d (%f kB/s)\n",
-		httpStats.TotalBytesTransferred(), httpStats.DurationNS(),
-		float64(httpStats.TotalBytesTransferred())/float64(httpStats.DurationNS())*float64(1000000000)/float64(1024))
+	t := &target{uri: u, ipfs: ipfs}
+	if verifyCid {
+		if ipfs == nil || ipfs.Namespace != "ipfs" || strings.Trim(ipfs.Path, "/") != "" {
+			return nil, fmt.Errorf("The -verifyCID flag needs an ipfs:// URI with no path, not %s", uri)
+		}
+		if t.verify, err = diag.ParseCid(ipfs.Cid); err == nil {
+			_, err = t.verify.NewHash()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Unable to verify %s: %s", ipfs.Cid, err)
+		}
+	}
+	return t, nil
+}
 
-	// Write a copy of the JSON representation of the stats to the log
-	j, err := json.Marshal(httpStats)
+// Read the URIs from a -uriFile, one per line. Blank lines and comments
+// starting with # are skipped.
+func readUriFile(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("Unable to read '%s': %w", path, err)
+	}
+	uris := []string{}
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		uris = append(uris, line)
+	}
+	if len(uris) == 0 {
+		return nil, fmt.Errorf("No URIs found in '%s'", path)
+	}
+	return uris, nil
+}
+
+// Print the reporters for each of the runs made for a target, followed by a
+// comparison or summary of them where there's more than one.
+func printRuns(w io.Writer, names []string, format string, t *target, total int, concurrency int, reuse bool, retries int, compare bool) {
+	runs, errs := t.runs, t.errs
+	if len(names) > 0 || retries > 0 {
+		for i, httpStats := range runs {
+			if total > 1 {
+				fmt.Fprintf(w, "Run %d of %d\n\n", i+1, total)
+			}
+			if compare {
+				fmt.Fprintf(w, "%s: %s\n\n", []string{"First", "Second"}[i], httpStats.Uri)
+			}
+			if retries > 0 {
+				// Tell flaky apart from down
+				result := "succeeded"
+				if errs[i] != nil {
+					result = "failed"
+				}
+				fmt.Fprintf(w, "Made %d attempt(s), the last of which %s\n\n", httpStats.Attempt, result)
+			}
+			if errs[i] != nil {
+				fmt.Fprintf(w, "Run failed: %s\n\n", errs[i])
+			}
+			if !diag.Reportable(httpStats) {
+				// There's nothing to report on
+				continue
+			}
+			runReporters(w, names, format, httpStats)
+		}
 	}
-	log.Println(string(j))
 
-	out.Close()
+	if compare {
+		fmt.Fprintln(w, "Comparison")
+		fmt.Fprintln(w, diag.CompareReport(runs[0], runs[1]))
+	} else if total > 1 {
+		bytes := uint64(0)
+		failed := 0
+		for i, s := range runs {
+			bytes += s.TotalBytesTransferred()
+			if errs[i] != nil {
+				failed++
+			}
+		}
+		elapsed := t.elapsed
+		fmt.Fprintf(w, "Summary of %d runs\n", total)
+		fmt.Fprintln(w, diag.AggregateReport(runs))
+		fmt.Fprintf(w, "%d requests (%d failed) in %s using %d worker(s): %f requests/s, %s overall\n\n",
+			total, failed, diag.SecondsText(elapsed.Seconds()), concurrency,
+			float64(total)/elapsed.Seconds(),
+			diag.RateText(float64(bytes)/elapsed.Seconds()/float64(1024)))
+		if retries > 0 {
+			retried := 0
+			for _, s := range runs {
+				if s.Attempt > 1 {
+					retried++
+				}
+			}
+			fmt.Fprintf(w, "%d run(s) needed more than one attempt\n\n", retried)
+		}
+		for _, r := range names {
+			if r == "Saturn" {
+				fmt.Fprintln(w, "Saturn cache status across runs")
+				fmt.Fprintln(w, diag.SaturnSummary(runs))
+			}
+			if r == "KeepAlive" && concurrency == 1 {
+				// Runs on several workers each have their own
+				// connection, so wouldn't follow on from each other
+				fmt.Fprintln(w, "Connection reuse across runs")
+				fmt.Fprintln(w, diag.KeepAliveSummary(runs, reuse))
+			}
+		}
+	}
+}
 
-	if reporters == "" {
-		os.Exit(0)
+// Return the runs that got far enough to be reported on
+func reportableRuns(runs []*diag.StatsCollector) []*diag.StatsCollector {
+	ret := []*diag.StatsCollector{}
+	for _, s := range runs {
+		if diag.Reportable(s) {
+			ret = append(ret, s)
+		}
 	}
+	return ret
+}
+
+// Check that s is a hex encoded digest of the given size in bytes
+func hexDigest(s string, size int) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == size
+}
 
-	// Now process reporters. TODO: call new() and create array, and then
-	// loop through each.
-	fmt.Println("")
-	reqReporters := strings.Split(reporters, ",")
-	for _, rep := range reqReporters {
-		if r, ok := reportersList[rep]; ok {
-			cr, err := r.Report(httpStats)
-			if err == nil {
-				fmt.Printf("%s: %s\n", rep, r.Title())
-				fmt.Println(r.Description())
-				fmt.Println(cr)
-				fmt.Println("")
+// Call each of the named reporters on the given stats, printing the results.
+// With -format plain, reporters that print tables give their data as
+// "key: value" lines instead.
+func runReporters(w io.Writer, names []string, format string, s *diag.StatsCollector) {
+	for _, rep := range names {
+		if r, ok := diag.ReportersList[rep]; ok {
+			raw, ok := r.(diag.RawReporter)
+			isRaw := ok && raw.Raw()
+			cr, err := "", error(nil)
+			if format == "plain" && !isRaw {
+				cr, err = diag.PlainReport(r, s)
 			} else {
-				fmt.Printf("Reporter %s failed: %s\n", rep, err)
+				cr, err = r.Report(s)
 			}
+			if err == nil && isRaw {
+				fmt.Fprint(w, cr)
+			} else if err == nil {
+				fmt.Fprintf(w, "%s: %s\n", rep, r.Title())
+				fmt.Fprintln(w, r.Description())
+				fmt.Fprintln(w, cr)
+				fmt.Fprintln(w, "")
+			} else {
+				fmt.Fprintf(w, "Reporter %s failed: %s\n", rep, err)
+			}
+		} else {
+			diag.LogError("Unknown reporter '%s'", rep)
+		}
+	}
+}
+
+// Gather the structured data from each of the named reporters, keyed by
+// reporter name. Reporters that fail have their error recorded instead.
+func reportData(names []string, s *diag.StatsCollector) map[string]interface{} {
+	ret := map[string]interface{}{}
+	for _, rep := range names {
+		r, ok := diag.ReportersList[rep]
+		if !ok {
+			diag.LogError("Unknown reporter '%s'", rep)
+			continue
+		}
+		if d, err := r.Data(s); err != nil {
+			ret[rep] = map[string]interface{}{"Error": err.Error()}
 		} else {
-			log.Printf("Unknown reporter '%s'", rep)
+			ret[rep] = d
+		}
+	}
+	return ret
+}
+
+// jsonSchemaVersion is the version of the JSON documents written with -jsonOut
+// and -format json. It goes up whenever a field is renamed or removed, or its
+// meaning changes, so that anything reading them can tell; adding a field
+// doesn't change it.
+const jsonSchemaVersion = 1
+
+// jsonEnvelope is the JSON document for a run, with the stats and the data
+// from any reporters asked for. Error is why the run failed, if it did, and
+// Reporters is empty if it failed before there was anything to report on.
+type jsonEnvelope struct {
+	SchemaVersion int                    `json:"schemaVersion"`
+	Version       string                 `json:"version"`
+	Uri           string                 `json:"uri"`
+	Error         *string                `json:"error"`
+	Stats         *diag.StatsCollector   `json:"stats"`
+	Reporters     map[string]interface{} `json:"reporters"`
+}
+
+// Build the JSON document for the runs, for -jsonOut and -format json. A
+// single run is an object, and multiple runs an array.
+func jsonDocument(names []string, runs []*diag.StatsCollector, errs []error) interface{} {
+	docs := []jsonEnvelope{}
+	for i, s := range runs {
+		d := jsonEnvelope{
+			SchemaVersion: jsonSchemaVersion,
+			Version:       version,
+			Uri:           s.Uri,
+			Stats:         s,
+			Reporters:     map[string]interface{}{},
 		}
+		if errs[i] != nil {
+			e := errs[i].Error()
+			d.Error = &e
+		}
+		if diag.Reportable(s) {
+			d.Reporters = reportData(names, s)
+		}
+		docs = append(docs, d)
+	}
+	if len(docs) == 1 {
+		return docs[0]
+	}
+	return docs
+}
+
+// The columns written with -format csv
+var csvHeader = []string{"uri", "dns_s", "connect_s", "tls_s", "ttfb_s", "transfer_s",
+	"total_bytes", "throughput_kbps", "status_code", "protocol", "error"}
+
+// Write a header and then a row for each run to w as CSV, for loading
+// into a spreadsheet. Phases that didn't happen are left empty.
+func writeCsv(w io.Writer, runs []*diag.StatsCollector, errs []error) {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for i, s := range runs {
+		row := []string{s.Uri}
+		for _, p := range []diag.Phase{diag.PhaseDns, diag.PhaseConnect, diag.PhaseTls, diag.PhaseFirstByte, diag.PhaseTransfer} {
+			if d, ok := s.PhaseDuration(p); ok {
+				row = append(row, fmt.Sprintf("%f", d.Seconds()))
+			} else {
+				row = append(row, "")
+			}
+		}
+		status, failure := "", ""
+		if s.StatusCode != 0 {
+			status = fmt.Sprintf("%d", s.StatusCode)
+		}
+		if errs[i] != nil {
+			failure = errs[i].Error()
+		}
+		bytes, rate := fmt.Sprintf("%d", s.TotalBytesTransferred()), fmt.Sprintf("%f", s.KBPerSecond())
+		if s.TransferSkipped {
+			bytes, rate = "", ""
+		}
+		row = append(row, bytes, rate, status, s.Proto, failure)
+		cw.Write(row)
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		diag.LogError("Unable to write CSV: %s", err)
+	}
+}
+
+// Write the JSON document for the runs to the -jsonOut file, if one was given
+func writeRuns(w io.Writer, doc interface{}, name string) {
+	if name == "" {
+		return
+	}
+	if err := writeStats(w, doc, name); err != nil {
+		diag.LogError("Unable to write stats to '%s': %s", name, err)
+	}
+}
+
+// Write the stats as pretty-printed JSON to the named file, or to w if the
+// name is "-".
+func writeStats(w io.Writer, v interface{}, name string) error {
+	j, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	j = append(j, '\n')
+
+	if name == "-" {
+		_, err = w.Write(j)
+		return err
 	}
+	return os.WriteFile(name, j, 0644)
 }
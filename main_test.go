@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mattgeddes/web3diag/diag"
+)
+
+func TestTeeSeparatesBodyAndReport(t *testing.T) {
+	body := strings.Repeat("body ", 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	var stdout, report bytes.Buffer
+	outFile := filepath.Join(t.TempDir(), "body")
+	s, err := diag.Probe(context.Background(), diag.Options{
+		Uri:     srv.URL,
+		OutFile: outFile,
+		Tee:     true,
+		Stdout:  &stdout,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	printRuns(&report, []string{"Digest"}, "table", &target{uri: srv.URL, runs: []*diag.StatsCollector{s}, errs: []error{nil}},
+		1, 1, true, 0, false)
+
+	if stdout.String() != body {
+		t.Errorf("got %d bytes on stdout, want just the %d of the body", stdout.Len(), len(body))
+	}
+	if b, err := os.ReadFile(outFile); err != nil || string(b) != body {
+		t.Errorf("got %d bytes in -outFile (%v), want the %d of the body", len(b), err, len(body))
+	}
+	if !strings.Contains(report.String(), "Digest: ") || strings.Contains(report.String(), "body ") {
+		t.Errorf("want the report, and only the report, on its own stream, got %q", report.String())
+	}
+}